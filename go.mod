@@ -6,6 +6,7 @@ require (
 	github.com/magiconair/properties v1.8.0
 	github.com/operator-framework/operator-sdk v0.18.2
 	github.com/parflesh/sonarqube-operator v0.0.0-20200608154349-0979d6cffde2 // indirect
+	github.com/prometheus/client_golang v1.5.1
 	github.com/spf13/pflag v1.0.5
 	golang.org/x/mod v0.2.0
 	k8s.io/api v0.18.2
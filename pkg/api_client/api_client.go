@@ -1,39 +1,53 @@
 package api_client
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 )
 
 type APIProvider interface {
-	New(URL string) APIReader
+	New(URL string, credentials *Credentials, tlsConfig *tls.Config) APIReader
 }
 
 type APIReader interface {
 	Ping() error
 	Status() (*Status, error)
 	Upgrades() (*Upgrades, error)
+	Health() (*Health, error)
+	InstalledPlugins() (*InstalledPlugins, error)
+	MigrateDB() (*DBMigrationStatus, error)
+	DBMigrationStatus() (*DBMigrationStatus, error)
+	ChangePassword(login, previousPassword, password string) error
+	GenerateToken(name string) (*UserToken, error)
+	RevokeToken(name string) error
 }
 
 type APIClient struct {
-	URL    string
-	Client *http.Client
+	URL         string
+	Credentials *Credentials
+	Client      *http.Client
 }
 
-func (r *APIClient) New(URL string) APIReader {
+// New returns a client of the API at URL, https endpoints are verified with tlsConfig or the system roots when nil
+func (r *APIClient) New(URL string, credentials *Credentials, tlsConfig *tls.Config) APIReader {
 	var netTransport = &http.Transport{
 		DialContext: (&net.Dialer{
 			Timeout: 5 * time.Second,
 		}).DialContext,
 		TLSHandshakeTimeout: 5 * time.Second,
+		TLSClientConfig:     tlsConfig,
 	}
 
 	return &APIClient{
-		URL: URL,
+		URL:         URL,
+		Credentials: credentials,
 		Client: &http.Client{
 			Timeout:   time.Second * 10,
 			Transport: netTransport,
@@ -46,12 +60,12 @@ func (r *APIClient) Ping() error {
 	if err != nil {
 		return err
 	}
+	defer res.Body.Close()
+
 	if res.StatusCode != 200 {
 		return fmt.Errorf("non 200 error code returned")
 	}
 
-	defer res.Body.Close()
-
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		return err
@@ -70,12 +84,12 @@ func (r *APIClient) Status() (*Status, error) {
 	if err != nil {
 		return output, err
 	}
+	defer res.Body.Close()
+
 	if res.StatusCode != 200 {
 		return output, fmt.Errorf("non 200 error code returned")
 	}
 
-	defer res.Body.Close()
-
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		return output, err
@@ -95,12 +109,37 @@ func (r *APIClient) Upgrades() (*Upgrades, error) {
 	if err != nil {
 		return output, err
 	}
+	defer res.Body.Close()
+
 	if res.StatusCode != 200 {
 		return output, fmt.Errorf("non 200 error code returned")
 	}
 
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return output, err
+	}
+
+	err = json.Unmarshal(body, output)
+	if err != nil {
+		return output, err
+	}
+
+	return output, nil
+}
+
+func (r *APIClient) Health() (*Health, error) {
+	output := &Health{}
+	res, err := r.get("system", "health")
+	if err != nil {
+		return output, err
+	}
 	defer res.Body.Close()
 
+	if res.StatusCode != 200 {
+		return output, fmt.Errorf("non 200 error code returned")
+	}
+
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		return output, err
@@ -114,7 +153,190 @@ func (r *APIClient) Upgrades() (*Upgrades, error) {
 	return output, nil
 }
 
+func (r *APIClient) InstalledPlugins() (*InstalledPlugins, error) {
+	output := &InstalledPlugins{}
+	res, err := r.get("plugins", "installed")
+	if err != nil {
+		return output, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return output, fmt.Errorf("non 200 error code returned")
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return output, err
+	}
+
+	err = json.Unmarshal(body, output)
+	if err != nil {
+		return output, err
+	}
+
+	return output, nil
+}
+
+func (r *APIClient) MigrateDB() (*DBMigrationStatus, error) {
+	output := &DBMigrationStatus{}
+	res, err := r.post("system", "migrate_db", nil)
+	if err != nil {
+		return output, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return output, fmt.Errorf("non 200 error code returned")
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return output, err
+	}
+
+	err = json.Unmarshal(body, output)
+	if err != nil {
+		return output, err
+	}
+
+	return output, nil
+}
+
+func (r *APIClient) DBMigrationStatus() (*DBMigrationStatus, error) {
+	output := &DBMigrationStatus{}
+	res, err := r.get("system", "db_migration_status")
+	if err != nil {
+		return output, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return output, fmt.Errorf("non 200 error code returned")
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return output, err
+	}
+
+	err = json.Unmarshal(body, output)
+	if err != nil {
+		return output, err
+	}
+
+	return output, nil
+}
+
+func (r *APIClient) ChangePassword(login, previousPassword, password string) error {
+	res, err := r.post("users", "change_password", url.Values{
+		"login":            {login},
+		"previousPassword": {previousPassword},
+		"password":         {password},
+	})
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 && res.StatusCode != 204 {
+		return fmt.Errorf("non 200 error code returned")
+	}
+
+	return nil
+}
+
+func (r *APIClient) GenerateToken(name string) (*UserToken, error) {
+	output := &UserToken{}
+	res, err := r.post("user_tokens", "generate", url.Values{
+		"name": {name},
+	})
+	if err != nil {
+		return output, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return output, fmt.Errorf("non 200 error code returned")
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return output, err
+	}
+
+	err = json.Unmarshal(body, output)
+	if err != nil {
+		return output, err
+	}
+
+	return output, nil
+}
+
+// RevokeToken revokes the token named name of the user of the credentials, revoking a token that doesn't exist succeeds
+func (r *APIClient) RevokeToken(name string) error {
+	res, err := r.post("user_tokens", "revoke", url.Values{
+		"name": {name},
+	})
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 && res.StatusCode != 204 {
+		return fmt.Errorf("non 200 error code returned")
+	}
+
+	return nil
+}
+
 func (r *APIClient) get(domain, object string) (*http.Response, error) {
-	url := fmt.Sprintf("%s/api/%s/%s", r.URL, domain, object)
-	return r.Client.Get(url)
+	endpoint := fmt.Sprintf("/api/%s/%s", domain, object)
+	req, err := http.NewRequest(http.MethodGet, r.URL+endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	return r.do(endpoint, req)
+}
+
+func (r *APIClient) post(domain, object string, params url.Values) (*http.Response, error) {
+	endpoint := fmt.Sprintf("/api/%s/%s", domain, object)
+	req, err := http.NewRequest(http.MethodPost, r.URL+endpoint, strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r.do(endpoint, req)
+}
+
+// do sends req with the credentials of the client, the request is observed in the metrics of endpoint
+func (r *APIClient) do(endpoint string, req *http.Request) (*http.Response, error) {
+	if r.Credentials != nil {
+		if r.Credentials.Token != "" {
+			// Tokens are sent as the login with an empty password
+			req.SetBasicAuth(r.Credentials.Token, "")
+		} else {
+			req.SetBasicAuth(r.Credentials.Username, r.Credentials.Password)
+		}
+	}
+
+	start := time.Now()
+	res, err := r.Client.Do(req)
+	if err != nil {
+		observeRequest(endpoint, start, 0, err)
+		return res, err
+	}
+	observeRequest(endpoint, start, res.StatusCode, nil)
+
+	if res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden {
+		res.Body.Close()
+		return nil, &AuthenticationError{
+			StatusCode: res.StatusCode,
+			Path:       req.URL.Path,
+		}
+	}
+
+	return res, nil
 }
@@ -1,14 +1,32 @@
 package api_client
 
+import "crypto/tls"
+
 type APIClientMock struct {
-	PingError      error
-	InfoOutput     *Status
-	InfoError      error
-	UpgradesOutput *Upgrades
-	UpgradesError  error
+	Credentials             *Credentials
+	TLSConfig               *tls.Config
+	PingError               error
+	InfoOutput              *Status
+	InfoError               error
+	UpgradesOutput          *Upgrades
+	UpgradesError           error
+	HealthOutput            *Health
+	HealthError             error
+	InstalledPluginsOutput  *InstalledPlugins
+	InstalledPluginsError   error
+	MigrateDBOutput         *DBMigrationStatus
+	MigrateDBError          error
+	DBMigrationStatusOutput *DBMigrationStatus
+	DBMigrationStatusError  error
+	ChangePasswordError     error
+	GenerateTokenOutput     *UserToken
+	GenerateTokenError      error
+	RevokeTokenError        error
 }
 
-func (r *APIClientMock) New(string) APIReader {
+func (r *APIClientMock) New(_ string, credentials *Credentials, tlsConfig *tls.Config) APIReader {
+	r.Credentials = credentials
+	r.TLSConfig = tlsConfig
 	return r
 }
 
@@ -23,3 +41,31 @@ func (r *APIClientMock) Status() (*Status, error) {
 func (r *APIClientMock) Upgrades() (*Upgrades, error) {
 	return r.UpgradesOutput, r.UpgradesError
 }
+
+func (r *APIClientMock) Health() (*Health, error) {
+	return r.HealthOutput, r.HealthError
+}
+
+func (r *APIClientMock) InstalledPlugins() (*InstalledPlugins, error) {
+	return r.InstalledPluginsOutput, r.InstalledPluginsError
+}
+
+func (r *APIClientMock) MigrateDB() (*DBMigrationStatus, error) {
+	return r.MigrateDBOutput, r.MigrateDBError
+}
+
+func (r *APIClientMock) DBMigrationStatus() (*DBMigrationStatus, error) {
+	return r.DBMigrationStatusOutput, r.DBMigrationStatusError
+}
+
+func (r *APIClientMock) ChangePassword(string, string, string) error {
+	return r.ChangePasswordError
+}
+
+func (r *APIClientMock) GenerateToken(string) (*UserToken, error) {
+	return r.GenerateTokenOutput, r.GenerateTokenError
+}
+
+func (r *APIClientMock) RevokeToken(string) error {
+	return r.RevokeTokenError
+}
@@ -0,0 +1,192 @@
+package api_client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestAPIClientCredentials verifies credentials are sent with every request
+func TestAPIClientCredentials(t *testing.T) {
+	tests := []struct {
+		credentials        *Credentials
+		username, password string
+	}{
+		{&Credentials{Token: "token"}, "token", ""},
+		{&Credentials{Username: "admin", Password: "secret"}, "admin", "secret"},
+	}
+
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			username, password, ok := req.BasicAuth()
+			if !ok || username != test.username || password != test.password {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"state":"NO_MIGRATION"}`))
+		}))
+
+		apiClient := (&APIClient{}).New(server.URL, test.credentials, nil)
+
+		if _, err := apiClient.DBMigrationStatus(); err != nil {
+			t.Errorf("get: credentials not sent (%v)", err)
+		}
+		if _, err := apiClient.MigrateDB(); err != nil {
+			t.Errorf("post: credentials not sent (%v)", err)
+		}
+
+		server.Close()
+	}
+}
+
+// TestAPIClientUnauthorized verifies rejected requests return AuthenticationError
+func TestAPIClientUnauthorized(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	apiClient := (&APIClient{}).New(server.URL, nil, nil)
+
+	_, err := apiClient.Upgrades()
+	if authErr, ok := err.(*AuthenticationError); !ok {
+		t.Errorf("upgrades: authentication error not returned (%v)", err)
+	} else if authErr.StatusCode != http.StatusUnauthorized || authErr.Path != "/api/system/upgrades" {
+		t.Errorf("upgrades: unexpected authentication error (%v)", authErr)
+	}
+}
+
+// closeRecorder is a response body recording whether it was closed
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (b *closeRecorder) Close() error {
+	b.closed = true
+	return nil
+}
+
+// errorTransport answers every request with a server error
+type errorTransport struct {
+	bodies []*closeRecorder
+}
+
+func (t *errorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := &closeRecorder{Reader: strings.NewReader("error")}
+	t.bodies = append(t.bodies, body)
+	return &http.Response{StatusCode: http.StatusInternalServerError, Body: body, Request: req}, nil
+}
+
+// TestAPIClientErrorResponse verifies the bodies of error responses are closed
+func TestAPIClientErrorResponse(t *testing.T) {
+	transport := &errorTransport{}
+	apiClient := &APIClient{URL: "http://sonarqube", Client: &http.Client{Transport: transport}}
+
+	calls := map[string]func() error{
+		"ping":                func() error { return apiClient.Ping() },
+		"status":              func() error { _, err := apiClient.Status(); return err },
+		"upgrades":            func() error { _, err := apiClient.Upgrades(); return err },
+		"health":              func() error { _, err := apiClient.Health(); return err },
+		"installed plugins":   func() error { _, err := apiClient.InstalledPlugins(); return err },
+		"migrate db":          func() error { _, err := apiClient.MigrateDB(); return err },
+		"db migration status": func() error { _, err := apiClient.DBMigrationStatus(); return err },
+		"change password":     func() error { return apiClient.ChangePassword("admin", "admin", "secret") },
+		"generate token":      func() error { _, err := apiClient.GenerateToken("operator"); return err },
+		"revoke token":        func() error { return apiClient.RevokeToken("operator") },
+	}
+	for name, call := range calls {
+		if err := call(); err == nil {
+			t.Errorf("%s: error not returned for server error", name)
+		}
+		if body := transport.bodies[len(transport.bodies)-1]; !body.closed {
+			t.Errorf("%s: body of error response not closed", name)
+		}
+	}
+}
+
+// TestAPIClientHealth verifies the health of a cluster is decoded with its nodes
+func TestAPIClientHealth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/api/system/health" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"health":"RED","causes":[{"message":"search cluster is RED"}],"nodes":[` +
+			`{"name":"app-1","type":"APPLICATION","host":"10.0.0.1","port":9003,"startedAt":"2020-06-01T12:00:00+0000","health":"GREEN","causes":[]},` +
+			`{"name":"search-1","type":"SEARCH","host":"10.0.0.2","port":9001,"startedAt":"2020-06-01T12:00:00+0000","health":"RED","causes":[{"message":"shards unassigned"}]}]}`))
+	}))
+	defer server.Close()
+
+	health, err := (&APIClient{}).New(server.URL, nil, nil).Health()
+	if err != nil {
+		t.Fatalf("health: (%v)", err)
+	}
+	if health.Health != HealthRed || len(health.Causes) != 1 {
+		t.Errorf("health: unexpected overall health %v", health)
+	}
+	if len(health.Nodes) != 2 || health.Nodes[1].Type != NodeSearch || health.Nodes[1].Health != HealthRed || health.Nodes[1].Causes[0].Message != "shards unassigned" {
+		t.Errorf("health: unexpected node health %v", health.Nodes)
+	}
+}
+
+// TestAPIClientTLS verifies https endpoints are verified with the CA in the tls config
+func TestAPIClientTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("pong"))
+	}))
+	defer server.Close()
+
+	if err := (&APIClient{}).New(server.URL, nil, nil).Ping(); err == nil {
+		t.Error("ping: certificate signed by an unknown authority accepted")
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	if err := (&APIClient{}).New(server.URL, nil, &tls.Config{RootCAs: pool}).Ping(); err != nil {
+		t.Errorf("ping: certificate signed by the CA in the tls config rejected (%v)", err)
+	}
+
+	if err := (&APIClient{}).New(server.URL, nil, &tls.Config{InsecureSkipVerify: true}).Ping(); err != nil {
+		t.Errorf("ping: certificate rejected with verification skipped (%v)", err)
+	}
+}
+
+// TestAPIClientMetrics verifies requests are observed by endpoint and failed requests are counted
+func TestAPIClientMetrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/api/system/upgrades" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("pong"))
+	}))
+	defer server.Close()
+
+	apiClient := (&APIClient{}).New(server.URL, nil, nil)
+
+	pingErrors := testutil.ToFloat64(RequestErrors.WithLabelValues("/api/system/ping"))
+	upgradesErrors := testutil.ToFloat64(RequestErrors.WithLabelValues("/api/system/upgrades"))
+	if err := apiClient.Ping(); err != nil {
+		t.Fatalf("ping: (%v)", err)
+	}
+	if _, err := apiClient.Upgrades(); err == nil {
+		t.Error("upgrades: error not returned for internal server error")
+	}
+
+	if testutil.ToFloat64(RequestErrors.WithLabelValues("/api/system/upgrades")) != upgradesErrors+1 {
+		t.Error("upgrades: failed request not counted")
+	}
+	if testutil.ToFloat64(RequestErrors.WithLabelValues("/api/system/ping")) != pingErrors {
+		t.Error("ping: successful request counted as failed")
+	}
+	if testutil.CollectAndCount(RequestDuration) < 2 {
+		t.Error("requests not observed by endpoint")
+	}
+}
@@ -0,0 +1,48 @@
+package api_client
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// RequestDuration observes the latency of SonarQube API requests by endpoint and status code,
+	// code is "error" when no response was received
+	RequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "sonarqube_operator_api_request_duration_seconds",
+			Help:    "Latency of SonarQube API requests by endpoint and status code",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"endpoint", "code"},
+	)
+
+	// RequestErrors counts SonarQube API requests that failed or were answered with a non 2xx status code
+	RequestErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sonarqube_operator_api_request_errors_total",
+			Help: "SonarQube API requests that failed or were answered with a non 2xx status code by endpoint",
+		},
+		[]string{"endpoint"},
+	)
+)
+
+// Collectors returns the collectors of the API client metrics, they are registered by the controllers using the client
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{RequestDuration, RequestErrors}
+}
+
+// observeRequest records the duration and outcome of a request to endpoint started at start
+func observeRequest(endpoint string, start time.Time, code int, err error) {
+	label := "error"
+	if err == nil {
+		label = strconv.Itoa(code)
+	}
+	RequestDuration.WithLabelValues(endpoint, label).Observe(time.Since(start).Seconds())
+
+	if err != nil || code < 200 || code > 299 {
+		RequestErrors.WithLabelValues(endpoint).Inc()
+	}
+}
@@ -0,0 +1,23 @@
+package api_client
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Credentials used to authenticate against the SonarQube API, either a user token or a login and password
+type Credentials struct {
+	Token    string
+	Username string
+	Password string
+}
+
+// AuthenticationError is returned when the SonarQube API rejects the credentials of a request
+type AuthenticationError struct {
+	StatusCode int
+	Path       string
+}
+
+func (r *AuthenticationError) Error() string {
+	return fmt.Sprintf("%s returned %d %s", r.Path, r.StatusCode, http.StatusText(r.StatusCode))
+}
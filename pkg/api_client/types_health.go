@@ -0,0 +1,37 @@
+package api_client
+
+type Health struct {
+	Health HealthStatus  `json:"health"`
+	Causes []HealthCause `json:"causes,omitempty"`
+	// Nodes are only reported by Data Center Edition clusters
+	Nodes []NodeHealth `json:"nodes,omitempty"`
+}
+
+type NodeHealth struct {
+	Name      string        `json:"name"`
+	Type      NodeType      `json:"type"`
+	Host      string        `json:"host"`
+	Port      int           `json:"port"`
+	StartedAt string        `json:"startedAt,omitempty"`
+	Health    HealthStatus  `json:"health"`
+	Causes    []HealthCause `json:"causes,omitempty"`
+}
+
+type HealthCause struct {
+	Message string `json:"message"`
+}
+
+type HealthStatus string
+
+const (
+	HealthGreen  HealthStatus = "GREEN"
+	HealthYellow HealthStatus = "YELLOW"
+	HealthRed    HealthStatus = "RED"
+)
+
+type NodeType string
+
+const (
+	NodeApplication NodeType = "APPLICATION"
+	NodeSearch      NodeType = "SEARCH"
+)
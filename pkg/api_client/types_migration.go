@@ -0,0 +1,18 @@
+package api_client
+
+type DBMigrationStatus struct {
+	State     DBMigrationState `json:"state"`
+	Message   string           `json:"message,omitempty"`
+	StartedAt string           `json:"startedAt,omitempty"`
+}
+
+type DBMigrationState string
+
+const (
+	MigrationNone         DBMigrationState = "NO_MIGRATION"
+	MigrationNotSupported DBMigrationState = "NOT_SUPPORTED"
+	MigrationRequired     DBMigrationState = "MIGRATION_REQUIRED"
+	MigrationRunning      DBMigrationState = "MIGRATION_RUNNING"
+	MigrationSucceeded    DBMigrationState = "MIGRATION_SUCCEEDED"
+	MigrationFailed       DBMigrationState = "MIGRATION_FAILED"
+)
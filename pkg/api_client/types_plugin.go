@@ -0,0 +1,12 @@
+package api_client
+
+type InstalledPlugins struct {
+	Plugins []InstalledPlugin `json:"plugins"`
+}
+
+type InstalledPlugin struct {
+	Key      string `json:"key"`
+	Name     string `json:"name,omitempty"`
+	Version  string `json:"version,omitempty"`
+	Filename string `json:"filename,omitempty"`
+}
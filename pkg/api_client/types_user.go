@@ -0,0 +1,14 @@
+package api_client
+
+// Credentials of the admin user of a new SonarQube installation
+const (
+	DefaultAdminLogin    = "admin"
+	DefaultAdminPassword = "admin"
+)
+
+type UserToken struct {
+	Login     string `json:"login"`
+	Name      string `json:"name"`
+	Token     string `json:"token"`
+	CreatedAt string `json:"createdAt,omitempty"`
+}
@@ -15,6 +15,22 @@ const (
 	ConditionShutdown status.ConditionType = "Shutdown"
 	// ConditionUnavailable means that the application is not available.
 	ConditionUnavailable status.ConditionType = "Unavailable"
+	// ConditionUpgrading means that the operator is upgrading SonarQube to a newer version.
+	ConditionUpgrading status.ConditionType = "Upgrading"
+	// ConditionMigrating means that the database schema of SonarQube is being migrated.
+	ConditionMigrating status.ConditionType = "Migrating"
+	// ConditionPluginsMismatched means that the plugins reported by SonarQube don't match the plugins in spec.
+	ConditionPluginsMismatched status.ConditionType = "PluginsMismatched"
+	// ConditionServerStopped means that the SonarQube resource restored has no running pods.
+	ConditionServerStopped status.ConditionType = "ServerStopped"
+	// ConditionDatabaseRestored means that the database dump has been restored.
+	ConditionDatabaseRestored status.ConditionType = "DatabaseRestored"
+	// ConditionVolumeRestored means that the volume of SonarQube has been recreated from the snapshot.
+	ConditionVolumeRestored status.ConditionType = "VolumeRestored"
+	// ConditionIndicesCleared means that the elasticsearch indices have been removed so they are rebuilt.
+	ConditionIndicesCleared status.ConditionType = "IndicesCleared"
+	// ConditionRestored means that the restore completed and SonarQube was started again.
+	ConditionRestored status.ConditionType = "Restored"
 )
 
 // Condition Reasons
@@ -29,11 +45,65 @@ const (
 	ConditionSpecInvalid status.ConditionReason = "SpecInvalid"
 	// ConditionConfigured means that the current spec specified meeting this condition
 	ConditionConfigured status.ConditionReason = "Configured"
+	// ConditionUpgradeStarted means that the operator updated the spec version to an available upgrade
+	ConditionUpgradeStarted status.ConditionReason = "UpgradeStarted"
+	// ConditionUpgradeSucceeded means that the server reported the upgraded version
+	ConditionUpgradeSucceeded status.ConditionReason = "UpgradeSucceeded"
+	// ConditionUpgradeFailed means that the server went down while upgrading
+	ConditionUpgradeFailed status.ConditionReason = "UpgradeFailed"
+	// ConditionMigrationRequired means that the server requires a database migration that the spec doesn't allow
+	ConditionMigrationRequired status.ConditionReason = "MigrationRequired"
+	// ConditionMigrationRunning means that the database migration is running
+	ConditionMigrationRunning status.ConditionReason = "MigrationRunning"
+	// ConditionMigrationSucceeded means that the database migration completed
+	ConditionMigrationSucceeded status.ConditionReason = "MigrationSucceeded"
+	// ConditionMigrationFailed means that the server reported a failed database migration
+	ConditionMigrationFailed status.ConditionReason = "MigrationFailed"
+	// ConditionServerHealthy means that the server reported GREEN or YELLOW health
+	ConditionServerHealthy status.ConditionReason = "ServerHealthy"
+	// ConditionServerUnhealthy means that the server reported RED health
+	ConditionServerUnhealthy status.ConditionReason = "ServerUnhealthy"
+	// ConditionAuthenticationFailed means that the SonarQube API rejected the operator credentials
+	ConditionAuthenticationFailed status.ConditionReason = "AuthenticationFailed"
+	// ConditionPluginsInstalled means that the server reported every plugin in spec
+	ConditionPluginsInstalled status.ConditionReason = "PluginsInstalled"
+	// ConditionPluginsMissing means that the server didn't report a plugin in spec or reported another version
+	ConditionPluginsMissing status.ConditionReason = "PluginsMissing"
+	// ConditionBackupRequired means that an upgrade is waiting for a recent backup
+	ConditionBackupRequired status.ConditionReason = "BackupRequired"
 )
 
 const (
 	SecretAnnotation       = "sonarqube.sonarsource.jfowler.github.io/database"
 	ServerSecretAnnotation = "sonarqubeserver.sonarsource.jfowler.github.io/database"
+	// ConfigMapAnnotation lists the SonarQubes requeued when a ConfigMap they read changes
+	ConfigMapAnnotation = "sonarqube.sonarsource.jfowler.github.io/configmap"
+	RevisionAnnotation     = "sonarsource.jfowler.github.io/revision"
+	// RestoreSnapshotAnnotation is set on SonarQube by a restore to recreate the volume from a VolumeSnapshot
+	RestoreSnapshotAnnotation = "sonarsource.jfowler.github.io/restore-snapshot"
+)
+
+// Keys of the database secret
+const (
+	DatabaseSecretUsername = "username"
+	DatabaseSecretPassword = "password"
+)
+
+// Keys of the authentication secret
+const (
+	AuthSecretToken    = "token"
+	AuthSecretUsername = "username"
+	AuthSecretPassword = "password"
+)
+
+// Keys of the passcode secret
+const (
+	PasscodeSecretPasscode = "passcode"
+)
+
+// Keys of the cluster secret
+const (
+	ClusterSecretJWT = "jwtBase64Hs256Secret"
 )
 
 const (
@@ -45,6 +115,7 @@ const (
 	KubeAppName      = "app.kubernetes.io/name"
 	TypeLabel        = "sonarsource.jfowler.github.io/SonarQube"
 	ServerTypeLabel  = "sonarsource.jfowler.github.io/SonarQubeServer"
+	BackupLabel      = "sonarsource.jfowler.github.io/SonarQubeBackup"
 )
 
 type ServerType string
@@ -55,6 +126,13 @@ const (
 	Search      ServerType = "search"
 )
 
+type ExposeType string
+
+const (
+	ExposeIngress ExposeType = "ingress"
+	ExposeRoute   ExposeType = "route"
+)
+
 const (
 	ApplicationWebPort int32 = 9000
 	ApplicationPort    int32 = 9003
@@ -72,3 +150,18 @@ const (
 	DeploymentUpdating    DeploymentStatus = "Updating"
 	DeploymentUnavailable DeploymentStatus = "Unavailable"
 )
+
+type UpgradeResult string
+
+const (
+	UpgradeInProgress UpgradeResult = "InProgress"
+	UpgradeSucceeded  UpgradeResult = "Succeeded"
+	UpgradeFailed     UpgradeResult = "Failed"
+)
+
+type BootstrapResult string
+
+const (
+	BootstrapCompleted BootstrapResult = "Completed"
+	BootstrapSkipped   BootstrapResult = "Skipped"
+)
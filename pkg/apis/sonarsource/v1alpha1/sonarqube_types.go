@@ -1,9 +1,12 @@
 package v1alpha1
 
 import (
+	"encoding/json"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"github.com/operator-framework/operator-sdk/pkg/status"
 	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 )
 
 // SonarQubeSpec defines the desired state of SonarQube
@@ -30,20 +33,43 @@ type SonarQubeSpec struct {
 	// +kubebuilder:validation:Enum=community;developer;enterprise
 	Edition *string `json:"edition,omitempty"`
 
-	// Automatically apply minor version updates
+	// Automatically apply minor version updates, can't be set when the image is pinned to a tag or digest.
+	// Updates are paused after a failed update until the version is changed
 	// +optional
 	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
 	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Minor"
 	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:checkbox,urn:alm:descriptor:com.tectonic.ui:advanced,urn:alm:descriptor:com.tectonic.ui:fieldGroup:updates"
 	UpdatesMinor *bool `json:"updatesMinor,omitempty"`
 
-	// Automatically apply major version updates
+	// Automatically apply major version updates, can't be set when the image is pinned to a tag or digest.
+	// Updates are paused after a failed update until the version is changed
 	// +optional
 	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
 	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Major"
 	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:checkbox,urn:alm:descriptor:com.tectonic.ui:advanced,urn:alm:descriptor:com.tectonic.ui:fieldGroup:updates"
 	UpdatesMajor *bool `json:"updatesMajor,omitempty"`
 
+	// Automatically start database migrations when the server requires one.
+	// Migrations are always started for upgrades applied by the operator
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Migrate Database"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:checkbox,urn:alm:descriptor:com.tectonic.ui:advanced,urn:alm:descriptor:com.tectonic.ui:fieldGroup:updates"
+	MigrateDatabase *bool `json:"migrateDatabase,omitempty"`
+
+	// Automatic upgrades are only started when a SonarQubeBackup of this resource completed within this many minutes
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Backup Max Age"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:number,urn:alm:descriptor:com.tectonic.ui:advanced,urn:alm:descriptor:com.tectonic.ui:fieldGroup:updates"
+	// +kubebuilder:validation:Minimum=1
+	UpdatesBackupMaxAge *int32 `json:"updatesBackupMaxAge,omitempty"`
+
+	// Image SonarQube runs, derived from version and edition when not overridden
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=false
+	Image *Image `json:"image,omitempty"`
+
 	// Secret with sonar configuration files (sonar.properties, wrapper.properties).
 	// Don't add cluster properties to configuration files as this could cause unexpected results
 	// +optional
@@ -54,6 +80,11 @@ type SonarQubeSpec struct {
 	// +operator-sdk:gen-csv:customresourcedefinitions.statusDescriptors.x-descriptors="urn:alm:descriptor:io.kubernetes:Secret"
 	Secret *string `json:"secret,omitempty"`
 
+	// Database connection, the embedded H2 database is used when empty
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=false
+	Database *Database `json:"database,omitempty"`
+
 	// Sonar Node Type application or search when clustering is enabled otherwise aio (all-in-one)
 	// +optional
 	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
@@ -62,6 +93,13 @@ type SonarQubeSpec struct {
 	// +kubebuilder:validation:Enum=aio;application;search
 	Type *ServerType `json:"type,omitempty"`
 
+	// Data Center Edition cluster of application and search nodes managed from this resource.
+	// Type, hosts, search hosts and edition are ignored when set.
+	// Can't be added to or removed from existing nodes, their deployment must be deleted first
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=false
+	Cluster *Cluster `json:"cluster,omitempty"`
+
 	// SonarQube application hosts list
 	// +optional
 	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
@@ -76,12 +114,18 @@ type SonarQubeSpec struct {
 	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:text,urn:alm:descriptor:com.tectonic.ui:arrayFieldGroup:searchHosts,urn:alm:descriptor:com.tectonic.ui:advanced"
 	SearchHosts []string `json:"searchHosts,omitempty"`
 
-	// Service Account
+	// Service account SonarQube runs as (default is a service account named after the SonarQube resource)
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=false
+	ServiceAccount *ServiceAccount `json:"serviceAccount,omitempty"`
+
+	// Secret with credentials used by the operator to call the SonarQube API.
+	// Must contain either a user token (token) or a login and password (username, password)
 	// +optional
 	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
-	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Service Account"
-	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:text"
-	ServiceAccount *string `json:"serviceAccount,omitempty"`
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Authentication Secret"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:io.kubernetes:Secret,urn:alm:descriptor:com.tectonic.ui:advanced"
+	AuthSecret *string `json:"authSecret,omitempty"`
 
 	// External base URL
 	// +optional
@@ -90,6 +134,43 @@ type SonarQubeSpec struct {
 	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:text"
 	ExternalURL *string `json:"externalURL,omitempty"`
 
+	// TLS served by SonarQube pods and trusted by the operator when calling the SonarQube API over https
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=false
+	TLS *TLS `json:"tls,omitempty"`
+
+	// Liveness, readiness and startup probes of SonarQube pods
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=false
+	Probes *Probes `json:"probes,omitempty"`
+
+	// Prometheus ServiceMonitor scraping /api/monitoring/metrics with the monitoring passcode, needs prometheus-operator
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=false
+	Monitoring *Monitoring `json:"monitoring,omitempty"`
+
+	// Expose the web UI with an Ingress or an OpenShift Route, sonar.core.serverBaseURL is derived from it
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=false
+	Expose *Expose `json:"expose,omitempty"`
+
+	// Plugins installed in extensions/plugins before SonarQube starts, jars that are no longer listed are removed
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=false
+	Plugins []Plugin `json:"plugins,omitempty"`
+
+	// Update center catalog served in the cluster, used by SonarQube for upgrades and by the operator to resolve plugins
+	// when update.sonarsource.org can't be reached
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=false
+	UpdateCenter *UpdateCenter `json:"updateCenter,omitempty"`
+
+	// Scheduled backups of the database and volume, managed with a SonarQubeBackup of the same name.
+	// Dumps and snapshots are kept when backups are removed from spec or SonarQube is deleted
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=false
+	Backup *Backup `json:"backup,omitempty"`
+
 	// Node Configuration
 	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=false
 	NodeConfig NodeConfig `json:"nodeConfig,omitempty"`
@@ -151,6 +232,430 @@ type NodeConfig struct {
 	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Storage Size"
 	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:text"
 	StorageSize *string `json:"storageSize,omitempty"`
+
+	// Volumes of data, extensions, logs and temp, volumes not set are kept in the storage volume and temp is an emptyDir.
+	// Volumes moved out of an existing storage volume are copied when SonarQube starts, the storage
+	// PersistentVolumeClaim is kept until it is deleted
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Volumes"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:advanced"
+	Volumes *Volumes `json:"volumes,omitempty"`
+}
+
+type Volumes struct {
+	// Volume of the embedded H2 database and elasticsearch indices
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Data"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:advanced"
+	Data *VolumeSource `json:"data,omitempty"`
+
+	// Volume of plugins and JDBC drivers
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Extensions"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:advanced"
+	Extensions *VolumeSource `json:"extensions,omitempty"`
+
+	// Volume of log files
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Logs"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:advanced"
+	Logs *VolumeSource `json:"logs,omitempty"`
+
+	// Volume of temporary files (default is an emptyDir)
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Temp"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:advanced"
+	Temp *VolumeSource `json:"temp,omitempty"`
+}
+
+// VolumeSource of a SonarQube volume, a PersistentVolumeClaim of size in storage class is created
+// when neither emptyDir nor claimName is set
+type VolumeSource struct {
+	// Size of the PersistentVolumeClaim (default is 1Gi)
+	// +optional
+	Size *string `json:"size,omitempty"`
+
+	// Storage class of the PersistentVolumeClaim (default is the storage class of nodeConfig)
+	// +optional
+	StorageClass *string `json:"storageClass,omitempty"`
+
+	// EmptyDir lasting as long as the pod
+	// +optional
+	EmptyDir *corev1.EmptyDirVolumeSource `json:"emptyDir,omitempty"`
+
+	// Existing PersistentVolumeClaim, it is not changed by the operator
+	// +optional
+	ClaimName *string `json:"claimName,omitempty"`
+}
+
+type Image struct {
+	// Repository of the image (default is sonarqube in the registry of the operator)
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Repository"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:text,urn:alm:descriptor:com.tectonic.ui:fieldGroup:image"
+	Repository *string `json:"repository,omitempty"`
+
+	// Tag of the image replacing <version>-<edition>, can't be set for a cluster
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Tag"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:text,urn:alm:descriptor:com.tectonic.ui:fieldGroup:image"
+	Tag *string `json:"tag,omitempty"`
+
+	// Digest the image is pinned to (ex sha256:...), takes precedence over the tag and can't be set for a cluster
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Digest"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:text,urn:alm:descriptor:com.tectonic.ui:fieldGroup:image"
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]+:[a-f0-9]{32,}$`
+	Digest *string `json:"digest,omitempty"`
+
+	// Pull policy of the image (default is Always)
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Pull Policy"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:imagePullPolicy,urn:alm:descriptor:com.tectonic.ui:fieldGroup:image"
+	// +kubebuilder:validation:Enum=Always;IfNotPresent;Never
+	PullPolicy *corev1.PullPolicy `json:"pullPolicy,omitempty"`
+
+	// Secrets the image is pulled with
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Pull Secrets"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:fieldGroup:image"
+	PullSecrets []corev1.LocalObjectReference `json:"pullSecrets,omitempty"`
+}
+
+type ServiceAccount struct {
+	// Name of the service account (default is the name of the SonarQube resource)
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Name"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:text,urn:alm:descriptor:com.tectonic.ui:fieldGroup:serviceAccount"
+	Name *string `json:"name,omitempty"`
+
+	// Create the service account, an existing service account is used as it is when false (default is true)
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Create"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:booleanSwitch,urn:alm:descriptor:com.tectonic.ui:fieldGroup:serviceAccount"
+	Create *bool `json:"create,omitempty"`
+
+	// Annotations of the created service account (ex. workload identity)
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Annotations"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:fieldGroup:serviceAccount"
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// Image pull secrets of the created service account
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Image Pull Secrets"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:fieldGroup:serviceAccount"
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+
+	// Mount the service account token in SonarQube pods (default is the setting of the service account)
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Automount Token"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:booleanSwitch,urn:alm:descriptor:com.tectonic.ui:fieldGroup:serviceAccount"
+	AutomountToken *bool `json:"automountToken,omitempty"`
+
+	// Rules of a Role bound to the service account, the Role and RoleBinding are named after the SonarQube resource
+	// and removed when no rules are set
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Rules"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:fieldGroup:serviceAccount"
+	Rules []rbacv1.PolicyRule `json:"rules,omitempty"`
+}
+
+// UnmarshalJSON decodes the service account, a bare string is the name of the service account
+// as set by SonarQube resources created before the service account became an object
+func (in *ServiceAccount) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*in = ServiceAccount{}
+		if name != "" {
+			in.Name = &name
+		}
+		return nil
+	}
+
+	type serviceAccount ServiceAccount
+	return json.Unmarshal(data, (*serviceAccount)(in))
+}
+
+type Cluster struct {
+	// Number of application nodes (default is 2)
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Application Nodes"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:podCount,urn:alm:descriptor:com.tectonic.ui:fieldGroup:cluster"
+	// +kubebuilder:validation:Minimum=1
+	ApplicationReplicas *int32 `json:"applicationReplicas,omitempty"`
+
+	// Number of search nodes (default is 3)
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Search Nodes"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:podCount,urn:alm:descriptor:com.tectonic.ui:fieldGroup:cluster"
+	// +kubebuilder:validation:Minimum=1
+	SearchReplicas *int32 `json:"searchReplicas,omitempty"`
+}
+
+type Expose struct {
+	// ingress or route (default is ingress)
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Type"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:select:ingress,urn:alm:descriptor:com.tectonic.ui:select:route,urn:alm:descriptor:com.tectonic.ui:fieldGroup:expose"
+	// +kubebuilder:validation:Enum=ingress;route
+	Type *ExposeType `json:"type,omitempty"`
+
+	// Host name SonarQube is reachable at
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Host"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:text,urn:alm:descriptor:com.tectonic.ui:fieldGroup:expose"
+	Host string `json:"host"`
+
+	// Path SonarQube is exposed and served under with sonar.web.context (default is /)
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Path"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:text,urn:alm:descriptor:com.tectonic.ui:fieldGroup:expose,urn:alm:descriptor:com.tectonic.ui:advanced"
+	Path *string `json:"path,omitempty"`
+
+	// Secret with the TLS certificate (tls.crt, tls.key), SonarQube is exposed over https when set
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="TLS Secret"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:io.kubernetes:Secret,urn:alm:descriptor:com.tectonic.ui:fieldGroup:expose"
+	TLSSecret *string `json:"tlsSecret,omitempty"`
+
+	// Annotations added to the Ingress or Route
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=false
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type TLS struct {
+	// Secret with the certificate (tls.crt, tls.key) a TLS proxy sidecar in SonarQube pods serves https with on the web port.
+	// Routes reencrypt or pass tls through, ingresses need the backend protocol annotation of their controller
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="TLS Secret"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:io.kubernetes:Secret,urn:alm:descriptor:com.tectonic.ui:fieldGroup:tls"
+	Secret *string `json:"secret,omitempty"`
+
+	// ConfigMap with the CA bundle the operator verifies https endpoints of SonarQube with
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="CA ConfigMap"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:io.kubernetes:ConfigMap,urn:alm:descriptor:com.tectonic.ui:fieldGroup:tls"
+	CAConfigMap *string `json:"caConfigMap,omitempty"`
+
+	// Secret with the CA bundle the operator verifies https endpoints of SonarQube with
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="CA Secret"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:io.kubernetes:Secret,urn:alm:descriptor:com.tectonic.ui:fieldGroup:tls"
+	CASecret *string `json:"caSecret,omitempty"`
+
+	// Key of the CA bundle in the ConfigMap or Secret (default is ca.crt)
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="CA Key"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:text,urn:alm:descriptor:com.tectonic.ui:fieldGroup:tls,urn:alm:descriptor:com.tectonic.ui:advanced"
+	CAKey *string `json:"caKey,omitempty"`
+
+	// Skip verification of the certificates of SonarQube, only meant for development clusters
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Insecure Skip Verify"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:booleanSwitch,urn:alm:descriptor:com.tectonic.ui:fieldGroup:tls,urn:alm:descriptor:com.tectonic.ui:advanced"
+	InsecureSkipVerify *bool `json:"insecureSkipVerify,omitempty"`
+}
+
+type Probes struct {
+	// Check liveness with /api/system/liveness and the monitoring passcode from within the container instead of a tcp check on the web port
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Liveness Endpoint"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:booleanSwitch,urn:alm:descriptor:com.tectonic.ui:fieldGroup:probes"
+	LivenessEndpoint *bool `json:"livenessEndpoint,omitempty"`
+
+	// Secret with the monitoring passcode (passcode) sent by the liveness probe and Prometheus, set as sonar.web.systemPasscode
+	// (default is a secret generated by the operator)
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Passcode Secret"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:io.kubernetes:Secret,urn:alm:descriptor:com.tectonic.ui:fieldGroup:probes"
+	PasscodeSecret *string `json:"passcodeSecret,omitempty"`
+
+	// Timings of the liveness probe (default is 60s initial delay, 5s timeout, 10s period and 3 failures)
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=false
+	Liveness *ProbeTimings `json:"liveness,omitempty"`
+
+	// Timings of the readiness probe (default is 5s timeout, 10s period and 3 failures)
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=false
+	Readiness *ProbeTimings `json:"readiness,omitempty"`
+
+	// Timings of the startup probe holding back liveness and readiness checks while SonarQube starts
+	// (default is 5s timeout, 10s period and 60 failures)
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=false
+	Startup *ProbeTimings `json:"startup,omitempty"`
+}
+
+type Monitoring struct {
+	// Create a ServiceMonitor for the web port of SonarQube, skipped when prometheus-operator is not installed
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Service Monitor"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:booleanSwitch,urn:alm:descriptor:com.tectonic.ui:fieldGroup:monitoring"
+	ServiceMonitor *bool `json:"serviceMonitor,omitempty"`
+
+	// Scrape interval (default is the interval of Prometheus)
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Interval"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:text,urn:alm:descriptor:com.tectonic.ui:fieldGroup:monitoring"
+	// +kubebuilder:validation:Pattern=^[0-9]+(ms|s|m|h)$
+	Interval *string `json:"interval,omitempty"`
+
+	// Labels added to the ServiceMonitor, used by the serviceMonitorSelector of Prometheus
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=false
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+type ProbeTimings struct {
+	// Seconds before the first check
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	InitialDelaySeconds *int32 `json:"initialDelaySeconds,omitempty"`
+
+	// Seconds a check may take
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	TimeoutSeconds *int32 `json:"timeoutSeconds,omitempty"`
+
+	// Seconds between checks
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	PeriodSeconds *int32 `json:"periodSeconds,omitempty"`
+
+	// Consecutive failed checks before the probe fails
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	FailureThreshold *int32 `json:"failureThreshold,omitempty"`
+}
+
+type Plugin struct {
+	// Plugin key as reported by /api/plugins/installed
+	// +kubebuilder:validation:Pattern=`^[A-Za-z0-9_-]+$`
+	Key string `json:"key"`
+
+	// Plugin version, the download url is resolved from the update center when no url is set
+	// +optional
+	// +kubebuilder:validation:Pattern=`^[A-Za-z0-9._-]+$`
+	Version *string `json:"version,omitempty"`
+
+	// URL the plugin jar is downloaded from
+	// +optional
+	URL *string `json:"url,omitempty"`
+}
+
+type UpdateCenter struct {
+	// URL of update-center.properties served by a local mirror
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Update Center URL"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:text,urn:alm:descriptor:com.tectonic.ui:fieldGroup:updateCenter"
+	URL *string `json:"url,omitempty"`
+
+	// ConfigMap holding the update center catalog, mounted in the SonarQube pods
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Update Center ConfigMap"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:io.kubernetes:ConfigMap,urn:alm:descriptor:com.tectonic.ui:fieldGroup:updateCenter"
+	ConfigMap *string `json:"configMap,omitempty"`
+
+	// Key of the catalog in the ConfigMap (default is update-center.properties)
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Update Center Key"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:text,urn:alm:descriptor:com.tectonic.ui:fieldGroup:updateCenter,urn:alm:descriptor:com.tectonic.ui:advanced"
+	Key *string `json:"key,omitempty"`
+}
+
+type Backup struct {
+	// Cron schedule of the backups (ex 0 2 * * *)
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Schedule"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:text,urn:alm:descriptor:com.tectonic.ui:fieldGroup:backup"
+	Schedule string `json:"schedule"`
+
+	// Number of completed backups kept (default is 7)
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Retention"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:number,urn:alm:descriptor:com.tectonic.ui:fieldGroup:backup"
+	// +kubebuilder:validation:Minimum=1
+	Retention *int32 `json:"retention,omitempty"`
+
+	// VolumeSnapshotClass of the snapshots of the SonarQube volume, the default class is used when empty
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Volume Snapshot Class"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:text,urn:alm:descriptor:com.tectonic.ui:fieldGroup:backup,urn:alm:descriptor:com.tectonic.ui:advanced"
+	VolumeSnapshotClass *string `json:"volumeSnapshotClass,omitempty"`
+}
+
+type Database struct {
+	// JDBC URL (ex jdbc:postgresql://postgres:5432/sonarqube), takes precedence over host, port, and name
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="JDBC URL"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:text,urn:alm:descriptor:com.tectonic.ui:fieldGroup:database"
+	URL *string `json:"url,omitempty"`
+
+	// PostgreSQL host
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Host"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:text,urn:alm:descriptor:com.tectonic.ui:fieldGroup:database"
+	Host *string `json:"host,omitempty"`
+
+	// PostgreSQL port (default is 5432)
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Port"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:number,urn:alm:descriptor:com.tectonic.ui:fieldGroup:database"
+	Port *int32 `json:"port,omitempty"`
+
+	// Database name (default is sonarqube)
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Database Name"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:text,urn:alm:descriptor:com.tectonic.ui:fieldGroup:database"
+	Name *string `json:"name,omitempty"`
+
+	// Secret with the database credentials (username, password)
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Credentials Secret"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:io.kubernetes:Secret,urn:alm:descriptor:com.tectonic.ui:fieldGroup:database"
+	Secret string `json:"secret"`
 }
 
 // SonarQubeStatus defines the observed state of SonarQube
@@ -164,6 +669,13 @@ type SonarQubeStatus struct {
 	// +operator-sdk:gen-csv:customresourcedefinitions.statusDescriptors=true
 	Service string `json:"service,omitempty"`
 
+	// URL SonarQube is exposed at
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.statusDescriptors.displayName="URL"
+	// +operator-sdk:gen-csv:customresourcedefinitions.statusDescriptors.x-descriptors="urn:alm:descriptor:org.w3:link"
+	// +operator-sdk:gen-csv:customresourcedefinitions.statusDescriptors=true
+	URL string `json:"url,omitempty"`
+
 	// Status of pods
 	// +optional
 	// +operator-sdk:gen-csv:customresourcedefinitions.statusDescriptors.displayName="Pod Statuses"
@@ -181,11 +693,154 @@ type SonarQubeStatus struct {
 	ObservedVersion string `json:"observedVersion,omitempty"`
 
 	Upgrades Upgrades `json:"upgrades,omitempty"`
+
+	// Status of the latest database migration
+	// +optional
+	Migration Migration `json:"migration,omitempty"`
+
+	// Health reported by the server, per node for clusters
+	// +optional
+	Health Health `json:"health,omitempty"`
+
+	// Status of the admin user bootstrap by the operator
+	// +optional
+	Admin AdminStatus `json:"admin,omitempty"`
+
+	// Size of the persistent volume requested in spec and provisioned
+	// +optional
+	Storage StorageStatus `json:"storage,omitempty"`
+
+	// Image resolved from spec and the images running
+	// +optional
+	Image ImageStatus `json:"image,omitempty"`
+}
+
+type Health struct {
+	// GREEN, YELLOW, or RED
+	// +operator-sdk:gen-csv:customresourcedefinitions.statusDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.statusDescriptors.displayName="Health"
+	// +operator-sdk:gen-csv:customresourcedefinitions.statusDescriptors.x-descriptors="urn:alm:descriptor:text"
+	Status string `json:"status,omitempty"`
+
+	// Reasons the server is not GREEN
+	Causes []string `json:"causes,omitempty"`
+
+	// Health of each node of a cluster
+	Nodes []NodeHealth `json:"nodes,omitempty"`
+}
+
+type NodeHealth struct {
+	// Name of the node
+	Name string `json:"name"`
+
+	// APPLICATION or SEARCH
+	Type string `json:"type"`
+
+	// Host the node is reachable at
+	Host string `json:"host,omitempty"`
+
+	// GREEN, YELLOW, or RED
+	Status string `json:"status"`
+
+	// Reasons the node is not GREEN
+	Causes []string `json:"causes,omitempty"`
+}
+
+type AdminStatus struct {
+	// Secret with the admin password and the operator token
+	Secret string `json:"secret,omitempty"`
+
+	// Completed or Skipped
+	Result BootstrapResult `json:"result,omitempty"`
+
+	// Details on why bootstrap was skipped
+	Message string `json:"message,omitempty"`
+}
+
+type ImageStatus struct {
+	// Image reference resolved from spec
+	// +operator-sdk:gen-csv:customresourcedefinitions.statusDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.statusDescriptors.displayName="Image"
+	// +operator-sdk:gen-csv:customresourcedefinitions.statusDescriptors.x-descriptors="urn:alm:descriptor:text"
+	Reference string `json:"reference,omitempty"`
+
+	// Image IDs of the images SonarQube pods are running, they differ from the reference during rollouts
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.statusDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.statusDescriptors.displayName="Running Images"
+	// +operator-sdk:gen-csv:customresourcedefinitions.statusDescriptors.x-descriptors="urn:alm:descriptor:text"
+	Running []string `json:"running,omitempty"`
+}
+
+type StorageStatus struct {
+	// PersistentVolumeClaim holding the data volume, empty when data is not kept in a PersistentVolumeClaim
+	// +operator-sdk:gen-csv:customresourcedefinitions.statusDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.statusDescriptors.displayName="Data Claim"
+	// +operator-sdk:gen-csv:customresourcedefinitions.statusDescriptors.x-descriptors="urn:alm:descriptor:text"
+	Claim string `json:"claim,omitempty"`
+
+	// Path of the data volume in the claim
+	// +optional
+	SubPath string `json:"subPath,omitempty"`
+
+	// Storage size requested in spec
+	// +operator-sdk:gen-csv:customresourcedefinitions.statusDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.statusDescriptors.displayName="Requested Storage"
+	// +operator-sdk:gen-csv:customresourcedefinitions.statusDescriptors.x-descriptors="urn:alm:descriptor:text"
+	Requested string `json:"requested,omitempty"`
+
+	// Capacity of the bound persistent volume, differs from requested while the volume is expanded
+	// +operator-sdk:gen-csv:customresourcedefinitions.statusDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.statusDescriptors.displayName="Storage Capacity"
+	// +operator-sdk:gen-csv:customresourcedefinitions.statusDescriptors.x-descriptors="urn:alm:descriptor:text"
+	Capacity string `json:"capacity,omitempty"`
+}
+
+type Migration struct {
+	// State reported by the server (NO_MIGRATION, MIGRATION_REQUIRED, MIGRATION_RUNNING, MIGRATION_SUCCEEDED, MIGRATION_FAILED)
+	State string `json:"state,omitempty"`
+
+	// Message reported by the server
+	Message string `json:"message,omitempty"`
+
+	// Time the migration was started
+	StartedAt string `json:"startedAt,omitempty"`
 }
 
 type Upgrades struct {
 	Compatible   []string `json:"compatible,omitempty"`
 	Incompatible []string `json:"incompatible,omitempty"`
+
+	// Last automatic upgrade started by the operator
+	// +optional
+	Last *UpgradeRecord `json:"last,omitempty"`
+
+	// Versions automatic upgrades failed to, they aren't selected by automatic upgrades again
+	// +optional
+	Failed []string `json:"failed,omitempty"`
+}
+
+type UpgradeRecord struct {
+	// Version before the upgrade
+	From string `json:"from"`
+
+	// Version targeted by the upgrade
+	To string `json:"to"`
+
+	// InProgress, Succeeded, or Failed
+	Result UpgradeResult `json:"result"`
+
+	// Details on the result of the upgrade
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// Time the upgrade was started
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// Time the upgrade succeeded or failed
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -198,6 +853,9 @@ type Upgrades struct {
 // +operator-sdk:gen-csv:customresourcedefinitions.resources="Secret,v1,\"\""
 // +operator-sdk:gen-csv:customresourcedefinitions.resources="Deployment,v1,\"\""
 // +operator-sdk:gen-csv:customresourcedefinitions.resources="PersistentVolumeClaim,v1,\"\""
+// +operator-sdk:gen-csv:customresourcedefinitions.resources="Ingress,v1beta1,\"\""
+// +operator-sdk:gen-csv:customresourcedefinitions.resources="StatefulSet,v1,\"\""
+// +operator-sdk:gen-csv:customresourcedefinitions.resources="SonarQubeBackup,v1alpha1,\"\""
 type SonarQube struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
@@ -0,0 +1,126 @@
+package v1alpha1
+
+import (
+	"github.com/operator-framework/operator-sdk/pkg/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SonarQubeBackupSpec defines the desired state of SonarQubeBackup
+type SonarQubeBackupSpec struct {
+	// SonarQube resource backed up, in the namespace of the backup
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="SonarQube"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:text"
+	SonarQube string `json:"sonarQube"`
+
+	// Cron schedule of the backups (ex 0 2 * * *)
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Schedule"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:text"
+	Schedule string `json:"schedule"`
+
+	// Number of completed backups kept (default is 7)
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Retention"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:number"
+	// +kubebuilder:validation:Minimum=1
+	Retention *int32 `json:"retention,omitempty"`
+
+	// Suspend scheduling of backups
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Suspend"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:booleanSwitch"
+	Suspend *bool `json:"suspend,omitempty"`
+
+	// Image running pg_dump, must match the major version of the database (default is postgres:12)
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Image"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:text,urn:alm:descriptor:com.tectonic.ui:advanced"
+	Image *string `json:"image,omitempty"`
+
+	// Size of the volume holding the database dumps (default is 10Gi)
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Storage Size"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:text,urn:alm:descriptor:com.tectonic.ui:fieldGroup:storage"
+	StorageSize *string `json:"storageSize,omitempty"`
+
+	// Storage class of the volume holding the database dumps
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Storage Class"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:io.kubernetes:StorageClass,urn:alm:descriptor:com.tectonic.ui:fieldGroup:storage"
+	StorageClass *string `json:"storageClass,omitempty"`
+
+	// VolumeSnapshotClass of the snapshots of the SonarQube volume, the default class is used when empty
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Volume Snapshot Class"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:text,urn:alm:descriptor:com.tectonic.ui:fieldGroup:storage"
+	VolumeSnapshotClass *string `json:"volumeSnapshotClass,omitempty"`
+}
+
+// SonarQubeBackupStatus defines the observed state of SonarQubeBackup
+type SonarQubeBackupStatus struct {
+	// Conditions represent the latest available observations of an object's state
+	Conditions status.Conditions `json:"conditions,omitempty"`
+
+	// Completed backups that are kept, newest first
+	// +optional
+	Backups []BackupRecord `json:"backups,omitempty"`
+
+	// Time the latest backup completed
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.statusDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.statusDescriptors.displayName="Last Backup"
+	// +operator-sdk:gen-csv:customresourcedefinitions.statusDescriptors.x-descriptors="urn:alm:descriptor:timestamp"
+	LastBackupTime *metav1.Time `json:"lastBackupTime,omitempty"`
+}
+
+type BackupRecord struct {
+	// Name of the Job that ran the backup
+	Name string `json:"name"`
+
+	// Database dump in the dump volume
+	Dump string `json:"dump"`
+
+	// VolumeSnapshot of the SonarQube volume, empty when volume snapshots are not available
+	// +optional
+	Snapshot string `json:"snapshot,omitempty"`
+
+	// Time the database dump completed
+	CompletionTime metav1.Time `json:"completionTime"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// SonarQubeBackup is the Schema for the sonarqubebackups API
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:path=sonarqubebackups,scope=Namespaced
+// +operator-sdk:gen-csv:customresourcedefinitions.displayName="SonarQube Backup"
+// +operator-sdk:gen-csv:customresourcedefinitions.resources="CronJob,v1beta1,\"\""
+// +operator-sdk:gen-csv:customresourcedefinitions.resources="PersistentVolumeClaim,v1,\"\""
+// +operator-sdk:gen-csv:customresourcedefinitions.resources="VolumeSnapshot,v1beta1,\"\""
+type SonarQubeBackup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SonarQubeBackupSpec   `json:"spec,omitempty"`
+	Status SonarQubeBackupStatus `json:"status,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// SonarQubeBackupList contains a list of SonarQubeBackup
+type SonarQubeBackupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []SonarQubeBackup `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&SonarQubeBackup{}, &SonarQubeBackupList{})
+}
@@ -0,0 +1,97 @@
+package v1alpha1
+
+import (
+	"github.com/operator-framework/operator-sdk/pkg/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SonarQubeRestoreSpec defines the desired state of SonarQubeRestore
+type SonarQubeRestoreSpec struct {
+	// SonarQube resource restored, in the namespace of the restore
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="SonarQube"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:text"
+	SonarQube string `json:"sonarQube"`
+
+	// Database dump restored
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Dump"
+	Dump DumpLocation `json:"dump"`
+
+	// VolumeSnapshot the volume of SonarQube is recreated from, the volume is kept when empty
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Snapshot"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:text"
+	Snapshot *string `json:"snapshot,omitempty"`
+
+	// Image running pg_restore, must match the major version of the database (default is postgres:12)
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Image"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:text,urn:alm:descriptor:com.tectonic.ui:advanced"
+	Image *string `json:"image,omitempty"`
+}
+
+// DumpLocation is a database dump in a PersistentVolumeClaim.
+// The dumps of a SonarQubeBackup are kept in the claim <backup>-dumps as <backup record>.dump
+type DumpLocation struct {
+	// PersistentVolumeClaim holding the dump
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Claim Name"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:text,urn:alm:descriptor:com.tectonic.ui:fieldGroup:dump"
+	ClaimName string `json:"claimName"`
+
+	// Path of the dump in the PersistentVolumeClaim
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.displayName="Path"
+	// +operator-sdk:gen-csv:customresourcedefinitions.specDescriptors.x-descriptors="urn:alm:descriptor:com.tectonic.ui:text,urn:alm:descriptor:com.tectonic.ui:fieldGroup:dump"
+	Path string `json:"path"`
+}
+
+// SonarQubeRestoreStatus defines the observed state of SonarQubeRestore
+type SonarQubeRestoreStatus struct {
+	// Conditions represent the latest available observations of an object's state, every phase of the restore
+	// is tracked by a condition
+	Conditions status.Conditions `json:"conditions,omitempty"`
+
+	// Shutdown of the SonarQube resource before the restore, set back once the restore completed
+	// +optional
+	Shutdown *bool `json:"shutdown,omitempty"`
+
+	// Time the restore completed
+	// +optional
+	// +operator-sdk:gen-csv:customresourcedefinitions.statusDescriptors=true
+	// +operator-sdk:gen-csv:customresourcedefinitions.statusDescriptors.displayName="Completion Time"
+	// +operator-sdk:gen-csv:customresourcedefinitions.statusDescriptors.x-descriptors="urn:alm:descriptor:timestamp"
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// SonarQubeRestore is the Schema for the sonarquberestores API
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:path=sonarquberestores,scope=Namespaced
+// +operator-sdk:gen-csv:customresourcedefinitions.displayName="SonarQube Restore"
+// +operator-sdk:gen-csv:customresourcedefinitions.resources="Job,v1,\"\""
+// +operator-sdk:gen-csv:customresourcedefinitions.resources="SonarQube,v1alpha1,\"\""
+type SonarQubeRestore struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SonarQubeRestoreSpec   `json:"spec,omitempty"`
+	Status SonarQubeRestoreStatus `json:"status,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// SonarQubeRestoreList contains a list of SonarQubeRestore
+type SonarQubeRestoreList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []SonarQubeRestore `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&SonarQubeRestore{}, &SonarQubeRestoreList{})
+}
@@ -0,0 +1,203 @@
+package sonarqube
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+
+	"github.com/jlfowle/sonarqube-operator/pkg/api_client"
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// OperatorTokenName is the name of the token generated for the operator, it is revoked before a new one is generated
+	OperatorTokenName = "sonarqube-operator"
+	// AdminPasswordLength is the length of generated admin passwords
+	AdminPasswordLength = 32
+)
+
+// Gets credentials for the SonarQube API from the authentication secret,
+// or from the admin secret once the admin bootstrap completed
+// Returns: Credentials, Error
+// Credentials is nil when no authentication secret is set in spec and bootstrap hasn't completed
+// Errors:
+//   ErrorReasonSpecInvalid: returned when secret doesn't exist or doesn't contain credentials
+//   ErrorReasonResourceUpdate: returned when secret was annotated to be watched
+//   ErrorReasonUnknown: returned when unhandled error from client occurs
+func (r *ReconcileSonarQube) getAPICredentials(cr *sonarsourcev1alpha1.SonarQube) (*api_client.Credentials, error) {
+	var name string
+	if cr.Spec.AuthSecret != nil {
+		name = *cr.Spec.AuthSecret
+	} else if cr.Status.Admin.Result == sonarsourcev1alpha1.BootstrapCompleted {
+		name = cr.Status.Admin.Secret
+	} else {
+		return nil, nil
+	}
+
+	secret := &corev1.Secret{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: cr.Namespace}, secret)
+	if err != nil && errors.IsNotFound(err) {
+		return nil, &utils.Error{
+			Reason:  utils.ErrorReasonSpecInvalid,
+			Message: fmt.Sprintf("authentication secret %s doesn't exist", name),
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !utils.IsOwner(cr, secret) {
+		if err := r.watchSecret(cr, secret, sonarsourcev1alpha1.ServerSecretAnnotation); err != nil {
+			return nil, err
+		}
+	}
+
+	if token := secret.Data[sonarsourcev1alpha1.AuthSecretToken]; len(token) > 0 {
+		return &api_client.Credentials{
+			Token: string(token),
+		}, nil
+	}
+
+	username := secret.Data[sonarsourcev1alpha1.AuthSecretUsername]
+	password := secret.Data[sonarsourcev1alpha1.AuthSecretPassword]
+	if len(username) > 0 && len(password) > 0 {
+		return &api_client.Credentials{
+			Username: string(username),
+			Password: string(password),
+		}, nil
+	}
+
+	return nil, &utils.Error{
+		Reason: utils.ErrorReasonSpecInvalid,
+		Message: fmt.Sprintf("authentication secret %s must contain %s or %s and %s", name,
+			sonarsourcev1alpha1.AuthSecretToken, sonarsourcev1alpha1.AuthSecretUsername, sonarsourcev1alpha1.AuthSecretPassword),
+	}
+}
+
+// Reconciles admin bootstrap for SonarQube
+// When no authentication secret is set in spec, the default admin password is changed to a generated one
+// and a token is generated for the operator. Both are stored in the admin secret.
+// Returns: Error
+// If Error is non-nil, bootstrap hasn't completed
+// Errors:
+//   ErrorReasonResourceCreate: returned when admin secret does not exists
+//   ErrorReasonResourceInvalid: returned when admin secret doesn't contain a password
+//   ErrorReasonResourceUpdate: returned when operator token was stored in admin secret
+//   ErrorReasonUnknown: returned when unhandled error from client or api occurs
+func (r *ReconcileSonarQube) reconcileAdmin(cr *sonarsourcev1alpha1.SonarQube, url string, tlsConfig *tls.Config) error {
+	if cr.Spec.AuthSecret != nil || cr.Status.Admin.Result != "" {
+		return nil
+	}
+
+	secret, err := r.findAdminSecret(cr)
+	if err != nil {
+		return err
+	}
+
+	password := string(secret.Data[sonarsourcev1alpha1.AuthSecretPassword])
+	if password == "" {
+		return &utils.Error{
+			Reason:  utils.ErrorReasonResourceInvalid,
+			Message: fmt.Sprintf("admin secret %s must contain %s", secret.Name, sonarsourcev1alpha1.AuthSecretPassword),
+		}
+	}
+
+	// Default credentials are rejected when the password was already changed by a previous reconcile
+	err = r.apiClient.New(url, &api_client.Credentials{
+		Username: api_client.DefaultAdminLogin,
+		Password: api_client.DefaultAdminPassword,
+	}, tlsConfig).ChangePassword(api_client.DefaultAdminLogin, api_client.DefaultAdminPassword, password)
+	if _, ok := err.(*api_client.AuthenticationError); !ok && err != nil {
+		return err
+	}
+
+	admin := r.apiClient.New(url, &api_client.Credentials{
+		Username: api_client.DefaultAdminLogin,
+		Password: password,
+	}, tlsConfig)
+
+	// A token generated by a reconcile that failed to store it is revoked so tokens don't pile up
+	var token *api_client.UserToken
+	err = admin.RevokeToken(OperatorTokenName)
+	if err == nil {
+		token, err = admin.GenerateToken(OperatorTokenName)
+	}
+	if _, ok := err.(*api_client.AuthenticationError); ok {
+		r.updateAdminStatus(cr, secret.Name, sonarsourcev1alpha1.BootstrapSkipped, "admin password was changed outside of the operator, set authSecret to manage sonarqube")
+		return nil
+	} else if err != nil {
+		return err
+	} else if token == nil {
+		return fmt.Errorf("nil returned for token")
+	}
+
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+	secret.Data[sonarsourcev1alpha1.AuthSecretToken] = []byte(token.Token)
+	err = r.client.Update(context.TODO(), secret)
+	if err != nil {
+		return err
+	}
+
+	r.updateAdminStatus(cr, secret.Name, sonarsourcev1alpha1.BootstrapCompleted, "")
+
+	return &utils.Error{
+		Reason:  utils.ErrorReasonResourceUpdate,
+		Message: fmt.Sprintf("stored operator token in secret %s", secret.Name),
+	}
+}
+
+func (r *ReconcileSonarQube) updateAdminStatus(cr *sonarsourcev1alpha1.SonarQube, secret string, result sonarsourcev1alpha1.BootstrapResult, message string) {
+	newStatus := cr.DeepCopy()
+	newStatus.Status.Admin = sonarsourcev1alpha1.AdminStatus{
+		Secret:  secret,
+		Result:  result,
+		Message: message,
+	}
+	utils.UpdateStatus(r.client, newStatus, cr)
+}
+
+func (r *ReconcileSonarQube) findAdminSecret(cr *sonarsourcev1alpha1.SonarQube) (*corev1.Secret, error) {
+	newSecret, err := r.newAdminSecret(cr)
+	if err != nil {
+		return newSecret, err
+	}
+
+	foundSecret := &corev1.Secret{}
+
+	return foundSecret, utils.ApplyResource(r.client, r.scheme, newSecret, foundSecret, "")
+}
+
+func (r *ReconcileSonarQube) newAdminSecret(cr *sonarsourcev1alpha1.SonarQube) (*corev1.Secret, error) {
+	labels := r.Labels(cr)
+
+	password, err := utils.GenPassword(AdminPasswordLength)
+	if err != nil {
+		return nil, err
+	}
+
+	dep := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: cr.Namespace,
+			Name:      fmt.Sprintf("%s-admin", cr.Name),
+			Labels:    labels,
+		},
+		Data: map[string][]byte{
+			sonarsourcev1alpha1.AuthSecretUsername: []byte(api_client.DefaultAdminLogin),
+			sonarsourcev1alpha1.AuthSecretPassword: []byte(password),
+		},
+		Type: corev1.SecretTypeOpaque,
+	}
+
+	if err := controllerutil.SetControllerReference(cr, dep, r.scheme); err != nil {
+		return dep, err
+	}
+
+	return dep, nil
+}
@@ -0,0 +1,276 @@
+package sonarqube
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jlfowle/sonarqube-operator/pkg/api_client"
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+// TestSonarQubeAPICredentials runs ReconcileSonarQube.getAPICredentials() against a
+// fake client
+func TestSonarQubeAPICredentials(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name      = "sonarqube-operator"
+		namespace = "sonarqube"
+	)
+
+	// A SonarQube resource with metadata and spec.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{},
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
+	// Create a fake client to mock API calls.
+	cl := newFakeClient(s, objs...)
+	// Create a ReconcileSonarQube object with the scheme and fake client.
+	apiMock := &api_client.APIClientMock{}
+	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: apiMock}
+
+	credentials, err := r.getAPICredentials(sonarqube)
+	if err != nil || credentials != nil {
+		t.Error("getAPICredentials: credentials returned without authentication secret")
+	}
+
+	sonarqube.Spec.AuthSecret = &[]string{"sonarqube-auth"}[0]
+	_, err = r.getAPICredentials(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonSpecInvalid {
+		t.Error("getAPICredentials: spec invalid error not thrown when secret doesn't exist")
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      *sonarqube.Spec.AuthSecret,
+		},
+		Data: map[string][]byte{},
+	}
+	err = r.client.Create(context.TODO(), secret)
+	if err != nil {
+		t.Fatalf("getAPICredentials: (%v)", err)
+	}
+
+	_, err = r.getAPICredentials(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceUpdate {
+		t.Error("getAPICredentials: resource update error not thrown when annotating secret")
+	}
+
+	_, err = r.getAPICredentials(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonSpecInvalid {
+		t.Error("getAPICredentials: spec invalid error not thrown when secret has no credentials")
+	}
+
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: secret.Name, Namespace: namespace}, secret)
+	if err != nil {
+		t.Fatalf("getAPICredentials: (%v)", err)
+	}
+	secret.Data[sonarsourcev1alpha1.AuthSecretUsername] = []byte("admin")
+	secret.Data[sonarsourcev1alpha1.AuthSecretPassword] = []byte("admin")
+	err = r.client.Update(context.TODO(), secret)
+	if err != nil {
+		t.Fatalf("getAPICredentials: (%v)", err)
+	}
+
+	credentials, err = r.getAPICredentials(sonarqube)
+	if err != nil || credentials == nil || credentials.Username != "admin" || credentials.Password != "admin" {
+		t.Error("getAPICredentials: username and password not returned")
+	}
+
+	secret.Data[sonarsourcev1alpha1.AuthSecretToken] = []byte("token")
+	err = r.client.Update(context.TODO(), secret)
+	if err != nil {
+		t.Fatalf("getAPICredentials: (%v)", err)
+	}
+
+	credentials, err = r.getAPICredentials(sonarqube)
+	if err != nil || credentials == nil || credentials.Token != "token" {
+		t.Error("getAPICredentials: token not preferred over username and password")
+	}
+
+	apiMock.InfoError = &api_client.AuthenticationError{StatusCode: 401, Path: "/api/system/status"}
+	_, err = r.verifyServerStatus(sonarqube, apiMock)
+	if utils.ReasonForError(err) != utils.ErrorReasonServerAuth {
+		t.Error("verifyServerStatus: server auth error not thrown when credentials are rejected")
+	}
+}
+
+// TestSonarQubeAdminBootstrap runs ReconcileSonarQube.reconcileAdmin() against a
+// fake client and a stand-in SonarQube api
+func TestSonarQubeAdminBootstrap(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name           = "sonarqube-operator"
+		namespace      = "sonarqube"
+		namespacedName = types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		}
+		adminPassword = api_client.DefaultAdminPassword
+		tokens        = map[string]bool{}
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		username, password, _ := req.BasicAuth()
+		switch {
+		case req.URL.Path == "/api/users/change_password" && password == adminPassword:
+			adminPassword = req.FormValue("password")
+			w.WriteHeader(http.StatusNoContent)
+		case req.URL.Path == "/api/user_tokens/revoke" && password == adminPassword:
+			delete(tokens, req.FormValue("name"))
+			w.WriteHeader(http.StatusNoContent)
+		case req.URL.Path == "/api/user_tokens/generate" && tokens[req.FormValue("name")]:
+			w.WriteHeader(http.StatusBadRequest)
+		case req.URL.Path == "/api/user_tokens/generate" && password == adminPassword:
+			tokens[req.FormValue("name")] = true
+			w.Write([]byte(fmt.Sprintf(`{"login":"%s","name":"%s","token":"operator-token"}`, username, req.FormValue("name"))))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	// A SonarQube resource with metadata and spec.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{},
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
+	// Create a fake client to mock API calls.
+	cl := newFakeClient(s, objs...)
+	// Create a ReconcileSonarQube object with the scheme, fake client and real api client.
+	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: &api_client.APIClient{}}
+
+	err := r.reconcileAdmin(sonarqube, server.URL, nil)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceCreate {
+		t.Error("reconcileAdmin: resource create error not thrown when creating admin secret")
+	}
+
+	err = r.reconcileAdmin(sonarqube, server.URL, nil)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceUpdate {
+		t.Errorf("reconcileAdmin: resource update error not thrown when storing token (%v)", err)
+	}
+	if adminPassword == "" || adminPassword == api_client.DefaultAdminPassword || len(adminPassword) != AdminPasswordLength {
+		t.Errorf("reconcileAdmin: default admin password not changed to a generated password (%q)", adminPassword)
+	}
+
+	secret := &corev1.Secret{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: fmt.Sprintf("%s-admin", name), Namespace: namespace}, secret)
+	if err != nil {
+		t.Fatalf("reconcileAdmin: (%v)", err)
+	}
+	if string(secret.Data[sonarsourcev1alpha1.AuthSecretPassword]) != adminPassword {
+		t.Error("reconcileAdmin: generated password not stored in admin secret")
+	}
+	if string(secret.Data[sonarsourcev1alpha1.AuthSecretToken]) != "operator-token" {
+		t.Error("reconcileAdmin: token not stored in admin secret")
+	}
+
+	err = r.client.Get(context.TODO(), namespacedName, sonarqube)
+	if err != nil {
+		t.Fatalf("reconcileAdmin: (%v)", err)
+	}
+	if sonarqube.Status.Admin.Result != sonarsourcev1alpha1.BootstrapCompleted || sonarqube.Status.Admin.Secret != secret.Name {
+		t.Error("reconcileAdmin: bootstrap not recorded in status")
+	}
+
+	credentials, err := r.getAPICredentials(sonarqube)
+	if err != nil || credentials == nil || credentials.Token != "operator-token" {
+		t.Error("getAPICredentials: operator token not returned after bootstrap")
+	}
+
+	// Status write lost after the token was stored
+	sonarqube.Status.Admin = sonarsourcev1alpha1.AdminStatus{}
+	err = r.client.Status().Update(context.TODO(), sonarqube)
+	if err != nil {
+		t.Fatalf("reconcileAdmin: (%v)", err)
+	}
+
+	err = r.reconcileAdmin(sonarqube, server.URL, nil)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceUpdate {
+		t.Errorf("reconcileAdmin: resource update error not thrown when storing token again (%v)", err)
+	}
+	if len(tokens) != 1 || !tokens[OperatorTokenName] {
+		t.Errorf("reconcileAdmin: operator token not revoked before generating a new one (%v)", tokens)
+	}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: secret.Name, Namespace: namespace}, secret)
+	if err != nil {
+		t.Fatalf("reconcileAdmin: (%v)", err)
+	}
+
+	// Password changed outside of the operator
+	sonarqube.Status.Admin = sonarsourcev1alpha1.AdminStatus{}
+	err = r.client.Status().Update(context.TODO(), sonarqube)
+	if err != nil {
+		t.Fatalf("reconcileAdmin: (%v)", err)
+	}
+	adminPassword = "changed"
+
+	err = r.reconcileAdmin(sonarqube, server.URL, nil)
+	if err != nil {
+		t.Errorf("reconcileAdmin: returned error when bootstrap is skipped (%v)", err)
+	}
+	err = r.client.Get(context.TODO(), namespacedName, sonarqube)
+	if err != nil {
+		t.Fatalf("reconcileAdmin: (%v)", err)
+	}
+	if sonarqube.Status.Admin.Result != sonarsourcev1alpha1.BootstrapSkipped {
+		t.Error("reconcileAdmin: skipped bootstrap not recorded in status")
+	}
+
+	// The default password is never changed to an empty one
+	sonarqube.Status.Admin = sonarsourcev1alpha1.AdminStatus{}
+	err = r.client.Status().Update(context.TODO(), sonarqube)
+	if err != nil {
+		t.Fatalf("reconcileAdmin: (%v)", err)
+	}
+	delete(secret.Data, sonarsourcev1alpha1.AuthSecretPassword)
+	err = r.client.Update(context.TODO(), secret)
+	if err != nil {
+		t.Fatalf("reconcileAdmin: (%v)", err)
+	}
+	adminPassword = api_client.DefaultAdminPassword
+
+	err = r.reconcileAdmin(sonarqube, server.URL, nil)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceInvalid {
+		t.Errorf("reconcileAdmin: resource invalid error not thrown for admin secret without password (%v)", err)
+	}
+	if adminPassword != api_client.DefaultAdminPassword {
+		t.Error("reconcileAdmin: admin password changed to an empty password")
+	}
+}
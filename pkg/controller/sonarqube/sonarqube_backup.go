@@ -0,0 +1,124 @@
+package sonarqube
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	"github.com/operator-framework/operator-sdk/pkg/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// Reconciles SonarQubeBackup scheduling the backups set in spec
+// Returns: Error
+// If Error is non-nil, SonarQubeBackup is not in expected state
+// Errors:
+//   ErrorReasonResourceCreate: returned when SonarQubeBackup does not exists
+//   ErrorReasonResourceUpdate: returned when SonarQubeBackup was updated or removed to meet expected state
+//   ErrorReasonUnknown: returned when unhandled error from client occurs
+func (r *ReconcileSonarQube) ReconcileBackup(cr *sonarsourcev1alpha1.SonarQube) error {
+	if cr.Spec.Backup == nil {
+		return r.removeBackup(cr)
+	}
+
+	backup := &sonarsourcev1alpha1.SonarQubeBackup{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: cr.Namespace,
+			Name:      cr.Name,
+			Labels:    r.Labels(cr),
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeBackupSpec{
+			SonarQube:           cr.Name,
+			Schedule:            cr.Spec.Backup.Schedule,
+			Retention:           cr.Spec.Backup.Retention,
+			VolumeSnapshotClass: cr.Spec.Backup.VolumeSnapshotClass,
+		},
+	}
+
+	if err := controllerutil.SetControllerReference(cr, backup, r.scheme); err != nil {
+		return err
+	}
+
+	return utils.ApplyResource(r.client, r.scheme, backup, &sonarsourcev1alpha1.SonarQubeBackup{}, "")
+}
+
+// removeBackup deletes the SonarQubeBackup owned by cr once backups are removed from spec, the CronJob is
+// garbage collected with it while the dumps and snapshots are kept
+func (r *ReconcileSonarQube) removeBackup(cr *sonarsourcev1alpha1.SonarQube) error {
+	backup := &sonarsourcev1alpha1.SonarQubeBackup{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: cr.Name, Namespace: cr.Namespace}, backup)
+	if err != nil && errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if !utils.IsOwner(cr, backup) {
+		return nil
+	}
+
+	if err := r.client.Delete(context.TODO(), backup); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	return &utils.Error{
+		Reason:  utils.ErrorReasonResourceUpdate,
+		Message: fmt.Sprintf("removed sonarqubebackup %s", backup.Name),
+	}
+}
+
+// verifyBackupAge checks a SonarQubeBackup of cr completed within UpdatesBackupMaxAge before an upgrade is started
+// Returns: true when the upgrade can start, otherwise the Upgrading condition reports the missing backup.
+// SonarQube is requeued by backupSonarQube when a backup completes
+func (r *ReconcileSonarQube) verifyBackupAge(cr *sonarsourcev1alpha1.SonarQube, to string) (bool, error) {
+	if cr.Spec.UpdatesBackupMaxAge == nil {
+		return true, nil
+	}
+
+	backups := &sonarsourcev1alpha1.SonarQubeBackupList{}
+	if err := r.client.List(context.TODO(), backups, client.InNamespace(cr.Namespace)); err != nil {
+		return false, err
+	}
+
+	maxAge := time.Duration(*cr.Spec.UpdatesBackupMaxAge) * time.Minute
+	for _, backup := range backups.Items {
+		if backup.Spec.SonarQube != cr.Name || backup.Status.LastBackupTime == nil {
+			continue
+		}
+		if time.Since(backup.Status.LastBackupTime.Time) <= maxAge {
+			return true, nil
+		}
+	}
+
+	newStatus := cr.DeepCopy()
+	newStatus.Status.Conditions.SetCondition(status.Condition{
+		Type:    sonarsourcev1alpha1.ConditionUpgrading,
+		Status:  corev1.ConditionFalse,
+		Reason:  sonarsourcev1alpha1.ConditionBackupRequired,
+		Message: fmt.Sprintf("upgrade to %s requires a backup completed within %d minutes", to, *cr.Spec.UpdatesBackupMaxAge),
+	})
+	utils.UpdateStatus(r.client, newStatus, cr)
+
+	return false, nil
+}
+
+// backupSonarQube maps a SonarQubeBackup to the SonarQube it backs up, backups created by users
+// aren't owned by the SonarQube but upgrades still wait for them
+func backupSonarQube(o handler.MapObject) []reconcile.Request {
+	backup, ok := o.Object.(*sonarsourcev1alpha1.SonarQubeBackup)
+	if !ok || backup.Spec.SonarQube == "" {
+		return nil
+	}
+	return []reconcile.Request{
+		{NamespacedName: types.NamespacedName{Namespace: backup.Namespace, Name: backup.Spec.SonarQube}},
+	}
+}
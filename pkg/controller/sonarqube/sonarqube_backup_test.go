@@ -0,0 +1,104 @@
+package sonarqube
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jlfowle/sonarqube-operator/pkg/api_client"
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/controller/sonarqubebackup"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+// TestSonarQubeRemoveBackup runs ReconcileSonarQube.ReconcileBackup() against a
+// fake client with backups removed from spec
+func TestSonarQubeRemoveBackup(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name           = "sonarqube-backup"
+		namespace      = "sonarqube"
+		namespacedName = types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		}
+		labels = map[string]string{sonarsourcev1alpha1.BackupLabel: name}
+	)
+
+	// A SonarQube resource with metadata and spec.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{
+			Backup: &sonarsourcev1alpha1.Backup{
+				Schedule: "0 0 * * *",
+			},
+		},
+	}
+	// Dumps and snapshots taken by the SonarQubeBackup
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name + "-dumps",
+			Namespace: namespace,
+			Labels:    labels,
+		},
+	}
+	snapshot := &unstructured.Unstructured{}
+	snapshot.SetGroupVersionKind(sonarqubebackup.VolumeSnapshotGVK)
+	snapshot.SetName("backup-1")
+	snapshot.SetNamespace(namespace)
+	snapshot.SetLabels(labels)
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+		pvc,
+		snapshot,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube, &sonarsourcev1alpha1.SonarQubeBackup{})
+	// Create a fake client to mock API calls.
+	cl := newFakeClient(s, objs...)
+	// Create a ReconcileSonarQube object with the scheme and fake client.
+	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: &api_client.APIClientMock{}}
+
+	err := r.ReconcileBackup(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceCreate {
+		t.Errorf("reconcileBackup: resource create error not thrown when creating sonarqubebackup (%v)", err)
+	}
+
+	sonarqube.Spec.Backup = nil
+	err = r.ReconcileBackup(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceUpdate {
+		t.Errorf("reconcileBackup: resource update error not thrown when removing sonarqubebackup (%v)", err)
+	}
+	err = r.client.Get(context.TODO(), namespacedName, &sonarsourcev1alpha1.SonarQubeBackup{})
+	if !errors.IsNotFound(err) {
+		t.Errorf("reconcileBackup: sonarqubebackup not removed (%v)", err)
+	}
+
+	// Backups are kept once they are no longer scheduled
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: pvc.Name, Namespace: namespace}, pvc); err != nil {
+		t.Errorf("reconcileBackup: dumps pvc removed with sonarqubebackup (%v)", err)
+	}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: snapshot.GetName(), Namespace: namespace}, snapshot); err != nil {
+		t.Errorf("reconcileBackup: snapshot removed with sonarqubebackup (%v)", err)
+	}
+
+	err = r.ReconcileBackup(sonarqube)
+	if err != nil {
+		t.Errorf("reconcileBackup: returned error once sonarqubebackup was removed (%v)", err)
+	}
+}
@@ -0,0 +1,355 @@
+package sonarqube
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	ClusterDefaultApplicationReplicas int32 = 2
+	ClusterDefaultSearchReplicas      int32 = 3
+	ClusterJWTSecretLength                  = 32
+)
+
+// Reconciles search nodes, headless Services and shared secret of a cluster for SonarQube
+// Returns: StatefulSet, Error
+// StatefulSet is the search nodes, nil when no cluster is set in spec
+// If Error is non-nil, cluster resources are not in expected state
+// Errors:
+//   ErrorReasonSpecInvalid: returned when the claims of search nodes can't be changed to the storage in spec
+//   ErrorReasonResourceCreate: returned when a cluster resource does not exists
+//   ErrorReasonResourceUpdate: returned when a cluster resource was updated to meet expected state
+//   ErrorReasonUnknown: returned when unhandled error from client occurs
+func (r *ReconcileSonarQube) ReconcileCluster(cr *sonarsourcev1alpha1.SonarQube) (*appsv1.StatefulSet, error) {
+	if cr.Spec.Cluster == nil {
+		return nil, nil
+	}
+
+	for _, service := range []*corev1.Service{
+		r.newHeadlessService(cr, applicationName(cr), r.SelectorLabels(cr), []corev1.ServicePort{
+			{
+				Name:     "node",
+				Protocol: corev1.ProtocolTCP,
+				Port:     sonarsourcev1alpha1.ApplicationPort,
+			},
+		}),
+		r.newHeadlessService(cr, searchName(cr), r.SearchSelectorLabels(cr), []corev1.ServicePort{
+			{
+				Name:     "search",
+				Protocol: corev1.ProtocolTCP,
+				Port:     sonarsourcev1alpha1.SearchPort,
+			},
+		}),
+	} {
+		if err := controllerutil.SetControllerReference(cr, service, r.scheme); err != nil {
+			return nil, err
+		}
+		if err := utils.ApplyResource(r.client, r.scheme, service, &corev1.Service{}, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	clusterSecret, err := r.newClusterSecret(cr)
+	if err != nil {
+		return nil, err
+	}
+	if err := utils.ApplyResource(r.client, r.scheme, clusterSecret, &corev1.Secret{}, ""); err != nil {
+		return nil, err
+	}
+
+	newStatefulSet, err := r.newSearchStatefulSet(cr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Selectors and volume claim templates can't change, search nodes created with an older label set keep
+	// their selector and the claims of existing search nodes are expanded instead
+	foundStatefulSet := &appsv1.StatefulSet{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: newStatefulSet.Name, Namespace: newStatefulSet.Namespace}, foundStatefulSet)
+	if err != nil && !errors.IsNotFound(err) {
+		return foundStatefulSet, err
+	} else if err == nil {
+		if err := r.resizeSearchPVCs(cr, newStatefulSet); err != nil {
+			return foundStatefulSet, err
+		}
+		newStatefulSet.Spec.VolumeClaimTemplates = foundStatefulSet.Spec.VolumeClaimTemplates
+		if foundStatefulSet.Spec.Selector != nil {
+			newStatefulSet.Spec.Selector = foundStatefulSet.Spec.Selector
+			for k, v := range foundStatefulSet.Spec.Selector.MatchLabels {
+				newStatefulSet.Spec.Template.Labels[k] = v
+			}
+		}
+	}
+
+	return foundStatefulSet, utils.ApplyResource(r.client, r.scheme, newStatefulSet, foundStatefulSet, "")
+}
+
+// resizeSearchPVCs expands the claims of search nodes created from the volume claim templates of sts
+// Errors:
+//   ErrorReasonSpecInvalid: returned when a claim can't be changed to its template, see verifyPVCResize
+//   ErrorReasonResourceUpdate: returned when a claim was expanded
+//   ErrorReasonUnknown: returned when unhandled error from client occurs
+func (r *ReconcileSonarQube) resizeSearchPVCs(cr *sonarsourcev1alpha1.SonarQube, sts *appsv1.StatefulSet) error {
+	for _, template := range sts.Spec.VolumeClaimTemplates {
+		for i := int32(0); i < *searchReplicas(cr); i++ {
+			foundPVC := &corev1.PersistentVolumeClaim{}
+			err := r.client.Get(context.TODO(), types.NamespacedName{Name: fmt.Sprintf("%s-%s-%d", template.Name, sts.Name, i), Namespace: sts.Namespace}, foundPVC)
+			if err != nil && errors.IsNotFound(err) {
+				continue
+			} else if err != nil {
+				return err
+			}
+
+			newPVC := &corev1.PersistentVolumeClaim{ObjectMeta: foundPVC.ObjectMeta, Spec: template.Spec}
+			if err := r.verifyPVCResize(newPVC, foundPVC); err != nil {
+				return err
+			}
+
+			requested := template.Spec.Resources.Requests[corev1.ResourceStorage]
+			current := foundPVC.Spec.Resources.Requests[corev1.ResourceStorage]
+			if requested.Cmp(current) <= 0 {
+				continue
+			}
+
+			if foundPVC.Spec.Resources.Requests == nil {
+				foundPVC.Spec.Resources.Requests = corev1.ResourceList{}
+			}
+			foundPVC.Spec.Resources.Requests[corev1.ResourceStorage] = requested
+			if err := r.client.Update(context.TODO(), foundPVC); err != nil {
+				return err
+			}
+
+			return &utils.Error{
+				Reason:  utils.ErrorReasonResourceUpdate,
+				Message: fmt.Sprintf("expanded persistentvolumeclaim %s to %s", foundPVC.Name, requested.String()),
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *ReconcileSonarQube) newSearchStatefulSet(cr *sonarsourcev1alpha1.SonarQube) (*appsv1.StatefulSet, error) {
+	serviceAccount, err := r.ReconcileServiceAccount(cr)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := r.ReconcileSecret(cr)
+	if err != nil {
+		return nil, err
+	}
+
+	pvcSpec, err := r.newPVCSpec(cr, VolumeStorage)
+	if err != nil {
+		return nil, err
+	}
+
+	podLabels := r.SearchPodLabels(cr)
+
+	// The storage volume is provided by the volume claim template
+	template, err := r.newPodTemplate(cr, sonarsourcev1alpha1.Search, podLabels, serviceAccount, secret, podVolumes(cr, nil))
+	if err != nil {
+		return nil, err
+	}
+	template.Name = searchName(cr)
+	template.Spec.Containers[0].Env = append(template.Spec.Containers[0].Env, clusterEnv(cr, sonarsourcev1alpha1.Search)...)
+
+	replicas := searchReplicas(cr)
+	if cr.Spec.Shutdown != nil && *cr.Spec.Shutdown {
+		replicas = &[]int32{0}[0]
+	}
+
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: cr.Namespace,
+			Name:      searchName(cr),
+			Labels:    r.SearchLabels(cr),
+		},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:    replicas,
+			ServiceName: searchName(cr),
+			// Search nodes must all be running to elect a master before any becomes ready
+			PodManagementPolicy: appsv1.ParallelPodManagement,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: r.SearchSelectorLabels(cr),
+			},
+			Template: *template,
+			VolumeClaimTemplates: []corev1.PersistentVolumeClaim{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:   "storage",
+						Labels: podLabels,
+					},
+					Spec: *pvcSpec,
+				},
+			},
+		},
+	}
+
+	if err := controllerutil.SetControllerReference(cr, sts, r.scheme); err != nil {
+		return sts, err
+	}
+
+	return sts, nil
+}
+
+// newHeadlessService returns a Service resolving to every node, including nodes that are not ready
+// so the cluster can form before the nodes pass their readiness probes
+func (r *ReconcileSonarQube) newHeadlessService(cr *sonarsourcev1alpha1.SonarQube, name string, selector map[string]string, ports []corev1.ServicePort) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: cr.Namespace,
+			Name:      name,
+			Labels:    r.Labels(cr),
+		},
+		Spec: corev1.ServiceSpec{
+			Selector:                 selector,
+			Type:                     corev1.ServiceTypeClusterIP,
+			ClusterIP:                corev1.ClusterIPNone,
+			PublishNotReadyAddresses: true,
+			Ports:                    ports,
+		},
+	}
+}
+
+// newClusterSecret returns the secret holding the JWT secret shared by the application nodes.
+// Data is only set when the secret is created
+func (r *ReconcileSonarQube) newClusterSecret(cr *sonarsourcev1alpha1.SonarQube) (*corev1.Secret, error) {
+	jwt, err := utils.GenPassword(ClusterJWTSecretLength)
+	if err != nil {
+		return nil, err
+	}
+
+	dep := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: cr.Namespace,
+			Name:      clusterSecretName(cr),
+			Labels:    r.PodLabels(cr),
+		},
+		StringData: map[string]string{
+			sonarsourcev1alpha1.ClusterSecretJWT: base64.StdEncoding.EncodeToString([]byte(jwt)),
+		},
+		Type: corev1.SecretTypeOpaque,
+	}
+
+	if err := controllerutil.SetControllerReference(cr, dep, r.scheme); err != nil {
+		return dep, err
+	}
+
+	return dep, nil
+}
+
+// clusterEnv returns the cluster env for nodes of a cluster managed from a single resource.
+// Hosts are resolved through the headless Services and the stable names of the search nodes
+func clusterEnv(cr *sonarsourcev1alpha1.SonarQube, nodeType sonarsourcev1alpha1.ServerType) []corev1.EnvVar {
+	env := []corev1.EnvVar{
+		{
+			Name:  "SONAR_CLUSTER_ENABLED",
+			Value: "true",
+		},
+		{
+			Name:  "SONAR_CLUSTER_NODE_TYPE",
+			Value: string(nodeType),
+		},
+		{
+			Name:  "SONAR_CLUSTER_SEARCH_HOSTS",
+			Value: strings.Join(searchHosts(cr), ","),
+		},
+		{
+			Name: "SONAR_CLUSTER_NODE_HOST",
+			ValueFrom: &corev1.EnvVarSource{
+				FieldRef: &corev1.ObjectFieldSelector{
+					APIVersion: "v1",
+					FieldPath:  "status.podIP",
+				},
+			},
+		},
+		{
+			Name: "SONAR_CLUSTER_NODE_NAME",
+			ValueFrom: &corev1.EnvVarSource{
+				FieldRef: &corev1.ObjectFieldSelector{
+					APIVersion: "v1",
+					FieldPath:  "metadata.name",
+				},
+			},
+		},
+	}
+
+	switch nodeType {
+	case sonarsourcev1alpha1.Application:
+		env = append(env,
+			corev1.EnvVar{
+				Name:  "SONAR_CLUSTER_HOSTS",
+				Value: applicationName(cr),
+			},
+			corev1.EnvVar{
+				Name: "SONAR_AUTH_JWTBASE64HS256SECRET",
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: clusterSecretName(cr)},
+						Key:                  sonarsourcev1alpha1.ClusterSecretJWT,
+					},
+				},
+			},
+		)
+	case sonarsourcev1alpha1.Search:
+		env = append(env, corev1.EnvVar{
+			Name: "SONAR_SEARCH_HOST",
+			ValueFrom: &corev1.EnvVarSource{
+				FieldRef: &corev1.ObjectFieldSelector{
+					APIVersion: "v1",
+					FieldPath:  "status.podIP",
+				},
+			},
+		})
+	}
+
+	return env
+}
+
+// searchHosts returns the stable dns names of the search nodes
+func searchHosts(cr *sonarsourcev1alpha1.SonarQube) []string {
+	var hosts []string
+	for i := int32(0); i < *searchReplicas(cr); i++ {
+		hosts = append(hosts, fmt.Sprintf("%s-%d.%s:%d", searchName(cr), i, searchName(cr), sonarsourcev1alpha1.SearchPort))
+	}
+	return hosts
+}
+
+func applicationReplicas(cr *sonarsourcev1alpha1.SonarQube) *int32 {
+	if cr.Spec.Cluster.ApplicationReplicas == nil {
+		return &[]int32{ClusterDefaultApplicationReplicas}[0]
+	}
+	return cr.Spec.Cluster.ApplicationReplicas
+}
+
+func searchReplicas(cr *sonarsourcev1alpha1.SonarQube) *int32 {
+	if cr.Spec.Cluster.SearchReplicas == nil {
+		return &[]int32{ClusterDefaultSearchReplicas}[0]
+	}
+	return cr.Spec.Cluster.SearchReplicas
+}
+
+func applicationName(cr *sonarsourcev1alpha1.SonarQube) string {
+	return fmt.Sprintf("%s-application", cr.Name)
+}
+
+func searchName(cr *sonarsourcev1alpha1.SonarQube) string {
+	return fmt.Sprintf("%s-search", cr.Name)
+}
+
+func clusterSecretName(cr *sonarsourcev1alpha1.SonarQube) string {
+	return fmt.Sprintf("%s-cluster", cr.Name)
+}
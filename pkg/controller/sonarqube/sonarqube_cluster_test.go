@@ -0,0 +1,494 @@
+package sonarqube
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/jlfowle/sonarqube-operator/pkg/api_client"
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+// TestSonarQubeCluster runs ReconcileSonarQube.ReconcileDeployment() against a
+// fake client for a cluster managed from a single resource
+func TestSonarQubeCluster(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name      = "sonarqube-operator"
+		namespace = "sonarqube"
+	)
+
+	// A SonarQube resource with metadata and spec.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{
+			Version: &[]string{"8.4.0"}[0],
+			Cluster: &sonarsourcev1alpha1.Cluster{},
+		},
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
+	// Create a fake client to mock API calls.
+	cl := newFakeClient(s, objs...)
+	// Create a ReconcileSonarQube object with the scheme and fake client.
+	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: &api_client.APIClientMock{}}
+
+	// The secret sets the revision used in the labels of the other dependencies
+	for {
+		_, err := r.ReconcileSecret(sonarqube)
+		if err != nil && utils.ReasonForError(err) == utils.ErrorReasonUnknown {
+			t.Fatalf("reconcileSecret: (%v)", err)
+		} else if err == nil {
+			break
+		}
+	}
+
+	var err error
+	for i := 0; i < 10; i++ {
+		_, err = r.ReconcileDeployment(sonarqube)
+		if err != nil && utils.ReasonForError(err) == utils.ErrorReasonUnknown {
+			t.Fatalf("reconcileDeployment: (%v)", err)
+		} else if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Errorf("reconcileDeployment: cluster resources not in expected state (%v)", err)
+	}
+
+	for _, service := range []string{name, applicationName(sonarqube), searchName(sonarqube)} {
+		err = r.client.Get(context.TODO(), types.NamespacedName{Name: service, Namespace: namespace}, &corev1.Service{})
+		if err != nil {
+			t.Errorf("reconcileDeployment: service %s not created (%v)", service, err)
+		}
+	}
+
+	searchService := &corev1.Service{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: searchName(sonarqube), Namespace: namespace}, searchService)
+	if err != nil {
+		t.Fatalf("reconcileDeployment: (%v)", err)
+	}
+	if searchService.Spec.ClusterIP != corev1.ClusterIPNone || searchService.Spec.Selector[sonarsourcev1alpha1.KubeAppComponent] != string(sonarsourcev1alpha1.Search) {
+		t.Error("reconcileDeployment: search service is not a headless service for search nodes")
+	}
+
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: clusterSecretName(sonarqube), Namespace: namespace}, &corev1.Secret{})
+	if err != nil {
+		t.Errorf("reconcileDeployment: cluster secret not created (%v)", err)
+	}
+
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, &corev1.PersistentVolumeClaim{})
+	if !errors.IsNotFound(err) {
+		t.Error("reconcileDeployment: persistent volume claim created for application nodes")
+	}
+
+	sts := &appsv1.StatefulSet{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: searchName(sonarqube), Namespace: namespace}, sts)
+	if err != nil {
+		t.Fatalf("reconcileDeployment: search nodes not created (%v)", err)
+	}
+	if *sts.Spec.Replicas != ClusterDefaultSearchReplicas || sts.Spec.ServiceName != searchName(sonarqube) || len(sts.Spec.VolumeClaimTemplates) != 1 {
+		t.Error("reconcileDeployment: unexpected search nodes spec")
+	}
+	if sts.Spec.Template.Spec.Containers[0].Image != utils.GetImage(&[]string{"datacenter-search"}[0], sonarqube.Spec.Version) {
+		t.Errorf("reconcileDeployment: unexpected search image %s", sts.Spec.Template.Spec.Containers[0].Image)
+	}
+	if len(sts.Spec.Template.Spec.InitContainers) != 0 {
+		t.Error("reconcileDeployment: plugins installed on search nodes")
+	}
+
+	deployment := &appsv1.Deployment{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, deployment)
+	if err != nil {
+		t.Fatalf("reconcileDeployment: application nodes not created (%v)", err)
+	}
+	if *deployment.Spec.Replicas != ClusterDefaultApplicationReplicas {
+		t.Error("reconcileDeployment: unexpected application replicas")
+	}
+	if deployment.Spec.Strategy.Type != appsv1.RecreateDeploymentStrategyType {
+		t.Errorf("reconcileDeployment: application nodes updated with %s strategy", deployment.Spec.Strategy.Type)
+	}
+	if len(deployment.Spec.Template.Spec.InitContainers) != 1 || deployment.Spec.Template.Spec.InitContainers[0].Image != deployment.Spec.Template.Spec.Containers[0].Image {
+		t.Error("reconcileDeployment: plugins not installed on application nodes")
+	}
+	env := make(map[string]corev1.EnvVar)
+	for _, e := range deployment.Spec.Template.Spec.Containers[0].Env {
+		env[e.Name] = e
+	}
+	if env["SONAR_CLUSTER_HOSTS"].Value != applicationName(sonarqube) {
+		t.Errorf("reconcileDeployment: unexpected cluster hosts %s", env["SONAR_CLUSTER_HOSTS"].Value)
+	}
+	expected := "sonarqube-operator-search-0.sonarqube-operator-search:9001,sonarqube-operator-search-1.sonarqube-operator-search:9001,sonarqube-operator-search-2.sonarqube-operator-search:9001"
+	if env["SONAR_CLUSTER_SEARCH_HOSTS"].Value != expected {
+		t.Errorf("reconcileDeployment: unexpected search hosts %s", env["SONAR_CLUSTER_SEARCH_HOSTS"].Value)
+	}
+	if env["SONAR_AUTH_JWTBASE64HS256SECRET"].ValueFrom == nil {
+		t.Error("reconcileDeployment: jwt secret not shared by application nodes")
+	}
+
+	// Every node reports its status
+	deployment.Status.Replicas = 2
+	deployment.Status.UpdatedReplicas = 2
+	deployment.Status.ReadyReplicas = 2
+	err = r.client.Status().Update(context.TODO(), deployment)
+	if err != nil {
+		t.Fatalf("reconcileDeployment: (%v)", err)
+	}
+	sts.Status.Replicas = 3
+	sts.Status.UpdatedReplicas = 3
+	sts.Status.ReadyReplicas = 2
+	err = r.client.Status().Update(context.TODO(), sts)
+	if err != nil {
+		t.Fatalf("reconcileDeployment: (%v)", err)
+	}
+
+	_, err = r.ReconcileDeployment(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceWaiting {
+		t.Errorf("reconcileDeployment: resource waiting error not thrown while search nodes start (%v)", err)
+	}
+	if ready := sonarqube.Status.Deployment[sonarsourcev1alpha1.DeploymentReady]; len(ready) != 1 || ready[0] != name {
+		t.Errorf("reconcileDeployment: application nodes not reported ready %v", ready)
+	}
+	if unavailable := sonarqube.Status.Deployment[sonarsourcev1alpha1.DeploymentUnavailable]; len(unavailable) != 1 || unavailable[0] != searchName(sonarqube) {
+		t.Errorf("reconcileDeployment: search nodes not reported unavailable %v", unavailable)
+	}
+
+	sts.Status.ReadyReplicas = 3
+	err = r.client.Status().Update(context.TODO(), sts)
+	if err != nil {
+		t.Fatalf("reconcileDeployment: (%v)", err)
+	}
+
+	_, err = r.ReconcileDeployment(sonarqube)
+	if err != nil {
+		t.Errorf("reconcileDeployment: returned error even though cluster is ready (%v)", err)
+	}
+}
+
+// TestSonarQubeClusterStorage runs ReconcileSonarQube.ReconcileCluster() against a
+// fake client with the storage of search nodes changed in spec
+func TestSonarQubeClusterStorage(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name      = "sonarqube-operator"
+		namespace = "sonarqube"
+	)
+
+	// A SonarQube resource with metadata and spec.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{
+			Version: &[]string{"8.4.0"}[0],
+			Cluster: &sonarsourcev1alpha1.Cluster{},
+			NodeConfig: sonarsourcev1alpha1.NodeConfig{
+				StorageClass: &[]string{"standard"}[0],
+				StorageSize:  &[]string{"1Gi"}[0],
+			},
+		},
+	}
+	storageClass := &storagev1.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "standard",
+		},
+		AllowVolumeExpansion: &[]bool{true}[0],
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+		storageClass,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
+	// Create a fake client to mock API calls.
+	cl := newFakeClient(s, objs...)
+	// Create a ReconcileSonarQube object with the scheme and fake client.
+	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: &api_client.APIClientMock{}}
+
+	reconcileCluster := func() error {
+		var err error
+		for i := 0; i < 10; i++ {
+			_, err = r.ReconcileCluster(sonarqube)
+			if err == nil || utils.ReasonForError(err) == utils.ErrorReasonSpecInvalid || utils.ReasonForError(err) == utils.ErrorReasonUnknown {
+				break
+			}
+		}
+		return err
+	}
+
+	// The secret sets the revision used in the labels of the other dependencies
+	for {
+		_, err := r.ReconcileSecret(sonarqube)
+		if err != nil && utils.ReasonForError(err) == utils.ErrorReasonUnknown {
+			t.Fatalf("reconcileSecret: (%v)", err)
+		} else if err == nil {
+			break
+		}
+	}
+
+	if err := reconcileCluster(); err != nil {
+		t.Fatalf("reconcileCluster: (%v)", err)
+	}
+
+	// The claim the StatefulSet controller created for the first search node
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("storage-%s-0", searchName(sonarqube)),
+			Namespace: namespace,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			StorageClassName: &[]string{"standard"}[0],
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: resource.MustParse("1Gi"),
+				},
+			},
+		},
+	}
+	if err := r.client.Create(context.TODO(), pvc); err != nil {
+		t.Fatalf("create pvc: (%v)", err)
+	}
+
+	sonarqube.Spec.NodeConfig.StorageSize = &[]string{"2Gi"}[0]
+	if err := r.client.Update(context.TODO(), sonarqube); err != nil {
+		t.Fatalf("update sonarqube: (%v)", err)
+	}
+	_, err := r.ReconcileCluster(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceUpdate {
+		t.Errorf("reconcileCluster: resource update error not thrown when expanding search pvc (%v)", err)
+	}
+	if err := reconcileCluster(); err != nil {
+		t.Errorf("reconcileCluster: returned error once search pvcs were expanded (%v)", err)
+	}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: pvc.Name, Namespace: namespace}, pvc)
+	if err != nil {
+		t.Fatalf("reconcileCluster: (%v)", err)
+	}
+	if size := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; size.String() != "2Gi" {
+		t.Errorf("reconcileCluster: search pvc request not expanded (%s)", size.String())
+	}
+
+	// Volume claim templates of a StatefulSet are immutable
+	sts := &appsv1.StatefulSet{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: searchName(sonarqube), Namespace: namespace}, sts)
+	if err != nil {
+		t.Fatalf("reconcileCluster: (%v)", err)
+	}
+	if size := sts.Spec.VolumeClaimTemplates[0].Spec.Resources.Requests[corev1.ResourceStorage]; size.String() != "1Gi" {
+		t.Errorf("reconcileCluster: volume claim template of search nodes changed to %s", size.String())
+	}
+
+	sonarqube.Spec.NodeConfig.StorageClass = &[]string{"fast"}[0]
+	if err := r.client.Update(context.TODO(), sonarqube); err != nil {
+		t.Fatalf("update sonarqube: (%v)", err)
+	}
+	if err := reconcileCluster(); utils.ReasonForError(err) != utils.ErrorReasonSpecInvalid {
+		t.Errorf("reconcileCluster: spec invalid error not thrown when changing storage class of search nodes (%v)", err)
+	}
+}
+
+// TestSonarQubeClusterSelector runs ReconcileSonarQube.ReconcileCluster() against a
+// fake client with search nodes created with an older label set
+func TestSonarQubeClusterSelector(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name      = "sonarqube-operator"
+		namespace = "sonarqube"
+	)
+
+	// A SonarQube resource with metadata and spec.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{
+			Version: &[]string{"8.4.0"}[0],
+			Cluster: &sonarsourcev1alpha1.Cluster{},
+		},
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
+	// Create a fake client to mock API calls.
+	cl := newFakeClient(s, objs...)
+	// Create a ReconcileSonarQube object with the scheme and fake client.
+	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: &api_client.APIClientMock{}}
+
+	reconcileCluster := func() error {
+		var err error
+		for i := 0; i < 10; i++ {
+			_, err = r.ReconcileCluster(sonarqube)
+			if err == nil || utils.ReasonForError(err) == utils.ErrorReasonSpecInvalid || utils.ReasonForError(err) == utils.ErrorReasonUnknown {
+				break
+			}
+		}
+		return err
+	}
+
+	// The secret sets the revision used in the labels of the other dependencies
+	reconcileSecret := func() {
+		for {
+			_, err := r.ReconcileSecret(sonarqube)
+			if err != nil && utils.ReasonForError(err) == utils.ErrorReasonUnknown {
+				t.Fatalf("reconcileSecret: (%v)", err)
+			} else if err == nil {
+				break
+			}
+		}
+	}
+
+	reconcileSecret()
+	if err := reconcileCluster(); err != nil {
+		t.Fatalf("reconcileCluster: (%v)", err)
+	}
+
+	sts := &appsv1.StatefulSet{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: searchName(sonarqube), Namespace: namespace}, sts)
+	if err != nil {
+		t.Fatalf("reconcileCluster: (%v)", err)
+	}
+	if len(sts.Spec.Selector.MatchLabels) != 3 {
+		t.Errorf("reconcileCluster: selector of search nodes not limited to the labels of the operator (%v)", sts.Spec.Selector.MatchLabels)
+	}
+
+	// Search nodes created before selectors left out the labels of the SonarQube resource
+	sts.Spec.Selector.MatchLabels["team"] = "qa"
+	sts.Spec.Template.Labels["team"] = "qa"
+	if err := r.client.Update(context.TODO(), sts); err != nil {
+		t.Fatalf("update statefulset: (%v)", err)
+	}
+
+	sonarqube.Labels = map[string]string{"team": "dev"}
+	if err := r.client.Update(context.TODO(), sonarqube); err != nil {
+		t.Fatalf("update sonarqube: (%v)", err)
+	}
+	reconcileSecret()
+	if err := reconcileCluster(); err != nil {
+		t.Fatalf("reconcileCluster: (%v)", err)
+	}
+
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: searchName(sonarqube), Namespace: namespace}, sts)
+	if err != nil {
+		t.Fatalf("reconcileCluster: (%v)", err)
+	}
+	if sts.Spec.Selector.MatchLabels["team"] != "qa" || sts.Spec.Template.Labels["team"] != "qa" {
+		t.Errorf("reconcileCluster: existing selector of search nodes not kept (%v)", sts.Spec.Selector.MatchLabels)
+	}
+}
+
+// TestSonarQubeClusterSwitch runs ReconcileSonarQube.ReconcileDeployment() against a
+// fake client with a cluster added to a single node
+func TestSonarQubeClusterSwitch(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name      = "sonarqube-operator"
+		namespace = "sonarqube"
+	)
+
+	// A SonarQube resource with metadata and spec.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{
+			Version: &[]string{"8.4.0"}[0],
+		},
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
+	// Create a fake client to mock API calls.
+	cl := newFakeClient(s, objs...)
+	// Create a ReconcileSonarQube object with the scheme and fake client.
+	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: &api_client.APIClientMock{}}
+
+	// Take care of dependencies and deployment, if there is an unkown error here there is not much to do
+	for {
+		_, err := r.ReconcileDeployment(sonarqube)
+		if err != nil && utils.ReasonForError(err) == utils.ErrorReasonUnknown {
+			t.Fatalf("reconcileDeployment: (%v)", err)
+		} else if err == nil {
+			break
+		}
+	}
+
+	// The selector of the single node can't select application nodes
+	sonarqube.Spec.Cluster = &sonarsourcev1alpha1.Cluster{}
+	if err := r.client.Update(context.TODO(), sonarqube); err != nil {
+		t.Fatalf("update sonarqube: (%v)", err)
+	}
+	_, err := r.ReconcileDeployment(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonSpecInvalid {
+		t.Errorf("reconcileDeployment: spec invalid error not thrown when adding a cluster to a single node (%v)", err)
+	}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: searchName(sonarqube), Namespace: namespace}, &appsv1.StatefulSet{})
+	if !errors.IsNotFound(err) {
+		t.Errorf("reconcileDeployment: search nodes created for a rejected cluster (%v)", err)
+	}
+
+	// Once the single node is deleted the cluster replaces it
+	if err := r.client.Delete(context.TODO(), &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}); err != nil {
+		t.Fatalf("delete deployment: (%v)", err)
+	}
+	for i := 0; i < 20; i++ {
+		_, err = r.ReconcileDeployment(sonarqube)
+		if err == nil || utils.ReasonForError(err) == utils.ErrorReasonSpecInvalid || utils.ReasonForError(err) == utils.ErrorReasonUnknown {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf("reconcileDeployment: (%v)", err)
+	}
+	deployment := &appsv1.Deployment{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, deployment)
+	if err != nil {
+		t.Fatalf("reconcileDeployment: (%v)", err)
+	}
+	if component := deployment.Spec.Selector.MatchLabels[sonarsourcev1alpha1.KubeAppComponent]; component != string(sonarsourcev1alpha1.Application) {
+		t.Errorf("reconcileDeployment: deployment doesn't select application nodes %s", component)
+	}
+}
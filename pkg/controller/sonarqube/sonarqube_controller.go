@@ -9,7 +9,10 @@ import (
 	"github.com/jlfowle/sonarqube-operator/pkg/utils"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
@@ -34,6 +37,7 @@ func newReconciler(mgr manager.Manager) reconcile.Reconciler {
 		client:    mgr.GetClient(),
 		scheme:    mgr.GetScheme(),
 		apiClient: &api_client.APIClient{},
+		recorder:  newEventRecorder(mgr.GetEventRecorderFor("sonarqube-controller")),
 	}
 }
 
@@ -60,6 +64,15 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
+	// Watch for changes to secondary resource StatefulSet and requeue the owner SonarQube
+	err = c.Watch(&source.Kind{Type: &appsv1.StatefulSet{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &sonarsourcev1alpha1.SonarQube{},
+	})
+	if err != nil {
+		return err
+	}
+
 	// Watch for changes to secondary resource Service and requeue the owner SonarQube
 	err = c.Watch(&source.Kind{Type: &corev1.Service{}}, &handler.EnqueueRequestForOwner{
 		IsController: true,
@@ -69,6 +82,31 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
+	// Watch for changes to secondary resource ServiceAccount and requeue the owner SonarQube
+	err = c.Watch(&source.Kind{Type: &corev1.ServiceAccount{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &sonarsourcev1alpha1.SonarQube{},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to secondary resources Role and RoleBinding and requeue the owner SonarQube
+	err = c.Watch(&source.Kind{Type: &rbacv1.Role{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &sonarsourcev1alpha1.SonarQube{},
+	})
+	if err != nil {
+		return err
+	}
+	err = c.Watch(&source.Kind{Type: &rbacv1.RoleBinding{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &sonarsourcev1alpha1.SonarQube{},
+	})
+	if err != nil {
+		return err
+	}
+
 	// Watch for changes to secondary resource Secret and requeue the owner SonarQube
 	err = c.Watch(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestForOwner{
 		IsController: true,
@@ -87,6 +125,50 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
+	// Watch for changes to secondary resource Ingress and requeue the owner SonarQube
+	err = c.Watch(&source.Kind{Type: &networkingv1beta1.Ingress{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &sonarsourcev1alpha1.SonarQube{},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to SonarQubeBackup and requeue the SonarQube in its spec, upgrades wait for backups
+	err = c.Watch(&source.Kind{Type: &sonarsourcev1alpha1.SonarQubeBackup{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(backupSonarQube),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to secondary resource Route and requeue the owner SonarQube, routes only exist on OpenShift
+	if _, err := mgr.GetRESTMapper().RESTMapping(RouteGVK.GroupKind(), RouteGVK.Version); err == nil {
+		err = c.Watch(&source.Kind{Type: newRoute()}, &handler.EnqueueRequestForOwner{
+			IsController: true,
+			OwnerType:    &sonarsourcev1alpha1.SonarQube{},
+		})
+		if err != nil {
+			return err
+		}
+	} else if !meta.IsNoMatchError(err) {
+		return err
+	}
+
+	// Watch for changes to secondary resource ServiceMonitor and requeue the owner SonarQube, service monitors only
+	// exist when prometheus-operator is installed
+	if _, err := mgr.GetRESTMapper().RESTMapping(ServiceMonitorGVK.GroupKind(), ServiceMonitorGVK.Version); err == nil {
+		err = c.Watch(&source.Kind{Type: newServiceMonitor()}, &handler.EnqueueRequestForOwner{
+			IsController: true,
+			OwnerType:    &sonarsourcev1alpha1.SonarQube{},
+		})
+		if err != nil {
+			return err
+		}
+	} else if !meta.IsNoMatchError(err) {
+		return err
+	}
+
 	// Watch for changes to secondary resource Secret and requeue the watcher
 	err = c.Watch(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestsFromMapFunc{
 		ToRequests: &utils.SecretMapper{Annotation: sonarsourcev1alpha1.ServerSecretAnnotation},
@@ -95,6 +177,22 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
+	// Watch for changes to database Secret and requeue the watcher
+	err = c.Watch(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: &utils.SecretMapper{Annotation: sonarsourcev1alpha1.SecretAnnotation},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to ConfigMaps read by SonarQube and requeue the watcher, they are annotated like secrets
+	err = c.Watch(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: &utils.SecretMapper{Annotation: sonarsourcev1alpha1.ConfigMapAnnotation},
+	})
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -108,6 +206,7 @@ type ReconcileSonarQube struct {
 	client    client.Client
 	scheme    *runtime.Scheme
 	apiClient api_client.APIProvider
+	recorder  *eventRecorder
 }
 
 // Reconcile reads that state of the cluster for a SonarQube object and makes changes based on the state read
@@ -124,6 +223,7 @@ func (r *ReconcileSonarQube) Reconcile(request reconcile.Request) (reconcile.Res
 			// Request object not found, could have been deleted after reconcile request.
 			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
 			// Return and don't requeue
+			servers.remove(request.NamespacedName)
 			return reconcile.Result{}, nil
 		}
 		// Error reading the object - requeue the request.
@@ -138,29 +238,52 @@ func (r *ReconcileSonarQube) Reconcile(request reconcile.Request) (reconcile.Res
 
 	_, err = r.ReconcileSecret(instance)
 	if err != nil {
-		return utils.ParseErrorForReconcileResult(r.client, instance, err)
+		return r.parseResult(instance, err)
 	}
 
 	_, err = r.ReconcileServiceAccount(instance)
 	if err != nil {
-		return utils.ParseErrorForReconcileResult(r.client, instance, err)
+		return r.parseResult(instance, err)
+	}
+
+	_, err = r.ReconcilePasscode(instance)
+	if err != nil {
+		return r.parseResult(instance, err)
 	}
 
 	_, err = r.ReconcileService(instance)
 	if err != nil {
-		return utils.ParseErrorForReconcileResult(r.client, instance, err)
+		return r.parseResult(instance, err)
+	}
+
+	err = r.ReconcileExpose(instance)
+	if err != nil {
+		return r.parseResult(instance, err)
+	}
+
+	err = r.ReconcileMonitoring(instance)
+	if err != nil {
+		return r.parseResult(instance, err)
 	}
 
 	_, err = r.ReconcileDeployment(instance)
 	if err != nil {
-		return utils.ParseErrorForReconcileResult(r.client, instance, err)
+		return r.parseResult(instance, err)
+	}
+
+	err = r.ReconcileBackup(instance)
+	if err != nil {
+		return r.parseResult(instance, err)
 	}
 
-	if (instance.Spec.Shutdown == nil || !*instance.Spec.Shutdown) && (instance.Spec.Type == nil || *instance.Spec.Type != sonarsourcev1alpha1.Search) {
+	if (instance.Spec.Shutdown == nil || !*instance.Spec.Shutdown) && nodeType(instance) != sonarsourcev1alpha1.Search {
 		err = r.ReconcileServer(instance)
 		if err != nil {
-			return utils.ParseErrorForReconcileResult(r.client, instance, err)
+			return r.parseResult(instance, err)
 		}
+	} else {
+		// There is no server to observe, its metrics would go stale
+		servers.remove(request.NamespacedName)
 	}
 
 	newStatus = instance.DeepCopy()
@@ -169,5 +292,5 @@ func (r *ReconcileSonarQube) Reconcile(request reconcile.Request) (reconcile.Res
 
 	utils.UpdateStatus(r.client, newStatus, instance)
 
-	return utils.ParseErrorForReconcileResult(r.client, instance, nil)
+	return r.parseResult(instance, nil)
 }
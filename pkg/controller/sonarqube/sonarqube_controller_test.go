@@ -2,7 +2,10 @@ package sonarqube
 
 import (
 	"context"
+	"fmt"
 	"github.com/jlfowle/sonarqube-operator/pkg/api_client"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils/fake"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -14,7 +17,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
-	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
 )
@@ -23,6 +26,10 @@ const (
 	ReconcileErrorFormat string = "reconcile: (%v)"
 )
 
+func newFakeClient(s *runtime.Scheme, objs ...runtime.Object) client.Client {
+	return fake.NewFakeClient(s, objs...)
+}
+
 // TestSonarQubeController runs ReconcileSonarQube.Reconcile() against a
 // fake client that tracks a SonarQube object.
 func TestSonarQubeController(t *testing.T) {
@@ -49,18 +56,27 @@ func TestSonarQubeController(t *testing.T) {
 
 	// Register operator types with the runtime scheme.
 	s := scheme.Scheme
-	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube, &sonarsourcev1alpha1.SonarQubeBackup{}, &sonarsourcev1alpha1.SonarQubeBackupList{})
 	// Create a fake client to mock API calls.
-	cl := fake.NewFakeClientWithScheme(s, objs...)
+	cl := newFakeClient(s, objs...)
 	// Create a ReconcileSonarQube object with the scheme and fake client.
 	apiMock := &api_client.APIClientMock{}
 	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: apiMock}
 	apiMock.InfoOutput = &api_client.Status{
+		Status: api_client.SystemUp,
 		Version: api_client.SystemVersion{
 			Major: 8,
 			Minor: 3,
 		},
 	}
+	apiMock.HealthOutput = &api_client.Health{
+		Health: api_client.HealthGreen,
+	}
+	apiMock.GenerateTokenOutput = &api_client.UserToken{
+		Login: api_client.DefaultAdminLogin,
+		Name:  OperatorTokenName,
+		Token: "operator-token",
+	}
 
 	// Mock request to simulate Reconcile() being called on an event for a
 	// watched resource .
@@ -126,6 +142,29 @@ func TestSonarQubeController(t *testing.T) {
 		t.Fatalf(ReconcileErrorFormat, err)
 	}
 
+	res, err = r.Reconcile(req)
+	if err != nil {
+		t.Fatalf(ReconcileErrorFormat, err)
+	}
+	// Check the result of reconciliation to make sure it has the desired state.
+	if !res.Requeue {
+		t.Error("reconcile did not requeue")
+	}
+	err = r.client.Get(context.TODO(), req.NamespacedName, sonarqube)
+	if err != nil {
+		t.Fatalf(ReconcileErrorFormat, err)
+	}
+	if !sonarqube.Status.Conditions.IsTrueFor(sonarsourcev1alpha1.ConditionProgressing) {
+		t.Errorf("condition progressing not set")
+	}
+	passcodeSecret := &corev1.Secret{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: sonarqube.Name + "-passcode", Namespace: sonarqube.Namespace}, passcodeSecret)
+	if err != nil && errors.IsNotFound(err) {
+		t.Error("reconcile: passcode secret not created")
+	} else if err != nil {
+		t.Fatalf(ReconcileErrorFormat, err)
+	}
+
 	res, err = r.Reconcile(req)
 	if err != nil {
 		t.Fatalf(ReconcileErrorFormat, err)
@@ -204,6 +243,38 @@ func TestSonarQubeController(t *testing.T) {
 		t.Fatalf("reconcileDeployment: (%v)", err)
 	}
 
+	res, err = r.Reconcile(req)
+	if err != nil {
+		t.Fatalf(ReconcileErrorFormat, err)
+	}
+	// Check the result of reconciliation to make sure it has the desired state.
+	if !res.Requeue {
+		t.Error("reconcile did not requeue to create admin secret")
+	}
+	adminSecret := &corev1.Secret{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: fmt.Sprintf("%s-admin", sonarqube.Name), Namespace: namespace}, adminSecret)
+	if err != nil && errors.IsNotFound(err) {
+		t.Error("reconcile: admin secret not created")
+	} else if err != nil {
+		t.Fatalf(ReconcileErrorFormat, err)
+	}
+
+	res, err = r.Reconcile(req)
+	if err != nil {
+		t.Fatalf(ReconcileErrorFormat, err)
+	}
+	// Check the result of reconciliation to make sure it has the desired state.
+	if !res.Requeue {
+		t.Error("reconcile did not requeue to store operator token")
+	}
+	err = r.client.Get(context.TODO(), req.NamespacedName, sonarqube)
+	if err != nil {
+		t.Fatalf(ReconcileErrorFormat, err)
+	}
+	if sonarqube.Status.Admin.Result != sonarsourcev1alpha1.BootstrapCompleted {
+		t.Error("admin bootstrap not recorded in status")
+	}
+
 	res, err = r.Reconcile(req)
 	if err != nil {
 		t.Fatalf(ReconcileErrorFormat, err)
@@ -212,6 +283,9 @@ func TestSonarQubeController(t *testing.T) {
 	if !res.Requeue {
 		t.Error("reconcile did not requeue to set version")
 	}
+	if apiMock.Credentials == nil || apiMock.Credentials.Token != "operator-token" {
+		t.Error("operator token not used for api calls")
+	}
 	err = r.client.Get(context.TODO(), req.NamespacedName, sonarqube)
 	if err != nil {
 		t.Fatalf(ReconcileErrorFormat, err)
@@ -225,6 +299,31 @@ func TestSonarQubeController(t *testing.T) {
 		UpdateCenterRefresh: "",
 	}
 
+	// Locking the version changes the revision, service and deployment are updated to match
+	for i := 0; i < 5; i++ {
+		res, err = r.Reconcile(req)
+		if err != nil {
+			t.Fatalf(ReconcileErrorFormat, err)
+		}
+		if !res.Requeue {
+			break
+		}
+	}
+	err = r.client.Get(context.TODO(), req.NamespacedName, sonarqube)
+	if err != nil {
+		t.Fatalf(ReconcileErrorFormat, err)
+	}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: sonarqube.Name, Namespace: namespace}, deployment)
+	if err != nil {
+		t.Fatalf(ReconcileErrorFormat, err)
+	}
+	if deployment.Spec.Template.Spec.Containers[0].Image != utils.GetImage(sonarqube.Spec.Edition, sonarqube.Spec.Version) {
+		t.Error("deployment image not updated to locked version")
+	}
+	if revision(deployment) != sonarqube.Status.Revision {
+		t.Error("deployment revision not updated")
+	}
+
 	res, err = r.Reconcile(req)
 	if err != nil {
 		t.Fatalf(ReconcileErrorFormat, err)
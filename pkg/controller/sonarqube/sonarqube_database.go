@@ -0,0 +1,82 @@
+package sonarqube
+
+import (
+	"context"
+	"fmt"
+
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Reconciles database connection for SonarQube
+// Returns: EnvVars, Error
+// EnvVars is nil when no database is set in spec
+// If Error is non-nil, database connection is not configured
+// Errors:
+//   ErrorReasonSpecInvalid: returned when spec has no url or host, or secret doesn't exist or doesn't contain credentials
+//   ErrorReasonResourceUpdate: returned when secret was annotated to be watched
+//   ErrorReasonUnknown: returned when unhandled error from client occurs
+func (r *ReconcileSonarQube) ReconcileDatabase(cr *sonarsourcev1alpha1.SonarQube) ([]corev1.EnvVar, error) {
+	if cr.Spec.Database == nil {
+		return nil, nil
+	}
+
+	url, err := utils.GetJDBCURL(cr.Spec.Database)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := &corev1.Secret{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: cr.Spec.Database.Secret, Namespace: cr.Namespace}, secret)
+	if err != nil && errors.IsNotFound(err) {
+		return nil, &utils.Error{
+			Reason:  utils.ErrorReasonSpecInvalid,
+			Message: fmt.Sprintf("database secret %s doesn't exist", cr.Spec.Database.Secret),
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !utils.IsOwner(cr, secret) {
+		if err := r.watchSecret(cr, secret, sonarsourcev1alpha1.SecretAnnotation); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, key := range []string{sonarsourcev1alpha1.DatabaseSecretUsername, sonarsourcev1alpha1.DatabaseSecretPassword} {
+		if _, ok := secret.Data[key]; !ok {
+			return nil, &utils.Error{
+				Reason:  utils.ErrorReasonSpecInvalid,
+				Message: fmt.Sprintf("database secret %s must contain %s", secret.Name, key),
+			}
+		}
+	}
+
+	return []corev1.EnvVar{
+		{
+			Name:  "SONAR_JDBC_URL",
+			Value: url,
+		},
+		{
+			Name: "SONAR_JDBC_USERNAME",
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: secret.Name},
+					Key:                  sonarsourcev1alpha1.DatabaseSecretUsername,
+				},
+			},
+		},
+		{
+			Name: "SONAR_JDBC_PASSWORD",
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: secret.Name},
+					Key:                  sonarsourcev1alpha1.DatabaseSecretPassword,
+				},
+			},
+		},
+	}, nil
+}
@@ -0,0 +1,131 @@
+package sonarqube
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jlfowle/sonarqube-operator/pkg/api_client"
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+// TestSonarQubeDatabase runs ReconcileSonarQube.ReconcileDatabase() against a
+// fake client
+func TestSonarQubeDatabase(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name      = "sonarqube-operator"
+		namespace = "sonarqube"
+	)
+
+	// A SonarQube resource with metadata and spec.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{},
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
+	// Create a fake client to mock API calls.
+	cl := newFakeClient(s, objs...)
+	// Create a ReconcileSonarQube object with the scheme and fake client.
+	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: &api_client.APIClientMock{}}
+
+	env, err := r.ReconcileDatabase(sonarqube)
+	if err != nil || env != nil {
+		t.Error("ReconcileDatabase: env returned without database")
+	}
+
+	sonarqube.Spec.Database = &sonarsourcev1alpha1.Database{
+		Secret: "sonarqube-db",
+	}
+	_, err = r.ReconcileDatabase(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonSpecInvalid {
+		t.Error("ReconcileDatabase: spec invalid error not thrown without url or host")
+	}
+
+	sonarqube.Spec.Database.Host = &[]string{"postgres"}[0]
+	_, err = r.ReconcileDatabase(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonSpecInvalid {
+		t.Error("ReconcileDatabase: spec invalid error not thrown when secret doesn't exist")
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      sonarqube.Spec.Database.Secret,
+		},
+		Data: map[string][]byte{
+			sonarsourcev1alpha1.DatabaseSecretUsername: []byte("sonar"),
+		},
+	}
+	err = r.client.Create(context.TODO(), secret)
+	if err != nil {
+		t.Fatalf("ReconcileDatabase: (%v)", err)
+	}
+
+	_, err = r.ReconcileDatabase(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceUpdate {
+		t.Error("ReconcileDatabase: resource update error not thrown when annotating secret")
+	}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: secret.Name, Namespace: namespace}, secret)
+	if err != nil {
+		t.Fatalf("ReconcileDatabase: (%v)", err)
+	}
+	if secret.Annotations[sonarsourcev1alpha1.SecretAnnotation] != name {
+		t.Error("ReconcileDatabase: secret not annotated to be watched")
+	}
+
+	_, err = r.ReconcileDatabase(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonSpecInvalid {
+		t.Error("ReconcileDatabase: spec invalid error not thrown when secret is missing password")
+	}
+
+	secret.Data[sonarsourcev1alpha1.DatabaseSecretPassword] = []byte("sonar")
+	err = r.client.Update(context.TODO(), secret)
+	if err != nil {
+		t.Fatalf("ReconcileDatabase: (%v)", err)
+	}
+
+	env, err = r.ReconcileDatabase(sonarqube)
+	if err != nil {
+		t.Fatalf("ReconcileDatabase: (%v)", err)
+	}
+	for _, e := range env {
+		switch e.Name {
+		case "SONAR_JDBC_URL":
+			if e.Value != "jdbc:postgresql://postgres:5432/sonarqube" {
+				t.Errorf("ReconcileDatabase: unexpected jdbc url %s", e.Value)
+			}
+		case "SONAR_JDBC_USERNAME", "SONAR_JDBC_PASSWORD":
+			if e.ValueFrom == nil || e.ValueFrom.SecretKeyRef == nil || e.ValueFrom.SecretKeyRef.Name != secret.Name {
+				t.Errorf("ReconcileDatabase: %s not set from secret", e.Name)
+			}
+		}
+	}
+	if len(env) != 3 {
+		t.Error("ReconcileDatabase: jdbc env not returned")
+	}
+
+	sonarqube.Spec.Database.URL = &[]string{"jdbc:postgresql://external:5433/sonar"}[0]
+	env, err = r.ReconcileDatabase(sonarqube)
+	if err != nil || len(env) != 3 || env[0].Value != *sonarqube.Spec.Database.URL {
+		t.Error("ReconcileDatabase: url from spec not preferred over host")
+	}
+}
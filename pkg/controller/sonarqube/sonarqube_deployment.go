@@ -1,15 +1,20 @@
 package sonarqube
 
 import (
+	"context"
 	"fmt"
 	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
 	"github.com/jlfowle/sonarqube-operator/pkg/utils"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/types"
 	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sort"
 	"strings"
 )
 
@@ -25,23 +30,37 @@ const (
 // Returns: Deployment, Error
 // If Error is non-nil, Deployment is not in expected state
 // Errors:
+//   ErrorReasonSpecInvalid: returned when the node type of an existing Deployment is changed, see verifyNodeType
 //   ErrorReasonResourceCreate: returned when Deployment does not exists
 //   ErrorReasonResourceUpdate: returned when Deployment was updated to meet expected state
 //   ErrorReasonUnknown: returned when unhandled error from client occurs
 func (r *ReconcileSonarQube) ReconcileDeployment(cr *sonarsourcev1alpha1.SonarQube) (*appsv1.Deployment, error) {
-	deployment, err := r.findDeployment(cr)
+	if err := r.verifyNodeType(cr); err != nil {
+		return nil, err
+	}
+
+	searchNodes, err := r.ReconcileCluster(cr)
 	if err != nil {
-		return deployment, err
+		return nil, err
 	}
 
-	err = r.verifyDeployment(cr, deployment)
+	deployment, err := r.findDeployment(cr)
 	if err != nil {
 		return deployment, err
 	}
 
+	var statefulSets []*appsv1.StatefulSet
+	if searchNodes != nil {
+		statefulSets = append(statefulSets, searchNodes)
+	}
+
 	newStatus := cr.DeepCopy()
 
-	newStatus.Status.Deployment = r.getDeploymentStatus([]*appsv1.Deployment{deployment})
+	newStatus.Status.Deployment = r.getDeploymentStatus([]*appsv1.Deployment{deployment}, statefulSets)
+	newStatus.Status.Image, err = r.getImageStatus(cr, deployment)
+	if err != nil {
+		return deployment, err
+	}
 	utils.UpdateStatus(r.client, newStatus, cr)
 
 	if utils.GetDeploymentCondition(deployment, appsv1.DeploymentReplicaFailure) == corev1.ConditionTrue {
@@ -51,17 +70,21 @@ func (r *ReconcileSonarQube) ReconcileDeployment(cr *sonarsourcev1alpha1.SonarQu
 		}
 	}
 
-	if deployment.Status.Replicas > 0 && len(newStatus.Status.Deployment[sonarsourcev1alpha1.DeploymentReady]) < 1 {
-		return deployment, &utils.Error{
-			Reason:  utils.ErrorReasonResourceWaiting,
-			Message: "waiting for deployment to be ready",
+	// Every node with replicas must be ready
+	var running int
+	if deployment.Status.Replicas > 0 {
+		running++
+	}
+	for _, sts := range statefulSets {
+		if sts.Status.Replicas > 0 {
+			running++
 		}
 	}
 
-	if deployment.Status.Replicas > 0 && len(newStatus.Status.Deployment[sonarsourcev1alpha1.DeploymentAvailable]) < 1 && len(newStatus.Status.Deployment[sonarsourcev1alpha1.DeploymentReady]) < 1 {
+	if running > 0 && len(newStatus.Status.Deployment[sonarsourcev1alpha1.DeploymentReady]) < running {
 		return deployment, &utils.Error{
 			Reason:  utils.ErrorReasonResourceWaiting,
-			Message: "waiting for deployment to be available and not progressing",
+			Message: "waiting for deployment to be ready",
 		}
 	}
 
@@ -76,20 +99,38 @@ func (r *ReconcileSonarQube) findDeployment(cr *sonarsourcev1alpha1.SonarQube) (
 
 	foundDeployment := &appsv1.Deployment{}
 
-	return foundDeployment, utils.CreateResourceIfNotFound(r.client, newDeployment, foundDeployment)
+	message, err := r.verifyDeployment(newDeployment)
+	if err != nil {
+		return foundDeployment, err
+	}
+
+	return foundDeployment, utils.ApplyResource(r.client, r.scheme, newDeployment, foundDeployment, message)
 }
 
 func (r *ReconcileSonarQube) newDeployment(cr *sonarsourcev1alpha1.SonarQube) (*appsv1.Deployment, error) {
+	serviceAccount, secret, pvcs, service, err := r.getDeploymentDeps(cr)
+	if err != nil {
+		return nil, err
+	}
+
 	labels := r.Labels(cr)
 	podLabels := r.PodLabels(cr)
+	nodeType := nodeType(cr)
+
+	volumes := podVolumes(cr, pvcs)
 
-	serviceAccount, secret, pvc, service, err := r.getDeploymentDeps(cr)
+	// Application nodes of a cluster don't keep data, elasticsearch runs on the search nodes
+	if cr.Spec.Cluster != nil && usesStorage(cr) {
+		volumes[VolumeStorage] = &corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		}
+	}
+
+	template, err := r.newPodTemplate(cr, nodeType, podLabels, serviceAccount, secret, volumes)
 	if err != nil {
 		return nil, err
 	}
 
-	sqImage := utils.GetImage(cr.Spec.Edition, cr.Spec.Version)
-
 	var replicas *int32
 	if cr.Spec.Shutdown == nil || *cr.Spec.Shutdown == false {
 		replicas = &[]int32{1}[0]
@@ -109,164 +150,221 @@ func (r *ReconcileSonarQube) newDeployment(cr *sonarsourcev1alpha1.SonarQube) (*
 			},
 			Replicas: replicas,
 			Selector: &metav1.LabelSelector{
-				MatchLabels: podLabels,
+				MatchLabels: r.SelectorLabels(cr),
+			},
+			Template: *template,
+		},
+	}
+
+	if cr.Spec.Cluster != nil {
+		if *replicas > 0 {
+			replicas = applicationReplicas(cr)
+		}
+		// Application nodes of different versions can't join the same cluster, they are recreated like a single node
+		dep.Spec.Replicas = replicas
+		dep.Spec.Template.Spec.Containers[0].Env = append(dep.Spec.Template.Spec.Containers[0].Env, clusterEnv(cr, nodeType)...)
+	} else if nodeType != sonarsourcev1alpha1.AIO {
+		dep.Spec.Template.Spec.Containers[0].Env = append(dep.Spec.Template.Spec.Containers[0].Env, nodeEnv(cr, nodeType, service, dep.Name)...)
+	}
+
+	if err := controllerutil.SetControllerReference(cr, dep, r.scheme); err != nil {
+		return dep, err
+	}
+
+	return dep, nil
+}
+
+// newPodTemplate returns the pod template shared by SonarQube nodes of nodeType mounting volumes.
+// The storage volume is left out when volumes has none so it can be provided by a volume claim template
+func (r *ReconcileSonarQube) newPodTemplate(cr *sonarsourcev1alpha1.SonarQube, nodeType sonarsourcev1alpha1.ServerType, podLabels map[string]string, serviceAccount *corev1.ServiceAccount, secret *corev1.Secret, volumes map[Volume]*corev1.VolumeSource) (*corev1.PodTemplateSpec, error) {
+	databaseEnv, err := r.ReconcileDatabase(cr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifyImage(cr); err != nil {
+		return nil, err
+	}
+
+	sqImage := image(cr, nodeType)
+
+	template := &corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      cr.Name,
+			Namespace: cr.Namespace,
+			Labels:    podLabels,
+			Annotations: map[string]string{
+				sonarsourcev1alpha1.RevisionAnnotation: cr.Status.Revision,
 			},
-			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{
-					Name:      cr.Name,
-					Namespace: cr.Namespace,
-					Labels:    podLabels,
+		},
+		Spec: corev1.PodSpec{
+			Volumes: []corev1.Volume{
+				{
+					Name:         string(VolumeTemp),
+					VolumeSource: *volumes[VolumeTemp],
 				},
-				Spec: corev1.PodSpec{
-					Volumes: []corev1.Volume{
+				{
+					Name: "conf",
+					VolumeSource: corev1.VolumeSource{
+						Secret: &corev1.SecretVolumeSource{
+							SecretName:  secret.Name,
+							Optional:    &[]bool{true}[0],
+							DefaultMode: &[]int32{corev1.SecretVolumeSourceDefaultMode}[0],
+						},
+					},
+				},
+			},
+			Containers: []corev1.Container{
+				{
+					Name:  "sonarqube",
+					Image: sqImage,
+					Env: []corev1.EnvVar{
 						{
-							Name: "temp",
-							VolumeSource: corev1.VolumeSource{
-								EmptyDir: &corev1.EmptyDirVolumeSource{},
-							},
+							Name:  "SONARR_WEB_PORT",
+							Value: fmt.Sprintf("%v", sonarsourcev1alpha1.ApplicationWebPort),
 						},
 						{
-							Name: "conf",
-							VolumeSource: corev1.VolumeSource{
-								Secret: &corev1.SecretVolumeSource{
-									SecretName: secret.Name,
-									Optional:   &[]bool{true}[0],
-								},
-							},
+							Name:  "SONARR_PATH_DATA",
+							Value: VolumePathData,
 						},
 						{
-							Name: "storage",
-							VolumeSource: corev1.VolumeSource{
-								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-									ClaimName: pvc.Name,
-								},
-							},
+							Name:  "SONARR_PATH_LOGS",
+							Value: VolumePathLogs,
+						},
+						{
+							Name:  "SONARR_PATH_TEMP",
+							Value: VolumePathTemp,
 						},
-					},
-					Containers: []corev1.Container{
 						{
-							Name:  "sonarqube",
-							Image: sqImage,
-							Env: []corev1.EnvVar{
-								{
-									Name:  "SONARR_WEB_PORT",
-									Value: fmt.Sprintf("%v", sonarsourcev1alpha1.ApplicationWebPort),
-								},
-								{
-									Name:  "SONARR_PATH_DATA",
-									Value: VolumePathData,
-								},
-								{
-									Name:  "SONARR_PATH_LOGS",
-									Value: VolumePathLogs,
-								},
-								{
-									Name:  "SONARR_PATH_TEMP",
-									Value: VolumePathTemp,
-								},
-								{
-									Name:  "SONARR_PATH_EXTENSIONS",
-									Value: VolumePathExtensions,
-								},
-							},
-							VolumeMounts: []corev1.VolumeMount{
-								{
-									Name:      "storage",
-									MountPath: VolumePathData,
-									SubPath:   "data",
-								},
-								{
-									Name:      "storage",
-									MountPath: VolumePathLogs,
-									SubPath:   "logs",
-								},
-								{
-									Name:      "temp",
-									MountPath: VolumePathTemp,
-								},
-								{
-									Name:      "storage",
-									MountPath: VolumePathExtensions,
-									SubPath:   "extensions",
-								},
-								{
-									Name:      "conf",
-									MountPath: "/opt/sonarqube/conf/",
-								},
-							},
-							LivenessProbe: &corev1.Probe{
-								Handler: corev1.Handler{
-									TCPSocket: &corev1.TCPSocketAction{
-										Port: intstr.IntOrString{
-											Type:   intstr.Int,
-											IntVal: sonarsourcev1alpha1.ApplicationWebPort,
-											StrVal: "",
-										},
-									},
-								},
-								InitialDelaySeconds: 60,
-								TimeoutSeconds:      1,
-								PeriodSeconds:       10,
-								SuccessThreshold:    1,
-								FailureThreshold:    3,
-							},
-							ReadinessProbe: &corev1.Probe{
-								Handler: corev1.Handler{
-									HTTPGet: &corev1.HTTPGetAction{
-										Path: "/api/system/status",
-										Port: intstr.IntOrString{
-											Type:   intstr.Int,
-											IntVal: sonarsourcev1alpha1.ApplicationWebPort,
-											StrVal: "",
-										},
-										Scheme: corev1.URISchemeHTTP,
-									},
-								},
-								InitialDelaySeconds: 0,
-								TimeoutSeconds:      1,
-								PeriodSeconds:       10,
-								SuccessThreshold:    1,
-								FailureThreshold:    3,
-							},
-							ImagePullPolicy: corev1.PullAlways,
+							Name:  "SONARR_PATH_EXTENSIONS",
+							Value: VolumePathExtensions,
 						},
 					},
-					RestartPolicy:                 corev1.RestartPolicyAlways,
-					TerminationGracePeriodSeconds: &[]int64{PodGracePeriod}[0],
-					DNSPolicy:                     corev1.DNSClusterFirst,
-					ServiceAccountName:            serviceAccount.Name,
-					Affinity: &corev1.Affinity{
-						NodeAffinity:    cr.Spec.NodeConfig.NodeAffinity,
-						PodAffinity:     cr.Spec.NodeConfig.PodAffinity,
-						PodAntiAffinity: cr.Spec.NodeConfig.PodAntiAffinity,
+					VolumeMounts: []corev1.VolumeMount{
+						volumeMount(volumes, VolumeData, VolumePathData),
+						volumeMount(volumes, VolumeLogs, VolumePathLogs),
+						volumeMount(volumes, VolumeTemp, VolumePathTemp),
+						volumeMount(volumes, VolumeExtensions, VolumePathExtensions),
+						{
+							Name:      "conf",
+							MountPath: "/opt/sonarqube/conf/",
+						},
 					},
+					ImagePullPolicy: imagePullPolicy(cr),
 				},
 			},
+			ImagePullSecrets:              imagePullSecrets(cr),
+			RestartPolicy:                 corev1.RestartPolicyAlways,
+			TerminationGracePeriodSeconds: &[]int64{PodGracePeriod}[0],
+			DNSPolicy:                     corev1.DNSClusterFirst,
+			ServiceAccountName:            serviceAccount.Name,
+			AutomountServiceAccountToken:  automountToken(cr),
+			Affinity: &corev1.Affinity{
+				NodeAffinity:    cr.Spec.NodeConfig.NodeAffinity,
+				PodAffinity:     cr.Spec.NodeConfig.PodAffinity,
+				PodAntiAffinity: cr.Spec.NodeConfig.PodAntiAffinity,
+			},
 		},
 	}
 
+	for _, volume := range []Volume{VolumeData, VolumeExtensions, VolumeLogs, VolumeStorage} {
+		if source, ok := volumes[volume]; ok {
+			template.Spec.Volumes = append(template.Spec.Volumes, corev1.Volume{
+				Name:         string(volume),
+				VolumeSource: *source,
+			})
+		}
+	}
+
+	container := &template.Spec.Containers[0]
+
+	container.Env = append(container.Env, databaseEnv...)
+
+	if url := exposeURL(cr); url != "" && nodeType != sonarsourcev1alpha1.Search {
+		container.Env = append(container.Env, corev1.EnvVar{
+			Name:  "SONAR_CORE_SERVERBASEURL",
+			Value: url,
+		})
+	}
+
+	// SonarQube exposed under a path serves the web UI and API under it
+	if path := webContext(cr); path != "" && nodeType != sonarsourcev1alpha1.Search {
+		container.Env = append(container.Env, corev1.EnvVar{
+			Name:  "SONAR_WEB_CONTEXT",
+			Value: path,
+		})
+	}
+
 	if cr.Spec.NodeConfig.Resources != nil {
-		dep.Spec.Template.Spec.Containers[0].Resources = *cr.Spec.NodeConfig.Resources
+		container.Resources = *cr.Spec.NodeConfig.Resources
 	}
 
 	if cr.Spec.NodeConfig.NodeSelector != nil {
-		dep.Spec.Template.Spec.NodeSelector = *cr.Spec.NodeConfig.NodeSelector
+		template.Spec.NodeSelector = *cr.Spec.NodeConfig.NodeSelector
 	}
 
 	if cr.Spec.NodeConfig.PriorityClass != nil {
-		dep.Spec.Template.Spec.PriorityClassName = *cr.Spec.NodeConfig.PriorityClass
+		template.Spec.PriorityClassName = *cr.Spec.NodeConfig.PriorityClass
 	}
 
-	var nodeType sonarsourcev1alpha1.ServerType
-	if cr.Spec.Type == nil {
-		nodeType = sonarsourcev1alpha1.AIO
-	} else {
-		nodeType = *cr.Spec.Type
+	// Volumes are copied out of the storage volume before plugins are installed in them
+	if migrated := migratedVolumes(volumes); len(migrated) > 0 {
+		template.Spec.InitContainers = append(template.Spec.InitContainers, *newMigrateContainer(cr, sqImage, migrated))
+	}
+
+	var tlsProxy *corev1.Container
+
+	// Search nodes don't load plugins or check for upgrades
+	if nodeType != sonarsourcev1alpha1.Search {
+		updateCenterURL, updateCenterVolume, err := r.getUpdateCenter(cr)
+		if err != nil {
+			return nil, err
+		}
+
+		plugins, err := newPluginsContainer(cr, sqImage, updateCenterURL, volumeMount(volumes, VolumeExtensions, VolumePathExtensions))
+		if err != nil {
+			return nil, err
+		}
+
+		if cr.Spec.UpdateCenter != nil {
+			container.Env = append(container.Env, corev1.EnvVar{
+				Name:  "SONAR_UPDATECENTER_URL",
+				Value: updateCenterURL,
+			})
+		}
+
+		if updateCenterVolume != nil {
+			template.Spec.Volumes = append(template.Spec.Volumes, *updateCenterVolume)
+			mount := corev1.VolumeMount{
+				Name:      updateCenterVolume.Name,
+				MountPath: VolumePathUpdateCenter,
+				ReadOnly:  true,
+			}
+			container.VolumeMounts = append(container.VolumeMounts, mount)
+			plugins.VolumeMounts = append(plugins.VolumeMounts, mount)
+		}
+
+		template.Spec.InitContainers = append(template.Spec.InitContainers, *plugins)
+
+		tlsVolume, err := r.getTLSVolume(cr)
+		if err != nil {
+			return nil, err
+		}
+
+		// https is served by the TLS proxy, SonarQube only accepts plain http from it on localhost
+		if tlsVolume != nil {
+			template.Spec.Volumes = append(template.Spec.Volumes, *tlsVolume)
+			tlsProxy = newTLSProxyContainer(tlsVolume)
+			container.Env = append(container.Env, corev1.EnvVar{
+				Name:  "SONAR_WEB_HOST",
+				Value: "127.0.0.1",
+			})
+		}
 	}
 
 	switch nodeType {
 	case sonarsourcev1alpha1.AIO:
-		dep.Spec.Template.Spec.Containers[0].Ports = []corev1.ContainerPort{
+		container.Ports = []corev1.ContainerPort{
 			{
 				Name:          "web",
 				ContainerPort: sonarsourcev1alpha1.ApplicationWebPort,
@@ -274,7 +372,7 @@ func (r *ReconcileSonarQube) newDeployment(cr *sonarsourcev1alpha1.SonarQube) (*
 			},
 		}
 	case sonarsourcev1alpha1.Application:
-		dep.Spec.Template.Spec.Containers[0].Ports = []corev1.ContainerPort{
+		container.Ports = []corev1.ContainerPort{
 			{
 				Name:          "web",
 				ContainerPort: sonarsourcev1alpha1.ApplicationWebPort,
@@ -291,16 +389,104 @@ func (r *ReconcileSonarQube) newDeployment(cr *sonarsourcev1alpha1.SonarQube) (*
 				Protocol:      corev1.ProtocolTCP,
 			},
 		}
+	case sonarsourcev1alpha1.Search:
+		container.Ports = []corev1.ContainerPort{
+			{
+				Name:          "search",
+				ContainerPort: sonarsourcev1alpha1.SearchPort,
+				Protocol:      corev1.ProtocolTCP,
+			},
+		}
+	}
+
+	if err := r.setProbes(cr, nodeType, container); err != nil {
+		return nil, err
+	}
+
+	// Appended last, container points into the containers of the template
+	if tlsProxy != nil {
+		template.Spec.Containers = append(template.Spec.Containers, *tlsProxy)
+	}
+
+	return template, nil
+}
+
+// image returns the SonarQube image for nodeType, nodes of a cluster managed from a single resource
+// use the Data Center Edition application and search images. The repository, tag and digest in spec
+// replace the ones derived from version and edition, a digest takes precedence over the tag
+func image(cr *sonarsourcev1alpha1.SonarQube, nodeType sonarsourcev1alpha1.ServerType) string {
+	repository := utils.GetImageRepository()
+	if cr.Spec.Image != nil && cr.Spec.Image.Repository != nil {
+		repository = *cr.Spec.Image.Repository
+	}
+
+	if cr.Spec.Image != nil && cr.Spec.Image.Digest != nil {
+		return fmt.Sprintf("%s@%s", repository, *cr.Spec.Image.Digest)
+	}
+
+	var tag string
+	switch {
+	case cr.Spec.Image != nil && cr.Spec.Image.Tag != nil:
+		tag = *cr.Spec.Image.Tag
+	case cr.Spec.Cluster == nil:
+		tag = utils.GetImageTag(cr.Spec.Edition, cr.Spec.Version)
+	case nodeType == sonarsourcev1alpha1.Search:
+		tag = utils.GetImageTag(&[]string{"datacenter-search"}[0], cr.Spec.Version)
+	default:
+		tag = utils.GetImageTag(&[]string{"datacenter-app"}[0], cr.Spec.Version)
+	}
+
+	return fmt.Sprintf("%s:%s", repository, tag)
+}
+
+// verifyImage checks the image in spec can be resolved for every node
+// Errors:
+//   ErrorReasonSpecInvalid: returned when a tag or digest is set for a cluster, its nodes run different images
+func verifyImage(cr *sonarsourcev1alpha1.SonarQube) error {
+	if cr.Spec.Cluster == nil || cr.Spec.Image == nil {
+		return nil
+	}
+	if cr.Spec.Image.Tag != nil || cr.Spec.Image.Digest != nil {
+		return &utils.Error{
+			Reason:  utils.ErrorReasonSpecInvalid,
+			Message: "image tag and digest can't be set for a cluster, application and search nodes run different images",
+		}
+	}
+	return nil
+}
+
+// imagePullPolicy returns the pull policy of SonarQube images in spec, Always when unset
+func imagePullPolicy(cr *sonarsourcev1alpha1.SonarQube) corev1.PullPolicy {
+	if cr.Spec.Image == nil || cr.Spec.Image.PullPolicy == nil {
+		return corev1.PullAlways
+	}
+	return *cr.Spec.Image.PullPolicy
+}
+
+// imagePullSecrets returns the secrets SonarQube images are pulled with
+func imagePullSecrets(cr *sonarsourcev1alpha1.SonarQube) []corev1.LocalObjectReference {
+	if cr.Spec.Image == nil {
+		return nil
+	}
+	return cr.Spec.Image.PullSecrets
+}
+
+// nodeEnv returns the cluster env for application and search nodes managed by separate resources
+// that list the cluster hosts in spec
+func nodeEnv(cr *sonarsourcev1alpha1.SonarQube, nodeType sonarsourcev1alpha1.ServerType, service *corev1.Service, name string) []corev1.EnvVar {
+	searchHosts := cr.Spec.SearchHosts
+	if !utils.ContainsString(searchHosts, service.Spec.ClusterIP) {
+		searchHosts = append(searchHosts, service.Spec.ClusterIP)
+	}
+
+	switch nodeType {
+	case sonarsourcev1alpha1.Application:
 		hosts := cr.Spec.Hosts
 		if !utils.ContainsString(hosts, service.Spec.ClusterIP) {
 			hosts = append(hosts, service.Spec.ClusterIP)
 		}
-		searchHosts := cr.Spec.SearchHosts
-		if !utils.ContainsString(searchHosts, service.Spec.ClusterIP) {
-			searchHosts = append(searchHosts, service.Spec.ClusterIP)
-		}
 
-		clusteredEnv := []corev1.EnvVar{
+		return []corev1.EnvVar{
 			{
 				Name:  "SONAR_CLUSTER_ENABLED",
 				Value: "true",
@@ -328,24 +514,11 @@ func (r *ReconcileSonarQube) newDeployment(cr *sonarsourcev1alpha1.SonarQube) (*
 			},
 			{
 				Name:  "SONAR_CLUSTER_NODE_NAME",
-				Value: dep.Name,
+				Value: name,
 			},
 		}
-		dep.Spec.Template.Spec.Containers[0].Env = append(dep.Spec.Template.Spec.Containers[0].Env, clusteredEnv...)
 	case sonarsourcev1alpha1.Search:
-		dep.Spec.Template.Spec.Containers[0].Ports = []corev1.ContainerPort{
-			{
-				Name:          "search",
-				ContainerPort: sonarsourcev1alpha1.SearchPort,
-				Protocol:      corev1.ProtocolTCP,
-			},
-		}
-		searchHosts := cr.Spec.SearchHosts
-		if !utils.ContainsString(cr.Spec.SearchHosts, service.Spec.ClusterIP) {
-			searchHosts = append(searchHosts, service.Spec.ClusterIP)
-		}
-
-		clusteredEnv := []corev1.EnvVar{
+		return []corev1.EnvVar{
 			{
 				Name:  "SONAR_CLUSTER_ENABLED",
 				Value: "true",
@@ -365,7 +538,7 @@ func (r *ReconcileSonarQube) newDeployment(cr *sonarsourcev1alpha1.SonarQube) (*
 			},
 			{
 				Name:  "SONAR_CLUSTER_NODE_NAME",
-				Value: dep.Name,
+				Value: name,
 			},
 			{
 				Name:  "SONAR_CLUSTER_SEARCH_HOSTS",
@@ -381,32 +554,12 @@ func (r *ReconcileSonarQube) newDeployment(cr *sonarsourcev1alpha1.SonarQube) (*
 				},
 			},
 		}
-		dep.Spec.Template.Spec.Containers[0].Env = append(dep.Spec.Template.Spec.Containers[0].Env, clusteredEnv...)
-		dep.Spec.Template.Spec.Containers[0].LivenessProbe.Handler.TCPSocket = &corev1.TCPSocketAction{
-			Port: intstr.IntOrString{
-				Type:   intstr.Int,
-				IntVal: sonarsourcev1alpha1.SearchPort,
-				StrVal: "",
-			},
-		}
-		dep.Spec.Template.Spec.Containers[0].ReadinessProbe.Handler.HTTPGet = nil
-		dep.Spec.Template.Spec.Containers[0].ReadinessProbe.Handler.TCPSocket = &corev1.TCPSocketAction{
-			Port: intstr.IntOrString{
-				Type:   intstr.Int,
-				IntVal: sonarsourcev1alpha1.SearchPort,
-				StrVal: "",
-			},
-		}
-	}
-
-	if err := controllerutil.SetControllerReference(cr, dep, r.scheme); err != nil {
-		return dep, err
 	}
 
-	return dep, nil
+	return nil
 }
 
-func (r *ReconcileSonarQube) getDeploymentDeps(cr *sonarsourcev1alpha1.SonarQube) (*corev1.ServiceAccount, *corev1.Secret, *corev1.PersistentVolumeClaim, *corev1.Service, error) {
+func (r *ReconcileSonarQube) getDeploymentDeps(cr *sonarsourcev1alpha1.SonarQube) (*corev1.ServiceAccount, *corev1.Secret, map[Volume]*corev1.PersistentVolumeClaim, *corev1.Service, error) {
 
 	serviceAccount, err := r.ReconcileServiceAccount(cr)
 	if err != nil {
@@ -418,51 +571,138 @@ func (r *ReconcileSonarQube) getDeploymentDeps(cr *sonarsourcev1alpha1.SonarQube
 		return serviceAccount, secret, nil, nil, err
 	}
 
-	pvc, err := r.ReconcilePVC(cr)
+	// Application nodes of a cluster don't use a persistent volume
+	var pvcs map[Volume]*corev1.PersistentVolumeClaim
+	if cr.Spec.Cluster == nil {
+		pvcs, err = r.ReconcilePVC(cr)
+	} else {
+		err = verifyClusterVolumes(cr)
+	}
 	if err != nil {
-		return serviceAccount, secret, pvc, nil, err
+		return serviceAccount, secret, pvcs, nil, err
 	}
 
 	service, err := r.ReconcileService(cr)
 	if err != nil {
-		return serviceAccount, secret, pvc, service, err
+		return serviceAccount, secret, pvcs, service, err
 	}
 
-	return serviceAccount, secret, pvc, service, nil
+	return serviceAccount, secret, pvcs, service, nil
 }
 
-func (r *ReconcileSonarQube) verifyDeployment(cr *sonarsourcev1alpha1.SonarQube, deployment *appsv1.Deployment) error {
-	newDeployment, err := r.newDeployment(cr)
-	if err != nil {
+// verifyNodeType checks the node type in spec matches the selector of the existing deployment.
+// Selectors are immutable so the pods of another node type, ex. when a cluster is added to a single node,
+// would not be selected by the deployment and the services
+// Errors:
+//   ErrorReasonSpecInvalid: returned when the node type differs from the component in the selector
+//   ErrorReasonUnknown: returned when unhandled error from client occurs
+func (r *ReconcileSonarQube) verifyNodeType(cr *sonarsourcev1alpha1.SonarQube) error {
+	deployment := &appsv1.Deployment{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: cr.Name, Namespace: cr.Namespace}, deployment)
+	if err != nil && errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
 		return err
 	}
 
-	if !reflect.DeepEqual(*deployment.Spec.Replicas, *newDeployment.Spec.Replicas) {
-		deployment.Spec.Replicas = newDeployment.Spec.Replicas
-		return utils.UpdateResource(r.client, deployment, utils.ErrorReasonResourceUpdate, "updated deployment replicas")
+	if deployment.Spec.Selector == nil {
+		return nil
+	}
+	if component, ok := deployment.Spec.Selector.MatchLabels[sonarsourcev1alpha1.KubeAppComponent]; ok && component != string(nodeType(cr)) {
+		return &utils.Error{
+			Reason:  utils.ErrorReasonSpecInvalid,
+			Message: fmt.Sprintf("node type can't change from %s to %s, delete deployment %s to change it", component, nodeType(cr), deployment.Name),
+		}
 	}
 
-	if !r.envEqual(newDeployment.Spec.Template.Spec.Containers[0].Env, deployment.Spec.Template.Spec.Containers[0].Env) {
-		deployment.Spec.Template.Spec.Containers[0].Env = newDeployment.Spec.Template.Spec.Containers[0].Env
-		return utils.UpdateResource(r.client, deployment, utils.ErrorReasonResourceUpdate, "updated deployment env")
+	return nil
+}
+
+// verifyDeployment compares the desired deployment with the deployment in the cluster
+// Returns: message summarizing the changed fields, Error
+// Message is empty when the deployment does not exist or nothing changed
+func (r *ReconcileSonarQube) verifyDeployment(newDeployment *appsv1.Deployment) (string, error) {
+	deployment := &appsv1.Deployment{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: newDeployment.Name, Namespace: newDeployment.Namespace}, deployment)
+	if err != nil && errors.IsNotFound(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
 	}
 
-	if !reflect.DeepEqual(deployment.Spec.Template.Spec.Containers[0].ReadinessProbe, newDeployment.Spec.Template.Spec.Containers[0].ReadinessProbe) {
-		deployment.Spec.Template.Spec.Containers[0].ReadinessProbe = newDeployment.Spec.Template.Spec.Containers[0].ReadinessProbe
-		return utils.UpdateResource(r.client, deployment, utils.ErrorReasonResourceUpdate, "updated deployment readiness probe")
+	// Selectors are immutable, deployments created with an older label set keep their selector
+	if !equality.Semantic.DeepEqual(deployment.Spec.Selector, newDeployment.Spec.Selector) {
+		newDeployment.Spec.Selector = deployment.Spec.Selector
+		for k, v := range deployment.Spec.Selector.MatchLabels {
+			newDeployment.Spec.Template.Labels[k] = v
+		}
 	}
 
-	if !reflect.DeepEqual(deployment.Spec.Template.Spec.Containers[0].LivenessProbe, newDeployment.Spec.Template.Spec.Containers[0].LivenessProbe) {
-		deployment.Spec.Template.Spec.Containers[0].LivenessProbe = newDeployment.Spec.Template.Spec.Containers[0].LivenessProbe
-		return utils.UpdateResource(r.client, deployment, utils.ErrorReasonResourceUpdate, "updated deployment liveness probe")
+	var changed []string
+	// Semantic equality treats nil and empty slices or maps as equal so unset fields aren't reported
+	diff := func(field string, actual, desired interface{}) {
+		if !equality.Semantic.DeepEqual(actual, desired) {
+			changed = append(changed, field)
+		}
 	}
 
-	if !reflect.DeepEqual(deployment.Labels, newDeployment.Labels) {
-		deployment.Labels = newDeployment.Labels
-		return utils.UpdateResource(r.client, deployment, utils.ErrorReasonResourceUpdate, "updated deployment labels")
+	diff("replicas", deployment.Spec.Replicas, newDeployment.Spec.Replicas)
+	diff("labels", deployment.Labels, newDeployment.Labels)
+	diff("revision", revision(deployment), revision(newDeployment))
+
+	podSpec, newPodSpec := &deployment.Spec.Template.Spec, &newDeployment.Spec.Template.Spec
+	diff("volumes", podSpec.Volumes, newPodSpec.Volumes)
+	diff("node selector", podSpec.NodeSelector, newPodSpec.NodeSelector)
+	diff("affinity", podSpec.Affinity, newPodSpec.Affinity)
+	diff("priority class", podSpec.PriorityClassName, newPodSpec.PriorityClassName)
+	diff("service account", podSpec.ServiceAccountName, newPodSpec.ServiceAccountName)
+	diff("image pull secrets", podSpec.ImagePullSecrets, newPodSpec.ImagePullSecrets)
+	diff("automount service account token", podSpec.AutomountServiceAccountToken, newPodSpec.AutomountServiceAccountToken)
+	diff("termination grace period", podSpec.TerminationGracePeriodSeconds, newPodSpec.TerminationGracePeriodSeconds)
+
+	container, newContainer := &podSpec.Containers[0], &newPodSpec.Containers[0]
+	diff("image", container.Image, newContainer.Image)
+	diff("image pull policy", container.ImagePullPolicy, newContainer.ImagePullPolicy)
+	diff("ports", container.Ports, newContainer.Ports)
+	diff("resources", container.Resources, newContainer.Resources)
+	diff("volume mounts", container.VolumeMounts, newContainer.VolumeMounts)
+	diff("readiness probe", container.ReadinessProbe, newContainer.ReadinessProbe)
+	diff("liveness probe", container.LivenessProbe, newContainer.LivenessProbe)
+	diff("startup probe", container.StartupProbe, newContainer.StartupProbe)
+
+	if !r.envEqual(newContainer.Env, container.Env) {
+		changed = append(changed, "env")
 	}
 
-	return nil
+	if !r.initContainersEqual(podSpec.InitContainers, newPodSpec.InitContainers) {
+		changed = append(changed, "init containers")
+	}
+
+	if len(changed) == 0 {
+		return "", nil
+	}
+
+	return fmt.Sprintf("updated deployment %s", strings.Join(changed, ", ")), nil
+}
+
+func revision(deployment *appsv1.Deployment) string {
+	return deployment.Spec.Template.Annotations[sonarsourcev1alpha1.RevisionAnnotation]
+}
+
+// initContainersEqual compares the fields of init containers set by the operator
+func (r *ReconcileSonarQube) initContainersEqual(c, p []corev1.Container) bool {
+	if len(c) != len(p) {
+		return false
+	}
+	for i := range c {
+		if c[i].Name != p[i].Name || c[i].Image != p[i].Image ||
+			!equality.Semantic.DeepEqual(c[i].Command, p[i].Command) ||
+			!equality.Semantic.DeepEqual(c[i].VolumeMounts, p[i].VolumeMounts) ||
+			!r.envEqual(c[i].Env, p[i].Env) {
+			return false
+		}
+	}
+	return true
 }
 
 func (r *ReconcileSonarQube) envEqual(c, p []corev1.EnvVar) bool {
@@ -510,7 +750,32 @@ func (r *ReconcileSonarQube) envEqual(c, p []corev1.EnvVar) bool {
 	return equal
 }
 
-func (r *ReconcileSonarQube) getDeploymentStatus(deployments []*appsv1.Deployment) sonarsourcev1alpha1.DeploymentStatuses {
+// getImageStatus returns the image of deployment and the image IDs reported by the SonarQube containers of its pods,
+// the version observed from the API belongs to one of the running images
+func (r *ReconcileSonarQube) getImageStatus(cr *sonarsourcev1alpha1.SonarQube, deployment *appsv1.Deployment) (sonarsourcev1alpha1.ImageStatus, error) {
+	status := sonarsourcev1alpha1.ImageStatus{}
+	if containers := deployment.Spec.Template.Spec.Containers; len(containers) > 0 {
+		status.Reference = containers[0].Image
+	}
+
+	pods := &corev1.PodList{}
+	if err := r.client.List(context.TODO(), pods, client.InNamespace(cr.Namespace), client.MatchingLabels(r.SelectorLabels(cr))); err != nil {
+		return status, err
+	}
+
+	for _, pod := range pods.Items {
+		for _, container := range pod.Status.ContainerStatuses {
+			if container.Name == "sonarqube" && container.ImageID != "" && !utils.ContainsString(status.Running, container.ImageID) {
+				status.Running = append(status.Running, container.ImageID)
+			}
+		}
+	}
+	sort.Strings(status.Running)
+
+	return status, nil
+}
+
+func (r *ReconcileSonarQube) getDeploymentStatus(deployments []*appsv1.Deployment, statefulSets []*appsv1.StatefulSet) sonarsourcev1alpha1.DeploymentStatuses {
 	status := sonarsourcev1alpha1.DeploymentStatuses{
 		sonarsourcev1alpha1.DeploymentAvailable:   []string{},
 		sonarsourcev1alpha1.DeploymentUpdating:    []string{},
@@ -520,24 +785,40 @@ func (r *ReconcileSonarQube) getDeploymentStatus(deployments []*appsv1.Deploymen
 
 	for _, dep := range deployments {
 		if *dep.Spec.Replicas == 0 {
-			break
+			continue
 		}
 		if dep.Status.Replicas > dep.Status.UpdatedReplicas {
 			status[sonarsourcev1alpha1.DeploymentUpdating] = append(status[sonarsourcev1alpha1.DeploymentUpdating], dep.Name)
-			break
+			continue
 		}
 		if dep.Status.Replicas == dep.Status.ReadyReplicas {
 			status[sonarsourcev1alpha1.DeploymentReady] = append(status[sonarsourcev1alpha1.DeploymentReady], dep.Name)
-			break
+			continue
 		}
 		if dep.Status.Replicas == dep.Status.AvailableReplicas {
 			status[sonarsourcev1alpha1.DeploymentAvailable] = append(status[sonarsourcev1alpha1.DeploymentAvailable], dep.Name)
-			break
+			continue
 		}
 		if dep.Status.Replicas == dep.Status.UnavailableReplicas {
 			status[sonarsourcev1alpha1.DeploymentUnavailable] = append(status[sonarsourcev1alpha1.DeploymentUnavailable], dep.Name)
-			break
+			continue
+		}
+	}
+
+	// StatefulSets don't report available replicas, nodes that aren't ready are unavailable
+	for _, sts := range statefulSets {
+		if *sts.Spec.Replicas == 0 {
+			continue
+		}
+		if sts.Status.Replicas > sts.Status.UpdatedReplicas {
+			status[sonarsourcev1alpha1.DeploymentUpdating] = append(status[sonarsourcev1alpha1.DeploymentUpdating], sts.Name)
+			continue
+		}
+		if sts.Status.Replicas == sts.Status.ReadyReplicas {
+			status[sonarsourcev1alpha1.DeploymentReady] = append(status[sonarsourcev1alpha1.DeploymentReady], sts.Name)
+			continue
 		}
+		status[sonarsourcev1alpha1.DeploymentUnavailable] = append(status[sonarsourcev1alpha1.DeploymentUnavailable], sts.Name)
 	}
 
 	return status
@@ -8,12 +8,14 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
-	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"os"
 	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+	"strings"
 	"testing"
 )
 
@@ -76,14 +78,15 @@ func TestSonarQubeDeployment(t *testing.T) {
 		s := scheme.Scheme
 		s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
 		// Create a fake client to mock API calls.
-		cl := fake.NewFakeClientWithScheme(s, objs...)
+		cl := newFakeClient(s, objs...)
 		// Create a ReconcileSonarQube object with the scheme and fake client.
 		apiMock := &api_client.APIClientMock{}
 		r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: apiMock}
 
 		// Take care of dependencies, if there is an unkown error here there is not much to do
+		// The secret sets the revision used in the labels of the other dependencies
 		for {
-			_, err := r.ReconcileServiceAccount(sonarqube)
+			_, err := r.ReconcileSecret(sonarqube)
 			if err != nil && utils.ReasonForError(err) == utils.ErrorReasonUnknown {
 				t.Fatalf("reconcileServiceAccount: (%v)", err)
 			} else if err == nil {
@@ -92,7 +95,7 @@ func TestSonarQubeDeployment(t *testing.T) {
 		}
 
 		for {
-			_, err := r.ReconcileSecret(sonarqube)
+			_, err := r.ReconcileServiceAccount(sonarqube)
 			if err != nil && utils.ReasonForError(err) == utils.ErrorReasonUnknown {
 				t.Fatalf("reconcileServiceAccount: (%v)", err)
 			} else if err == nil {
@@ -100,6 +103,15 @@ func TestSonarQubeDeployment(t *testing.T) {
 			}
 		}
 
+		for {
+			_, err := r.ReconcilePasscode(sonarqube)
+			if err != nil && utils.ReasonForError(err) == utils.ErrorReasonUnknown {
+				t.Fatalf("reconcilePasscode: (%v)", err)
+			} else if err == nil {
+				break
+			}
+		}
+
 		for {
 			_, err := r.ReconcilePVC(sonarqube)
 			if err != nil && utils.ReasonForError(err) == utils.ErrorReasonUnknown {
@@ -120,7 +132,7 @@ func TestSonarQubeDeployment(t *testing.T) {
 
 		_, err := r.ReconcileDeployment(sonarqube)
 		if utils.ReasonForError(err) != utils.ErrorReasonResourceCreate {
-			t.Error("reconcileDeployment: resource created error not thrown when creating Deployment")
+			t.Errorf("reconcileDeployment: resource created error not thrown when creating Deployment (%v)", err)
 		}
 		deployment := &appsv1.Deployment{}
 		err = r.client.Get(context.TODO(), types.NamespacedName{Name: sonarqube.Name, Namespace: sonarqube.Namespace}, deployment)
@@ -141,7 +153,356 @@ func TestSonarQubeDeployment(t *testing.T) {
 
 		deployment, err = r.ReconcileDeployment(sonarqube)
 		if err != nil {
-			t.Error("reconcileDeployment: returned error even though Deployment is in expected state")
+			t.Errorf("reconcileDeployment: returned error even though Deployment is in expected state (%v)", err)
+		}
+	}
+}
+
+// TestSonarQubeDeploymentRevision runs ReconcileSonarQube.ReconcileDeployment() against a
+// fake client after the configuration secret was edited
+func TestSonarQubeDeploymentRevision(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name      = "sonarqube-operator"
+		namespace = "sonarqube"
+	)
+
+	// A SonarQube resource with metadata and spec.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{},
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
+	// Create a fake client to mock API calls.
+	cl := newFakeClient(s, objs...)
+	// Create a ReconcileSonarQube object with the scheme and fake client.
+	apiMock := &api_client.APIClientMock{}
+	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: apiMock}
+
+	// Take care of dependencies and deployment, if there is an unkown error here there is not much to do
+	for {
+		_, err := r.ReconcileDeployment(sonarqube)
+		if err != nil && utils.ReasonForError(err) == utils.ErrorReasonUnknown {
+			t.Fatalf("reconcileDeployment: (%v)", err)
+		} else if err == nil {
+			break
+		}
+	}
+
+	deployment := &appsv1.Deployment{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: sonarqube.Name, Namespace: sonarqube.Namespace}, deployment)
+	if err != nil {
+		t.Fatalf("reconcileDeployment: (%v)", err)
+	}
+	oldRevision := revision(deployment)
+	if oldRevision == "" || oldRevision != sonarqube.Status.Revision {
+		t.Error("reconcileDeployment: revision not set on pod template")
+	}
+
+	secret := &corev1.Secret{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: *sonarqube.Spec.Secret, Namespace: sonarqube.Namespace}, secret)
+	if err != nil {
+		t.Fatalf("reconcileDeployment: (%v)", err)
+	}
+	secret.Data = map[string][]byte{
+		"sonar.properties": []byte("sonar.log.level=DEBUG\n"),
+	}
+	err = r.client.Update(context.TODO(), secret)
+	if err != nil {
+		t.Fatalf("reconcileDeployment: (%v)", err)
+	}
+
+	_, err = r.ReconcileDeployment(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceUpdate {
+		t.Error("reconcileDeployment: resource update error not thrown when secret changed")
+	}
+	for err != nil {
+		if utils.ReasonForError(err) == utils.ErrorReasonUnknown {
+			t.Fatalf("reconcileDeployment: (%v)", err)
+		}
+		_, err = r.ReconcileDeployment(sonarqube)
+	}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: sonarqube.Name, Namespace: sonarqube.Namespace}, deployment)
+	if err != nil {
+		t.Fatalf("reconcileDeployment: (%v)", err)
+	}
+	if revision(deployment) == oldRevision || revision(deployment) != sonarqube.Status.Revision {
+		t.Error("reconcileDeployment: pod template revision not updated after secret changed")
+	}
+
+	// Fields that don't feed the pods don't roll them
+	oldRevision = sonarqube.Status.Revision
+	sonarqube.Spec.UpdatesMinor = &[]bool{true}[0]
+	sonarqube.Spec.AuthSecret = &[]string{"credentials"}[0]
+	sonarqube.Spec.Backup = &sonarsourcev1alpha1.Backup{Schedule: "0 2 * * *"}
+	sonarqube.Spec.Monitoring = &sonarsourcev1alpha1.Monitoring{ServiceMonitor: &[]bool{true}[0]}
+	err = r.client.Update(context.TODO(), sonarqube)
+	if err != nil {
+		t.Fatalf("reconcileDeployment: (%v)", err)
+	}
+	if _, err := r.ReconcileSecret(sonarqube); err != nil {
+		t.Fatalf("reconcileSecret: (%v)", err)
+	}
+	if sonarqube.Status.Revision != oldRevision {
+		t.Error("reconcileSecret: revision changed by fields that don't feed the pods")
+	}
+}
+
+// TestSonarQubeDeploymentDrift runs ReconcileSonarQube.ReconcileDeployment() against a
+// fake client after changing several fields of the spec
+func TestSonarQubeDeploymentDrift(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name      = "sonarqube-operator"
+		namespace = "sonarqube"
+	)
+
+	// A SonarQube resource with metadata and spec.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{},
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
+	// Create a fake client to mock API calls.
+	cl := newFakeClient(s, objs...)
+	// Create a ReconcileSonarQube object with the scheme and fake client.
+	apiMock := &api_client.APIClientMock{}
+	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: apiMock}
+
+	// Take care of dependencies and deployment, if there is an unkown error here there is not much to do
+	for {
+		_, err := r.ReconcileDeployment(sonarqube)
+		if err != nil && utils.ReasonForError(err) == utils.ErrorReasonUnknown {
+			t.Fatalf("reconcileDeployment: (%v)", err)
+		} else if err == nil {
+			break
 		}
 	}
+
+	sonarqube.Spec.Version = &[]string{"8.3"}[0]
+	sonarqube.Spec.NodeConfig.PriorityClass = &[]string{"high"}[0]
+	sonarqube.Spec.NodeConfig.NodeSelector = &map[string]string{"disk": "ssd"}
+	sonarqube.Spec.NodeConfig.Resources = &corev1.ResourceRequirements{
+		Limits: corev1.ResourceList{
+			corev1.ResourceMemory: resource.MustParse("4Gi"),
+		},
+	}
+	err := r.client.Update(context.TODO(), sonarqube)
+	if err != nil {
+		t.Fatalf("reconcileDeployment: (%v)", err)
+	}
+
+	// The service is updated with the new revision before the deployment
+	var message string
+	for i := 0; i < 5; i++ {
+		_, err = r.ReconcileDeployment(sonarqube)
+		if err == nil || utils.ReasonForError(err) == utils.ErrorReasonUnknown {
+			t.Fatalf("reconcileDeployment: deployment not updated after spec changed (%v)", err)
+		}
+		if strings.HasPrefix(err.(*utils.Error).Message, "updated deployment") {
+			message = err.(*utils.Error).Message
+			break
+		}
+	}
+	for _, field := range []string{"revision", "image", "resources", "node selector", "priority class"} {
+		if !strings.Contains(message, field) {
+			t.Errorf("reconcileDeployment: %s not in update message %q", field, message)
+		}
+	}
+
+	deployment := &appsv1.Deployment{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: sonarqube.Name, Namespace: sonarqube.Namespace}, deployment)
+	if err != nil {
+		t.Fatalf("reconcileDeployment: (%v)", err)
+	}
+	podSpec := deployment.Spec.Template.Spec
+	if podSpec.PriorityClassName != "high" || podSpec.NodeSelector["disk"] != "ssd" || podSpec.Containers[0].Resources.Limits.Memory().String() != "4Gi" {
+		t.Error("reconcileDeployment: spec changes not applied to deployment")
+	}
+
+	_, err = r.ReconcileDeployment(sonarqube)
+	if err != nil {
+		t.Errorf("reconcileDeployment: returned error even though Deployment is in expected state (%v)", err)
+	}
+}
+
+// TestSonarQubeDeploymentImage runs ReconcileSonarQube.ReconcileDeployment() against a
+// fake client with the image overridden in spec
+func TestSonarQubeDeploymentImage(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name      = "sonarqube-operator"
+		namespace = "sonarqube"
+		digest    = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+		imageID   = "docker-pullable://registry.example.com/mirror/sonarqube@" + digest
+	)
+
+	// The registry of the operator replaces Docker Hub for images derived from version and edition
+	os.Setenv(utils.ImageRegistryEnvVar, "registry.example.com/")
+	defer os.Unsetenv(utils.ImageRegistryEnvVar)
+
+	// A SonarQube resource with metadata and spec.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{
+			Version: &[]string{"8.3"}[0],
+		},
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
+	// Create a fake client to mock API calls.
+	cl := newFakeClient(s, objs...)
+	// Create a ReconcileSonarQube object with the scheme and fake client.
+	apiMock := &api_client.APIClientMock{}
+	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: apiMock}
+
+	// Take care of dependencies and deployment, if there is an unkown error here there is not much to do
+	var deployment *appsv1.Deployment
+	for {
+		var err error
+		deployment, err = r.ReconcileDeployment(sonarqube)
+		if err != nil && utils.ReasonForError(err) == utils.ErrorReasonUnknown {
+			t.Fatalf("reconcileDeployment: (%v)", err)
+		} else if err == nil {
+			break
+		}
+	}
+	if image := deployment.Spec.Template.Spec.Containers[0].Image; image != "registry.example.com/sonarqube:8.3-community" {
+		t.Errorf("reconcileDeployment: image %s not pulled from the registry of the operator", image)
+	}
+
+	sonarqube.Spec.Image = &sonarsourcev1alpha1.Image{
+		Repository:  &[]string{"registry.example.com/mirror/sonarqube"}[0],
+		Tag:         &[]string{"8.3-custom"}[0],
+		Digest:      &[]string{digest}[0],
+		PullPolicy:  &[]corev1.PullPolicy{corev1.PullIfNotPresent}[0],
+		PullSecrets: []corev1.LocalObjectReference{{Name: "mirror"}},
+	}
+	err := r.client.Update(context.TODO(), sonarqube)
+	if err != nil {
+		t.Fatalf("reconcileDeployment: (%v)", err)
+	}
+
+	// The dependencies are updated with the new revision before the deployment
+	var message string
+	for i := 0; i < 5; i++ {
+		_, err = r.ReconcileDeployment(sonarqube)
+		if err == nil || utils.ReasonForError(err) == utils.ErrorReasonUnknown {
+			t.Fatalf("reconcileDeployment: deployment not updated after image changed (%v)", err)
+		}
+		if strings.HasPrefix(err.(*utils.Error).Message, "updated deployment") {
+			message = err.(*utils.Error).Message
+			break
+		}
+	}
+	for _, field := range []string{"image", "image pull policy", "image pull secrets"} {
+		if !strings.Contains(message, field) {
+			t.Errorf("reconcileDeployment: %s not in update message %q", field, message)
+		}
+	}
+
+	deployment = &appsv1.Deployment{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: sonarqube.Name, Namespace: sonarqube.Namespace}, deployment)
+	if err != nil {
+		t.Fatalf("reconcileDeployment: (%v)", err)
+	}
+	podSpec := deployment.Spec.Template.Spec
+	if podSpec.Containers[0].Image != "registry.example.com/mirror/sonarqube@"+digest {
+		t.Errorf("reconcileDeployment: image %s not pinned to digest", podSpec.Containers[0].Image)
+	}
+	if podSpec.Containers[0].ImagePullPolicy != corev1.PullIfNotPresent {
+		t.Error("reconcileDeployment: image pull policy not applied to deployment")
+	}
+	if len(podSpec.ImagePullSecrets) != 1 || podSpec.ImagePullSecrets[0].Name != "mirror" {
+		t.Error("reconcileDeployment: image pull secrets not applied to deployment")
+	}
+	for _, container := range podSpec.InitContainers {
+		if container.Image != podSpec.Containers[0].Image || container.ImagePullPolicy != corev1.PullIfNotPresent {
+			t.Errorf("reconcileDeployment: init container %s doesn't use the image in spec", container.Name)
+		}
+	}
+
+	// The image IDs of running pods are reported next to the resolved reference
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name + "-pod",
+			Namespace: namespace,
+			Labels:    r.PodLabels(sonarqube),
+		},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{
+				{
+					Name:    "sonarqube",
+					ImageID: imageID,
+				},
+			},
+		},
+	}
+	err = r.client.Create(context.TODO(), pod)
+	if err != nil {
+		t.Fatalf("reconcileDeployment: (%v)", err)
+	}
+
+	_, err = r.ReconcileDeployment(sonarqube)
+	if err != nil {
+		t.Errorf("reconcileDeployment: returned error even though Deployment is in expected state (%v)", err)
+	}
+	found := &sonarsourcev1alpha1.SonarQube{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: sonarqube.Name, Namespace: sonarqube.Namespace}, found)
+	if err != nil {
+		t.Fatalf("reconcileDeployment: (%v)", err)
+	}
+	if found.Status.Image.Reference != podSpec.Containers[0].Image {
+		t.Errorf("reconcileDeployment: image reference %q not in status", found.Status.Image.Reference)
+	}
+	if len(found.Status.Image.Running) != 1 || found.Status.Image.Running[0] != imageID {
+		t.Errorf("reconcileDeployment: running images %v not in status", found.Status.Image.Running)
+	}
+
+	// Nodes of a cluster run different images, a single tag or digest can't be used
+	sonarqube.Spec.Cluster = &sonarsourcev1alpha1.Cluster{}
+	for i := 0; i < 10; i++ {
+		_, err = r.ReconcileDeployment(sonarqube)
+		if err == nil || utils.ReasonForError(err) == utils.ErrorReasonSpecInvalid {
+			break
+		}
+	}
+	if utils.ReasonForError(err) != utils.ErrorReasonSpecInvalid {
+		t.Errorf("reconcileDeployment: spec invalid error not thrown for an image digest in a cluster (%v)", err)
+	}
 }
@@ -0,0 +1,126 @@
+package sonarqube
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	EventReasonCreated              string = "Created"
+	EventReasonUpdated              string = "Updated"
+	EventReasonSpecUpdated          string = "SpecUpdated"
+	EventReasonWaiting              string = "Waiting"
+	EventReasonInvalid              string = "Invalid"
+	EventReasonAuthenticationFailed string = "AuthenticationFailed"
+	EventReasonServerDown           string = "ServerDown"
+	EventReasonShutdown             string = "Shutdown"
+	EventReasonServerStatus         string = "ServerStatusChanged"
+	EventReasonUpgradesAvailable    string = "UpgradesAvailable"
+	EventReasonReconcileError       string = "ReconcileError"
+
+	// EventDeduplicationWindow is how long an identical event of a SonarQube is not recorded again,
+	// reconciliations are requeued every few seconds while waiting on resources or the server
+	EventDeduplicationWindow = 10 * time.Minute
+)
+
+// eventRecorder records events of SonarQube objects, identical events are only recorded once per deduplication window
+type eventRecorder struct {
+	recorder record.EventRecorder
+
+	mu       sync.Mutex
+	recorded map[string]time.Time
+}
+
+func newEventRecorder(recorder record.EventRecorder) *eventRecorder {
+	return &eventRecorder{
+		recorder: recorder,
+		recorded: make(map[string]time.Time),
+	}
+}
+
+// event records an event of cr unless an identical event was recorded within the deduplication window
+func (e *eventRecorder) event(cr *sonarsourcev1alpha1.SonarQube, eventType, reason, message string) {
+	if e == nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	for key, recorded := range e.recorded {
+		if now.Sub(recorded) > EventDeduplicationWindow {
+			delete(e.recorded, key)
+		}
+	}
+
+	key := strings.Join([]string{cr.Namespace, cr.Name, eventType, reason, message}, "/")
+	if _, ok := e.recorded[key]; ok {
+		return
+	}
+	e.recorded[key] = now
+
+	e.recorder.Event(cr, eventType, reason, message)
+}
+
+// parseResult records an event for the outcome of the reconciliation of cr and converts it into a reconcile result,
+// see utils.ParseErrorForReconcileResult
+func (r *ReconcileSonarQube) parseResult(cr *sonarsourcev1alpha1.SonarQube, err error) (reconcile.Result, error) {
+	if err != nil {
+		eventType, reason := eventForError(err)
+		message := err.Error()
+		if sqErr, ok := err.(*utils.Error); ok {
+			message = sqErr.Message
+		}
+		r.recorder.event(cr, eventType, reason, message)
+	}
+
+	return utils.ParseErrorForReconcileResult(r.client, cr, err)
+}
+
+// eventForError returns the type and reason of the event recorded for a reconciliation ending with err
+func eventForError(err error) (string, string) {
+	switch utils.ReasonForError(err) {
+	case utils.ErrorReasonResourceCreate:
+		return corev1.EventTypeNormal, EventReasonCreated
+	case utils.ErrorReasonResourceUpdate:
+		return corev1.EventTypeNormal, EventReasonUpdated
+	case utils.ErrorReasonSpecUpdate:
+		return corev1.EventTypeNormal, EventReasonSpecUpdated
+	case utils.ErrorReasonResourceWaiting, utils.ErrorReasonServerWaiting:
+		return corev1.EventTypeNormal, EventReasonWaiting
+	case utils.ErrorReasonResourceShutdown:
+		return corev1.EventTypeNormal, EventReasonShutdown
+	case utils.ErrorReasonSpecInvalid, utils.ErrorReasonResourceInvalid:
+		return corev1.EventTypeWarning, EventReasonInvalid
+	case utils.ErrorReasonServerAuth:
+		return corev1.EventTypeWarning, EventReasonAuthenticationFailed
+	case utils.ErrorReasonServerDown:
+		return corev1.EventTypeWarning, EventReasonServerDown
+	default:
+		return corev1.EventTypeWarning, EventReasonReconcileError
+	}
+}
+
+// upgradesMessage describes the upgrades available for SonarQube, empty when there are none
+func upgradesMessage(upgrades sonarsourcev1alpha1.Upgrades) string {
+	var available []string
+	if len(upgrades.Compatible) > 0 {
+		available = append(available, fmt.Sprintf("compatible %s", strings.Join(upgrades.Compatible, ", ")))
+	}
+	if len(upgrades.Incompatible) > 0 {
+		available = append(available, fmt.Sprintf("with incompatible plugins %s", strings.Join(upgrades.Incompatible, ", ")))
+	}
+	if len(available) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("upgrades available: %s", strings.Join(available, "; "))
+}
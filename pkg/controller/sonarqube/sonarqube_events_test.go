@@ -0,0 +1,94 @@
+package sonarqube
+
+import (
+	"testing"
+
+	"github.com/jlfowle/sonarqube-operator/pkg/api_client"
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+// TestSonarQubeEvents runs ReconcileSonarQube.parseResult(), verifyServerStatus() and verifyUpgrades()
+// against a fake client and recorder
+func TestSonarQubeEvents(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name      = "sonarqube-events"
+		namespace = "sonarqube"
+	)
+
+	// A SonarQube resource with metadata and spec.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{},
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
+	// Create a fake client to mock API calls.
+	cl := newFakeClient(s, objs...)
+	// Create a ReconcileSonarQube object with the scheme, fake client and fake recorder.
+	apiMock := &api_client.APIClientMock{
+		InfoOutput: &api_client.Status{
+			Status: api_client.SystemStarting,
+		},
+		UpgradesOutput: &api_client.Upgrades{
+			Upgrades: []api_client.Upgrade{
+				{Version: api_client.SystemVersion{Major: 8, Minor: 4}},
+			},
+		},
+	}
+	fakeRecorder := record.NewFakeRecorder(10)
+	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: apiMock, recorder: newEventRecorder(fakeRecorder)}
+
+	// Requeued reconciliations with the same outcome record a single event
+	for i := 0; i < 3; i++ {
+		r.parseResult(sonarqube, &utils.Error{Reason: utils.ErrorReasonResourceWaiting, Message: "waiting for deployment"})
+	}
+	r.parseResult(sonarqube, &utils.Error{Reason: utils.ErrorReasonSpecInvalid, Message: "expose must have a host"})
+	expectEvents(t, fakeRecorder, "Normal Waiting waiting for deployment", "Warning Invalid expose must have a host")
+
+	// Server status changes are recorded once per status
+	for _, status := range []api_client.SystemStatus{api_client.SystemStarting, api_client.SystemStarting, api_client.SystemDown} {
+		apiMock.InfoOutput.Status = status
+		r.verifyServerStatus(sonarqube, apiMock)
+	}
+	expectEvents(t, fakeRecorder, "Normal ServerStatusChanged sonarqube server status STARTING", "Warning ServerStatusChanged sonarqube server status DOWN")
+
+	if err := r.verifyUpgrades(sonarqube, apiMock.InfoOutput, apiMock); err != nil {
+		t.Fatalf("verifyUpgrades: (%v)", err)
+	}
+	expectEvents(t, fakeRecorder, "Normal UpgradesAvailable upgrades available: compatible 8.4.0")
+}
+
+// expectEvents checks the fake recorder recorded exactly the expected events
+func expectEvents(t *testing.T, recorder *record.FakeRecorder, expected ...string) {
+	var events []string
+	for len(recorder.Events) > 0 {
+		events = append(events, <-recorder.Events)
+	}
+	if len(events) != len(expected) {
+		t.Errorf("recorder: expected events %v, got %v", expected, events)
+		return
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("recorder: expected event %s, got %s", expected[i], events[i])
+		}
+	}
+}
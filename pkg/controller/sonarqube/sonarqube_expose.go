@@ -0,0 +1,309 @@
+package sonarqube
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// RouteGVK is the OpenShift Route kind, routes are handled as unstructured objects so the
+// operator doesn't depend on the OpenShift api
+var RouteGVK = schema.GroupVersionKind{Group: "route.openshift.io", Version: "v1", Kind: "Route"}
+
+// Reconciles Ingress or Route exposing the SonarQube web UI
+// Returns: Error
+// If Error is non-nil, the Ingress or Route is not in expected state
+// Errors:
+//   ErrorReasonSpecInvalid: returned when expose has no host, the route TLS secret is invalid or routes are not available
+//   ErrorReasonResourceCreate: returned when Ingress or Route does not exists
+//   ErrorReasonResourceUpdate: returned when Ingress or Route was updated to meet expected state
+//   ErrorReasonUnknown: returned when unhandled error from client occurs
+func (r *ReconcileSonarQube) ReconcileExpose(cr *sonarsourcev1alpha1.SonarQube) error {
+	var err error
+	switch exposeType(cr) {
+	case sonarsourcev1alpha1.ExposeIngress:
+		if err = r.removeOwned(cr, newRoute(), "route"); err != nil {
+			return err
+		}
+		err = r.applyIngress(cr)
+	case sonarsourcev1alpha1.ExposeRoute:
+		if err = r.removeOwned(cr, &networkingv1beta1.Ingress{}, "ingress"); err != nil {
+			return err
+		}
+		// Routes only exist on OpenShift, retrying won't make them available
+		if err = r.applyRoute(cr); err != nil && meta.IsNoMatchError(err) {
+			return &utils.Error{
+				Reason:  utils.ErrorReasonSpecInvalid,
+				Message: "routes are not available on this cluster, expose with an ingress instead",
+			}
+		}
+	default:
+		if err = r.removeOwned(cr, &networkingv1beta1.Ingress{}, "ingress"); err != nil {
+			return err
+		}
+		err = r.removeOwned(cr, newRoute(), "route")
+	}
+	if err != nil {
+		return err
+	}
+
+	url := exposeURL(cr)
+	if cr.Status.URL != url {
+		newStatus := cr.DeepCopy()
+		newStatus.Status.URL = url
+		utils.UpdateStatus(r.client, newStatus, cr)
+	}
+
+	return nil
+}
+
+func (r *ReconcileSonarQube) applyIngress(cr *sonarsourcev1alpha1.SonarQube) error {
+	if cr.Spec.Expose.Host == "" {
+		return &utils.Error{
+			Reason:  utils.ErrorReasonSpecInvalid,
+			Message: "expose must have a host",
+		}
+	}
+
+	ingress := &networkingv1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   cr.Namespace,
+			Name:        cr.Name,
+			Labels:      r.Labels(cr),
+			Annotations: cr.Spec.Expose.Annotations,
+		},
+		Spec: networkingv1beta1.IngressSpec{
+			Rules: []networkingv1beta1.IngressRule{
+				{
+					Host: cr.Spec.Expose.Host,
+					IngressRuleValue: networkingv1beta1.IngressRuleValue{
+						HTTP: &networkingv1beta1.HTTPIngressRuleValue{
+							Paths: []networkingv1beta1.HTTPIngressPath{
+								{
+									Path: exposePath(cr),
+									Backend: networkingv1beta1.IngressBackend{
+										ServiceName: cr.Name,
+										ServicePort: intstr.FromString("web"),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if cr.Spec.Expose.TLSSecret != nil {
+		ingress.Spec.TLS = []networkingv1beta1.IngressTLS{
+			{
+				Hosts:      []string{cr.Spec.Expose.Host},
+				SecretName: *cr.Spec.Expose.TLSSecret,
+			},
+		}
+	}
+
+	if err := controllerutil.SetControllerReference(cr, ingress, r.scheme); err != nil {
+		return err
+	}
+
+	return utils.ApplyResource(r.client, r.scheme, ingress, &networkingv1beta1.Ingress{}, "")
+}
+
+func (r *ReconcileSonarQube) applyRoute(cr *sonarsourcev1alpha1.SonarQube) error {
+	if cr.Spec.Expose.Host == "" {
+		return &utils.Error{
+			Reason:  utils.ErrorReasonSpecInvalid,
+			Message: "expose must have a host",
+		}
+	}
+
+	route := newRoute()
+	route.SetNamespace(cr.Namespace)
+	route.SetName(cr.Name)
+	route.SetLabels(r.Labels(cr))
+	route.SetAnnotations(cr.Spec.Expose.Annotations)
+
+	spec := map[string]interface{}{
+		"host": cr.Spec.Expose.Host,
+		"path": exposePath(cr),
+		"to": map[string]interface{}{
+			"kind":   "Service",
+			"name":   cr.Name,
+			"weight": int64(100),
+		},
+		"port": map[string]interface{}{
+			"targetPort": "web",
+		},
+	}
+
+	// Routes don't reference secrets so the certificate is copied into the route
+	if cr.Spec.Expose.TLSSecret != nil {
+		tls, err := r.getRouteTLS(cr)
+		if err != nil {
+			return err
+		}
+		spec["tls"] = tls
+	} else if servesTLS(cr) {
+		// Pods serving https without a certificate for the route are reached through the router as they are
+		if exposePath(cr) != "/" {
+			return &utils.Error{
+				Reason:  utils.ErrorReasonSpecInvalid,
+				Message: "expose path can't be set for a route passing tls through to pods",
+			}
+		}
+		delete(spec, "path")
+		spec["tls"] = map[string]interface{}{
+			"termination":                   "passthrough",
+			"insecureEdgeTerminationPolicy": "Redirect",
+		}
+	}
+
+	if err := unstructured.SetNestedField(route.Object, spec, "spec"); err != nil {
+		return err
+	}
+
+	if err := controllerutil.SetControllerReference(cr, route, r.scheme); err != nil {
+		return err
+	}
+
+	return utils.ApplyResource(r.client, r.scheme, route, newRoute(), "")
+}
+
+func (r *ReconcileSonarQube) getRouteTLS(cr *sonarsourcev1alpha1.SonarQube) (map[string]interface{}, error) {
+	secret := &corev1.Secret{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: *cr.Spec.Expose.TLSSecret, Namespace: cr.Namespace}, secret)
+	if err != nil && errors.IsNotFound(err) {
+		return nil, &utils.Error{
+			Reason:  utils.ErrorReasonSpecInvalid,
+			Message: fmt.Sprintf("tls secret %s doesn't exist", *cr.Spec.Expose.TLSSecret),
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !utils.IsOwner(cr, secret) {
+		if err := r.watchSecret(cr, secret, sonarsourcev1alpha1.SecretAnnotation); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, key := range []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey} {
+		if _, ok := secret.Data[key]; !ok {
+			return nil, &utils.Error{
+				Reason:  utils.ErrorReasonSpecInvalid,
+				Message: fmt.Sprintf("tls secret %s must contain %s", secret.Name, key),
+			}
+		}
+	}
+
+	tls := map[string]interface{}{
+		"termination":                   "edge",
+		"insecureEdgeTerminationPolicy": "Redirect",
+		"certificate":                   string(secret.Data[corev1.TLSCertKey]),
+		"key":                           string(secret.Data[corev1.TLSPrivateKeyKey]),
+	}
+
+	// Pods serving https are reached by reencrypting, their certificate is verified with the CA bundle when set
+	if servesTLS(cr) {
+		tls["termination"] = "reencrypt"
+		bundle, err := r.getCABundle(cr)
+		if err != nil {
+			return nil, err
+		}
+		if bundle != nil {
+			tls["destinationCACertificate"] = string(bundle)
+		}
+	}
+
+	return tls, nil
+}
+
+// removeOwned deletes an Ingress, Route, ServiceMonitor, Role or RoleBinding owned by cr that is no longer selected by the spec
+func (r *ReconcileSonarQube) removeOwned(cr *sonarsourcev1alpha1.SonarQube, object runtime.Object, kind string) error {
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: cr.Name, Namespace: cr.Namespace}, object)
+	if err != nil && (errors.IsNotFound(err) || meta.IsNoMatchError(err)) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if !utils.IsOwner(cr, object.(metav1.Object)) {
+		return nil
+	}
+
+	if err := r.client.Delete(context.TODO(), object); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	return &utils.Error{
+		Reason:  utils.ErrorReasonResourceUpdate,
+		Message: fmt.Sprintf("removed %s %s", kind, cr.Name),
+	}
+}
+
+func newRoute() *unstructured.Unstructured {
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(RouteGVK)
+	return route
+}
+
+// exposeType returns the kind of object exposing SonarQube, empty when the web UI is not exposed
+func exposeType(cr *sonarsourcev1alpha1.SonarQube) sonarsourcev1alpha1.ExposeType {
+	if cr.Spec.Expose == nil || nodeType(cr) == sonarsourcev1alpha1.Search {
+		return ""
+	}
+	if cr.Spec.Expose.Type == nil {
+		return sonarsourcev1alpha1.ExposeIngress
+	}
+	return *cr.Spec.Expose.Type
+}
+
+// exposePath returns the path SonarQube is exposed at, SonarQube is served under it with sonar.web.context
+func exposePath(cr *sonarsourcev1alpha1.SonarQube) string {
+	if cr.Spec.Expose.Path == nil || *cr.Spec.Expose.Path == "" {
+		return "/"
+	}
+	return *cr.Spec.Expose.Path
+}
+
+// webContext returns sonar.web.context of SonarQube, empty when it is served at the root path
+func webContext(cr *sonarsourcev1alpha1.SonarQube) string {
+	if exposeType(cr) == "" {
+		return ""
+	}
+	return strings.TrimSuffix(exposePath(cr), "/")
+}
+
+// exposeURL returns the url SonarQube is exposed at, used for sonar.core.serverBaseURL
+func exposeURL(cr *sonarsourcev1alpha1.SonarQube) string {
+	if exposeType(cr) == "" {
+		return ""
+	}
+
+	scheme := "http"
+	if cr.Spec.Expose.TLSSecret != nil || (exposeType(cr) == sonarsourcev1alpha1.ExposeRoute && servesTLS(cr)) {
+		scheme = "https"
+	}
+
+	path := exposePath(cr)
+	if path == "/" {
+		path = ""
+	}
+
+	return fmt.Sprintf("%s://%s%s", scheme, cr.Spec.Expose.Host, path)
+}
@@ -0,0 +1,298 @@
+package sonarqube
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jlfowle/sonarqube-operator/pkg/api_client"
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+// TestSonarQubeExpose runs ReconcileSonarQube.ReconcileExpose() against a
+// fake client
+func TestSonarQubeExpose(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name           = "sonarqube-operator"
+		namespace      = "sonarqube"
+		namespacedName = types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		}
+	)
+
+	// A SonarQube resource with metadata and spec.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{
+			Expose: &sonarsourcev1alpha1.Expose{
+				Host:        "sonarqube.example.com",
+				TLSSecret:   &[]string{"sonarqube-tls"}[0],
+				Annotations: map[string]string{"kubernetes.io/ingress.class": "nginx"},
+			},
+		},
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
+	// Create a fake client to mock API calls.
+	cl := newFakeClient(s, objs...)
+	// Create a ReconcileSonarQube object with the scheme and fake client.
+	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: &api_client.APIClientMock{}}
+
+	err := r.ReconcileExpose(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceCreate {
+		t.Error("ReconcileExpose: resource created error not thrown when creating Ingress")
+	}
+	ingress := &networkingv1beta1.Ingress{}
+	err = r.client.Get(context.TODO(), namespacedName, ingress)
+	if err != nil {
+		t.Fatalf("ReconcileExpose: (%v)", err)
+	}
+	if len(ingress.Spec.Rules) != 1 || ingress.Spec.Rules[0].Host != "sonarqube.example.com" || ingress.Spec.Rules[0].HTTP.Paths[0].Path != "/" {
+		t.Error("ReconcileExpose: ingress rule not set from spec")
+	}
+	if len(ingress.Spec.TLS) != 1 || ingress.Spec.TLS[0].SecretName != "sonarqube-tls" {
+		t.Error("ReconcileExpose: ingress tls not set from spec")
+	}
+	if ingress.Annotations["kubernetes.io/ingress.class"] != "nginx" {
+		t.Error("ReconcileExpose: ingress annotations not set from spec")
+	}
+
+	err = r.ReconcileExpose(sonarqube)
+	if err != nil {
+		t.Errorf("ReconcileExpose: returned error even though Ingress is in expected state (%v)", err)
+	}
+	err = r.client.Get(context.TODO(), namespacedName, sonarqube)
+	if err != nil {
+		t.Fatalf("ReconcileExpose: (%v)", err)
+	}
+	if sonarqube.Status.URL != "https://sonarqube.example.com" {
+		t.Errorf("ReconcileExpose: unexpected url in status %s", sonarqube.Status.URL)
+	}
+
+	sonarqube.Spec.Expose.Path = &[]string{"/sonarqube"}[0]
+	err = r.client.Update(context.TODO(), sonarqube)
+	if err != nil {
+		t.Fatalf("ReconcileExpose: (%v)", err)
+	}
+	err = r.ReconcileExpose(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceUpdate {
+		t.Error("ReconcileExpose: resource update error not thrown when path changed")
+	}
+	if exposeURL(sonarqube) != "https://sonarqube.example.com/sonarqube" {
+		t.Errorf("exposeURL: path not added to url %s", exposeURL(sonarqube))
+	}
+
+	// SonarQube is served under the path it is exposed at
+	if webContext(sonarqube) != "/sonarqube" {
+		t.Errorf("webContext: path not used as web context %s", webContext(sonarqube))
+	}
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: "10.0.0.1",
+			Ports:     []corev1.ServicePort{{Port: sonarsourcev1alpha1.ApplicationWebPort}},
+		},
+	}
+	if url := serverURL(sonarqube, service); url != "http://10.0.0.1:9000/sonarqube" {
+		t.Errorf("serverURL: web context not added to url %s", url)
+	}
+	for {
+		_, err := r.ReconcilePasscode(sonarqube)
+		if err != nil && utils.ReasonForError(err) == utils.ErrorReasonUnknown {
+			t.Fatalf("reconcilePasscode: (%v)", err)
+		} else if err == nil {
+			break
+		}
+	}
+	template, err := r.newPodTemplate(sonarqube, sonarsourcev1alpha1.AIO, r.PodLabels(sonarqube), &corev1.ServiceAccount{}, &corev1.Secret{}, podVolumes(sonarqube, nil))
+	if err != nil {
+		t.Fatalf("newPodTemplate: (%v)", err)
+	}
+	env := make(map[string]string)
+	for _, e := range template.Spec.Containers[0].Env {
+		env[e.Name] = e.Value
+	}
+	if env["SONAR_WEB_CONTEXT"] != "/sonarqube" {
+		t.Errorf("newPodTemplate: sonar.web.context not set %s", env["SONAR_WEB_CONTEXT"])
+	}
+	if path := template.Spec.Containers[0].ReadinessProbe.HTTPGet.Path; path != "/sonarqube/api/system/status" {
+		t.Errorf("newPodTemplate: readiness probe doesn't check the web context %s", path)
+	}
+
+	// Routes copy the certificate from the tls secret
+	sonarqube.Spec.Expose.Type = &[]sonarsourcev1alpha1.ExposeType{sonarsourcev1alpha1.ExposeRoute}[0]
+	err = r.client.Update(context.TODO(), sonarqube)
+	if err != nil {
+		t.Fatalf("ReconcileExpose: (%v)", err)
+	}
+	err = r.ReconcileExpose(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceUpdate {
+		t.Error("ReconcileExpose: resource update error not thrown when removing Ingress")
+	}
+	err = r.client.Get(context.TODO(), namespacedName, &networkingv1beta1.Ingress{})
+	if !errors.IsNotFound(err) {
+		t.Error("ReconcileExpose: Ingress not removed when exposed with Route")
+	}
+
+	err = r.ReconcileExpose(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonSpecInvalid {
+		t.Error("ReconcileExpose: spec invalid error not thrown when tls secret doesn't exist")
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      "sonarqube-tls",
+		},
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       []byte("cert"),
+			corev1.TLSPrivateKeyKey: []byte("key"),
+		},
+	}
+	err = r.client.Create(context.TODO(), secret)
+	if err != nil {
+		t.Fatalf("ReconcileExpose: (%v)", err)
+	}
+
+	err = r.ReconcileExpose(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceUpdate {
+		t.Error("ReconcileExpose: resource update error not thrown when annotating tls secret")
+	}
+
+	err = r.ReconcileExpose(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceCreate {
+		t.Errorf("ReconcileExpose: resource created error not thrown when creating Route (%v)", err)
+	}
+	route := newRoute()
+	err = r.client.Get(context.TODO(), namespacedName, route)
+	if err != nil {
+		t.Fatalf("ReconcileExpose: (%v)", err)
+	}
+	if host, _, _ := unstructured.NestedString(route.Object, "spec", "host"); host != "sonarqube.example.com" {
+		t.Error("ReconcileExpose: route host not set from spec")
+	}
+	if cert, _, _ := unstructured.NestedString(route.Object, "spec", "tls", "certificate"); cert != "cert" {
+		t.Error("ReconcileExpose: route certificate not copied from tls secret")
+	}
+
+	sonarqube.Spec.Expose = nil
+	err = r.client.Update(context.TODO(), sonarqube)
+	if err != nil {
+		t.Fatalf("ReconcileExpose: (%v)", err)
+	}
+	err = r.ReconcileExpose(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceUpdate {
+		t.Error("ReconcileExpose: resource update error not thrown when removing Route")
+	}
+	err = r.ReconcileExpose(sonarqube)
+	if err != nil {
+		t.Errorf("ReconcileExpose: returned error when not exposed (%v)", err)
+	}
+	err = r.client.Get(context.TODO(), namespacedName, sonarqube)
+	if err != nil {
+		t.Fatalf("ReconcileExpose: (%v)", err)
+	}
+	if sonarqube.Status.URL != "" {
+		t.Error("ReconcileExpose: url not cleared from status")
+	}
+}
+
+// noRouteClient is a client of a cluster without the Route kind
+type noRouteClient struct {
+	client.Client
+}
+
+func (c *noRouteClient) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	if obj.GetObjectKind().GroupVersionKind() == RouteGVK {
+		return &meta.NoKindMatchError{GroupKind: RouteGVK.GroupKind(), SearchedVersions: []string{RouteGVK.Version}}
+	}
+	return c.Client.Get(ctx, key, obj)
+}
+
+func (c *noRouteClient) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if obj.GetObjectKind().GroupVersionKind() == RouteGVK {
+		return &meta.NoKindMatchError{GroupKind: RouteGVK.GroupKind(), SearchedVersions: []string{RouteGVK.Version}}
+	}
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *noRouteClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	if obj.GetObjectKind().GroupVersionKind() == RouteGVK {
+		return &meta.NoKindMatchError{GroupKind: RouteGVK.GroupKind(), SearchedVersions: []string{RouteGVK.Version}}
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+// TestSonarQubeExposeWithoutRoutes runs ReconcileSonarQube.ReconcileExpose() against a
+// fake client of a cluster without routes
+func TestSonarQubeExposeWithoutRoutes(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name      = "sonarqube-operator"
+		namespace = "sonarqube"
+	)
+
+	// A SonarQube resource with metadata and spec.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{
+			Expose: &sonarsourcev1alpha1.Expose{
+				Type: &[]sonarsourcev1alpha1.ExposeType{sonarsourcev1alpha1.ExposeRoute}[0],
+				Host: "sonarqube.example.com",
+			},
+		},
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
+	// Create a fake client without routes to mock API calls.
+	cl := &noRouteClient{Client: newFakeClient(s, objs...)}
+	// Create a ReconcileSonarQube object with the scheme and fake client.
+	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: &api_client.APIClientMock{}}
+
+	err := r.ReconcileExpose(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonSpecInvalid {
+		t.Errorf("ReconcileExpose: spec invalid error not thrown when routes are not available (%v)", err)
+	}
+
+	// Ingresses don't need routes
+	sonarqube.Spec.Expose.Type = &[]sonarsourcev1alpha1.ExposeType{sonarsourcev1alpha1.ExposeIngress}[0]
+	err = r.ReconcileExpose(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceCreate {
+		t.Errorf("ReconcileExpose: resource created error not thrown when creating Ingress (%v)", err)
+	}
+}
@@ -0,0 +1,80 @@
+package sonarqube
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jlfowle/sonarqube-operator/pkg/api_client"
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	"github.com/operator-framework/operator-sdk/pkg/status"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Reconciles health reported by SonarQube
+// Returns: Error
+// If Error is non-nil, the server is not healthy
+// Errors:
+//   ErrorReasonServerWaiting: returned when the server reports RED health
+//   ErrorReasonServerAuth: returned when the credentials can't read the server health
+//   ErrorReasonUnknown: returned when unhandled error from client occurs
+func (r *ReconcileSonarQube) verifyServerHealth(cr *sonarsourcev1alpha1.SonarQube, apiClient api_client.APIReader) error {
+	health, err := apiClient.Health()
+	if err != nil {
+		return parseAPIError(err)
+	} else if health == nil {
+		return fmt.Errorf("nil returned for health")
+	}
+
+	newStatus := cr.DeepCopy()
+	newStatus.Status.Health = sonarsourcev1alpha1.Health{
+		Status: string(health.Health),
+		Causes: healthCauses(health.Causes),
+	}
+	for _, node := range health.Nodes {
+		newStatus.Status.Health.Nodes = append(newStatus.Status.Health.Nodes, sonarsourcev1alpha1.NodeHealth{
+			Name:   node.Name,
+			Type:   string(node.Type),
+			Host:   node.Host,
+			Status: string(node.Health),
+			Causes: healthCauses(node.Causes),
+		})
+	}
+
+	if health.Health == api_client.HealthRed {
+		message := fmt.Sprintf("sonarqube health %s", health.Health)
+		if causes := newStatus.Status.Health.Causes; len(causes) > 0 {
+			message = fmt.Sprintf("%s (%s)", message, strings.Join(causes, ", "))
+		}
+		newStatus.Status.Conditions.SetCondition(status.Condition{
+			Type:    sonarsourcev1alpha1.ConditionUnavailable,
+			Status:  corev1.ConditionTrue,
+			Reason:  sonarsourcev1alpha1.ConditionServerUnhealthy,
+			Message: message,
+		})
+		utils.UpdateStatus(r.client, newStatus, cr)
+
+		return &utils.Error{
+			Reason:  utils.ErrorReasonServerWaiting,
+			Message: message,
+		}
+	}
+
+	newStatus.Status.Conditions.SetCondition(status.Condition{
+		Type:    sonarsourcev1alpha1.ConditionUnavailable,
+		Status:  corev1.ConditionFalse,
+		Reason:  sonarsourcev1alpha1.ConditionServerHealthy,
+		Message: fmt.Sprintf("sonarqube health %s", health.Health),
+	})
+	utils.UpdateStatus(r.client, newStatus, cr)
+
+	return nil
+}
+
+func healthCauses(causes []api_client.HealthCause) []string {
+	var messages []string
+	for _, cause := range causes {
+		messages = append(messages, cause.Message)
+	}
+	return messages
+}
@@ -0,0 +1,117 @@
+package sonarqube
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jlfowle/sonarqube-operator/pkg/api_client"
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+// TestSonarQubeHealth runs ReconcileSonarQube.verifyServerHealth() against a
+// fake client
+func TestSonarQubeHealth(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name           = "sonarqube-operator"
+		namespace      = "sonarqube"
+		namespacedName = types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		}
+	)
+
+	// A SonarQube resource with metadata and spec.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{
+			Cluster: &sonarsourcev1alpha1.Cluster{},
+		},
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
+	// Create a fake client to mock API calls.
+	cl := newFakeClient(s, objs...)
+	// Create a ReconcileSonarQube object with the scheme and fake client.
+	apiMock := &api_client.APIClientMock{
+		HealthOutput: &api_client.Health{
+			Health: api_client.HealthRed,
+			Causes: []api_client.HealthCause{{Message: "search cluster is RED"}},
+			Nodes: []api_client.NodeHealth{
+				{
+					Name:   "sonarqube-operator-abcde",
+					Type:   api_client.NodeApplication,
+					Host:   "10.0.0.1",
+					Health: api_client.HealthGreen,
+				},
+				{
+					Name:   "sonarqube-operator-search-0",
+					Type:   api_client.NodeSearch,
+					Host:   "10.0.0.2",
+					Health: api_client.HealthRed,
+					Causes: []api_client.HealthCause{{Message: "shards unassigned"}},
+				},
+			},
+		},
+	}
+	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: apiMock}
+
+	err := r.verifyServerHealth(sonarqube, apiMock)
+	if utils.ReasonForError(err) != utils.ErrorReasonServerWaiting {
+		t.Error("verifyServerHealth: server waiting error not thrown when health is RED")
+	}
+	err = r.client.Get(context.TODO(), namespacedName, sonarqube)
+	if err != nil {
+		t.Fatalf("verifyServerHealth: (%v)", err)
+	}
+	if sonarqube.Status.Health.Status != string(api_client.HealthRed) || len(sonarqube.Status.Health.Causes) != 1 {
+		t.Error("verifyServerHealth: overall health not recorded")
+	}
+	if nodes := sonarqube.Status.Health.Nodes; len(nodes) != 2 || nodes[1].Status != string(api_client.HealthRed) || nodes[1].Causes[0] != "shards unassigned" {
+		t.Errorf("verifyServerHealth: node health not recorded %v", nodes)
+	}
+	if c := sonarqube.Status.Conditions.GetCondition(sonarsourcev1alpha1.ConditionUnavailable); c == nil || !c.IsTrue() || c.Reason != sonarsourcev1alpha1.ConditionServerUnhealthy {
+		t.Error("verifyServerHealth: condition unavailable not set when health is RED")
+	}
+
+	apiMock.HealthOutput = &api_client.Health{
+		Health: api_client.HealthYellow,
+	}
+	err = r.verifyServerHealth(sonarqube, apiMock)
+	if err != nil {
+		t.Errorf("verifyServerHealth: returned error even though health is YELLOW (%v)", err)
+	}
+	err = r.client.Get(context.TODO(), namespacedName, sonarqube)
+	if err != nil {
+		t.Fatalf("verifyServerHealth: (%v)", err)
+	}
+	if len(sonarqube.Status.Health.Nodes) != 0 {
+		t.Error("verifyServerHealth: node health not cleared")
+	}
+	if !sonarqube.Status.Conditions.IsFalseFor(sonarsourcev1alpha1.ConditionUnavailable) {
+		t.Error("verifyServerHealth: condition unavailable not cleared")
+	}
+
+	apiMock.HealthError = &api_client.AuthenticationError{StatusCode: 403, Path: "/api/system/health"}
+	err = r.verifyServerHealth(sonarqube, apiMock)
+	if utils.ReasonForError(err) != utils.ErrorReasonServerAuth {
+		t.Error("verifyServerHealth: server auth error not thrown when credentials are rejected")
+	}
+}
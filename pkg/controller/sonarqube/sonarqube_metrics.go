@@ -0,0 +1,114 @@
+package sonarqube
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/jlfowle/sonarqube-operator/pkg/api_client"
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	serverStatusDesc = prometheus.NewDesc(
+		"sonarqube_operator_server_status",
+		"Status reported by the SonarQube server, 1 for the current status",
+		[]string{"namespace", "name", "status"}, nil,
+	)
+	serverVersionDesc = prometheus.NewDesc(
+		"sonarqube_operator_server_version_info",
+		"Version of SonarQube desired in spec and observed on the server",
+		[]string{"namespace", "name", "version", "observed_version", "matches"}, nil,
+	)
+	serverUpgradesDesc = prometheus.NewDesc(
+		"sonarqube_operator_server_upgrades_available",
+		"Upgrades available for the SonarQube server by compatibility of the installed plugins",
+		[]string{"namespace", "name", "compatibility"}, nil,
+	)
+
+	// systemStatuses are exported for every server so alerts can match on a status being 1
+	systemStatuses = []api_client.SystemStatus{
+		api_client.SystemUp,
+		api_client.SystemDown,
+		api_client.SystemStarting,
+		api_client.SystemRestarting,
+		api_client.SystemDBMigrationNeeded,
+		api_client.SystemDBMigrationRunning,
+	}
+
+	servers = &serverCollector{servers: make(map[types.NamespacedName]serverMetrics)}
+)
+
+func init() {
+	// Metrics in the controller-runtime registry are served by the manager
+	metrics.Registry.MustRegister(servers)
+	metrics.Registry.MustRegister(api_client.Collectors()...)
+}
+
+// serverMetrics is the state of a SonarQube server last observed by the operator
+type serverMetrics struct {
+	Status          api_client.SystemStatus
+	Version         string
+	ObservedVersion string
+	VersionMatches  bool
+	Compatible      int
+	Incompatible    int
+}
+
+// serverCollector collects the metrics of the SonarQube servers observed by the operator,
+// servers are removed with their SonarQube or when it is shut down so no stale series are exported
+type serverCollector struct {
+	mu      sync.Mutex
+	servers map[types.NamespacedName]serverMetrics
+}
+
+func (c *serverCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- serverStatusDesc
+	ch <- serverVersionDesc
+	ch <- serverUpgradesDesc
+}
+
+func (c *serverCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, server := range c.servers {
+		if server.Status != "" {
+			for _, status := range systemStatuses {
+				value := 0.0
+				if status == server.Status {
+					value = 1
+				}
+				ch <- prometheus.MustNewConstMetric(serverStatusDesc, prometheus.GaugeValue, value, key.Namespace, key.Name, string(status))
+			}
+		}
+
+		if server.ObservedVersion != "" {
+			matches := strconv.FormatBool(server.VersionMatches)
+			ch <- prometheus.MustNewConstMetric(serverVersionDesc, prometheus.GaugeValue, 1, key.Namespace, key.Name, server.Version, server.ObservedVersion, matches)
+			ch <- prometheus.MustNewConstMetric(serverUpgradesDesc, prometheus.GaugeValue, float64(server.Compatible), key.Namespace, key.Name, "compatible")
+			ch <- prometheus.MustNewConstMetric(serverUpgradesDesc, prometheus.GaugeValue, float64(server.Incompatible), key.Namespace, key.Name, "incompatible")
+		}
+	}
+}
+
+// update applies f to the metrics of the server of cr
+func (c *serverCollector) update(cr *sonarsourcev1alpha1.SonarQube, f func(server *serverMetrics)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := types.NamespacedName{Name: cr.Name, Namespace: cr.Namespace}
+	server := c.servers[key]
+	f(&server)
+	c.servers[key] = server
+}
+
+// remove drops the metrics of the server of a deleted or shut down SonarQube
+func (c *serverCollector) remove(key types.NamespacedName) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.servers, key)
+}
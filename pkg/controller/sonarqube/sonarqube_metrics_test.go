@@ -0,0 +1,144 @@
+package sonarqube
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jlfowle/sonarqube-operator/pkg/api_client"
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+// TestSonarQubeMetrics runs ReconcileSonarQube.verifyServerStatus(), verifyServerVersion() and
+// verifyUpgrades() against a fake client and checks the metrics of the server
+func TestSonarQubeMetrics(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name           = "sonarqube-metrics"
+		namespace      = "sonarqube"
+		namespacedName = types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		}
+	)
+
+	// A SonarQube resource with metadata and spec.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{
+			Version: &[]string{"8.3"}[0],
+		},
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
+	// Create a fake client to mock API calls.
+	cl := newFakeClient(s, objs...)
+	// Create a ReconcileSonarQube object with the scheme and fake client.
+	apiMock := &api_client.APIClientMock{
+		InfoOutput: &api_client.Status{
+			Status:  api_client.SystemStarting,
+			Version: api_client.SystemVersion{Major: 8, Minor: 3, Patch: 1, Build: "34397"},
+		},
+		UpgradesOutput: &api_client.Upgrades{
+			Upgrades: []api_client.Upgrade{
+				{Version: api_client.SystemVersion{Major: 8, Minor: 4}},
+				{
+					Version: api_client.SystemVersion{Major: 8, Minor: 5},
+					Plugins: api_client.Plugins{Incompatible: []api_client.Plugin{{Key: "legacy"}}},
+				},
+			},
+		},
+	}
+	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: apiMock}
+
+	status, err := r.verifyServerStatus(sonarqube, apiMock)
+	if utils.ReasonForError(err) != utils.ErrorReasonServerWaiting {
+		t.Errorf("verifyServerStatus: server waiting error not thrown when starting (%v)", err)
+	}
+	if err := r.verifyServerVersion(sonarqube, status); err != nil {
+		t.Fatalf("verifyServerVersion: (%v)", err)
+	}
+	if err := r.verifyUpgrades(sonarqube, status, apiMock); err != nil {
+		t.Fatalf("verifyUpgrades: (%v)", err)
+	}
+
+	expected := `
+# HELP sonarqube_operator_server_status Status reported by the SonarQube server, 1 for the current status
+# TYPE sonarqube_operator_server_status gauge
+sonarqube_operator_server_status{name="sonarqube-metrics",namespace="sonarqube",status="DB_MIGRATION_NEEDED"} 0
+sonarqube_operator_server_status{name="sonarqube-metrics",namespace="sonarqube",status="DB_MIGRATION_RUNNING"} 0
+sonarqube_operator_server_status{name="sonarqube-metrics",namespace="sonarqube",status="DOWN"} 0
+sonarqube_operator_server_status{name="sonarqube-metrics",namespace="sonarqube",status="RESTARTING"} 0
+sonarqube_operator_server_status{name="sonarqube-metrics",namespace="sonarqube",status="STARTING"} 1
+sonarqube_operator_server_status{name="sonarqube-metrics",namespace="sonarqube",status="UP"} 0
+# HELP sonarqube_operator_server_version_info Version of SonarQube desired in spec and observed on the server
+# TYPE sonarqube_operator_server_version_info gauge
+sonarqube_operator_server_version_info{matches="true",name="sonarqube-metrics",namespace="sonarqube",observed_version="8.3.1.34397",version="8.3"} 1
+# HELP sonarqube_operator_server_upgrades_available Upgrades available for the SonarQube server by compatibility of the installed plugins
+# TYPE sonarqube_operator_server_upgrades_available gauge
+sonarqube_operator_server_upgrades_available{compatibility="compatible",name="sonarqube-metrics",namespace="sonarqube"} 1
+sonarqube_operator_server_upgrades_available{compatibility="incompatible",name="sonarqube-metrics",namespace="sonarqube"} 1
+`
+	// Other tests share the collector, only the metrics of this server are compared
+	collector := &serverCollector{servers: map[types.NamespacedName]serverMetrics{namespacedName: servers.servers[namespacedName]}}
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected)); err != nil {
+		t.Errorf("serverCollector: unexpected metrics (%v)", err)
+	}
+
+	// Reconciliations are counted by the reason they ended with
+	invalid := testutil.ToFloat64(utils.ReconcileResults.WithLabelValues("SonarQube", string(utils.ErrorReasonSpecInvalid)))
+	utils.ParseErrorForReconcileResult(r.client, sonarqube, &utils.Error{Reason: utils.ErrorReasonSpecInvalid, Message: "invalid"})
+	if testutil.ToFloat64(utils.ReconcileResults.WithLabelValues("SonarQube", string(utils.ErrorReasonSpecInvalid))) != invalid+1 {
+		t.Error("ParseErrorForReconcileResult: reconcile result not counted by reason")
+	}
+
+	// Metrics of shut down SonarQubes are dropped
+	sonarqube.Spec.Shutdown = &[]bool{true}[0]
+	if err := cl.Update(context.TODO(), sonarqube); err != nil {
+		t.Fatalf("update sonarqube: (%v)", err)
+	}
+	for {
+		res, err := r.Reconcile(reconcile.Request{NamespacedName: namespacedName})
+		if err != nil {
+			t.Fatalf(ReconcileErrorFormat, err)
+		} else if !res.Requeue && res.RequeueAfter == 0 {
+			break
+		}
+	}
+	if _, ok := servers.servers[namespacedName]; ok {
+		t.Error("serverCollector: metrics of shut down server not dropped")
+	}
+
+	// Metrics of deleted SonarQubes are dropped
+	servers.update(sonarqube, func(server *serverMetrics) {
+		server.Status = api_client.SystemUp
+	})
+	if err := cl.Delete(context.TODO(), sonarqube); err != nil {
+		t.Fatalf("delete sonarqube: (%v)", err)
+	}
+	if _, err := r.Reconcile(reconcile.Request{NamespacedName: namespacedName}); err != nil {
+		t.Fatalf(ReconcileErrorFormat, err)
+	}
+	if _, ok := servers.servers[namespacedName]; ok {
+		t.Error("serverCollector: metrics of deleted server not dropped")
+	}
+}
@@ -0,0 +1,101 @@
+package sonarqube
+
+import (
+	"fmt"
+
+	"github.com/jlfowle/sonarqube-operator/pkg/api_client"
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	"github.com/operator-framework/operator-sdk/pkg/status"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Reconciles database migration for SonarQube
+// Returns: Error
+// If Error is non-nil, database migration is required or hasn't completed
+// Errors:
+//   ErrorReasonServerWaiting: returned when migration is required or running
+//   ErrorReasonServerDown: returned when migration failed
+//   ErrorReasonUnknown: returned when unhandled error from api occurs
+func (r *ReconcileSonarQube) reconcileMigration(cr *sonarsourcev1alpha1.SonarQube, apiClient api_client.APIReader) error {
+	migration, err := apiClient.DBMigrationStatus()
+	if _, ok := err.(*api_client.AuthenticationError); ok {
+		return parseAPIError(err)
+	} else if err != nil {
+		return &utils.Error{
+			Reason:  utils.ErrorReasonServerWaiting,
+			Message: fmt.Sprintf("waiting for database migration status (%s)", err.Error()),
+		}
+	} else if migration == nil {
+		return fmt.Errorf("nil returned for database migration status")
+	}
+
+	if migration.State == api_client.MigrationRequired && migrationAllowed(cr) {
+		migration, err = apiClient.MigrateDB()
+		if err != nil {
+			return parseAPIError(err)
+		} else if migration == nil {
+			return fmt.Errorf("nil returned for database migration")
+		}
+	}
+
+	r.updateMigrationStatus(cr, migration)
+
+	switch migration.State {
+	case api_client.MigrationRequired:
+		return &utils.Error{
+			Reason:  utils.ErrorReasonServerWaiting,
+			Message: "database migration required, set migrateDatabase to start it",
+		}
+	case api_client.MigrationRunning:
+		return &utils.Error{
+			Reason:  utils.ErrorReasonServerWaiting,
+			Message: fmt.Sprintf("database migration running since %s", migration.StartedAt),
+		}
+	case api_client.MigrationFailed:
+		if upgradeInProgress(cr) {
+			r.failUpgrade(cr, fmt.Sprintf("database migration failed (%s)", migration.Message))
+		}
+		return &utils.Error{
+			Reason:  utils.ErrorReasonServerDown,
+			Message: fmt.Sprintf("database migration failed (%s)", migration.Message),
+		}
+	}
+
+	return nil
+}
+
+func (r *ReconcileSonarQube) updateMigrationStatus(cr *sonarsourcev1alpha1.SonarQube, migration *api_client.DBMigrationStatus) {
+	newStatus := cr.DeepCopy()
+	newStatus.Status.Migration = sonarsourcev1alpha1.Migration{
+		State:     string(migration.State),
+		Message:   migration.Message,
+		StartedAt: migration.StartedAt,
+	}
+
+	condition := status.Condition{
+		Type:    sonarsourcev1alpha1.ConditionMigrating,
+		Status:  corev1.ConditionFalse,
+		Message: migration.Message,
+	}
+	switch migration.State {
+	case api_client.MigrationRequired:
+		condition.Reason = sonarsourcev1alpha1.ConditionMigrationRequired
+	case api_client.MigrationRunning:
+		condition.Status = corev1.ConditionTrue
+		condition.Reason = sonarsourcev1alpha1.ConditionMigrationRunning
+	case api_client.MigrationSucceeded:
+		condition.Reason = sonarsourcev1alpha1.ConditionMigrationSucceeded
+	case api_client.MigrationFailed:
+		condition.Reason = sonarsourcev1alpha1.ConditionMigrationFailed
+	}
+	if condition.Reason != "" {
+		newStatus.Status.Conditions.SetCondition(condition)
+	}
+
+	utils.UpdateStatus(r.client, newStatus, cr)
+}
+
+func migrationAllowed(cr *sonarsourcev1alpha1.SonarQube) bool {
+	return (cr.Spec.MigrateDatabase != nil && *cr.Spec.MigrateDatabase) || upgradeInProgress(cr)
+}
@@ -0,0 +1,121 @@
+package sonarqube
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jlfowle/sonarqube-operator/pkg/api_client"
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+// TestSonarQubeMigration runs ReconcileSonarQube.verifyServerStatus() against a
+// fake client while the server requires a database migration
+func TestSonarQubeMigration(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name           = "sonarqube-operator"
+		namespace      = "sonarqube"
+		namespacedName = types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		}
+	)
+
+	// A SonarQube resource with metadata and spec.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{},
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
+	// Create a fake client to mock API calls.
+	cl := newFakeClient(s, objs...)
+	// Create a ReconcileSonarQube object with the scheme and fake client.
+	apiMock := &api_client.APIClientMock{
+		InfoOutput: &api_client.Status{
+			Status: api_client.SystemDBMigrationNeeded,
+		},
+		DBMigrationStatusOutput: &api_client.DBMigrationStatus{
+			State: api_client.MigrationRequired,
+		},
+		MigrateDBOutput: &api_client.DBMigrationStatus{
+			State:     api_client.MigrationRunning,
+			StartedAt: "2020-06-01T12:00:00+0000",
+		},
+	}
+	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: apiMock}
+
+	_, err := r.verifyServerStatus(sonarqube, apiMock)
+	if utils.ReasonForError(err) != utils.ErrorReasonServerWaiting {
+		t.Error("verifyServerStatus: server waiting error not thrown when migration is required")
+	}
+	err = r.client.Get(context.TODO(), namespacedName, sonarqube)
+	if err != nil {
+		t.Fatalf("verifyServerStatus: (%v)", err)
+	}
+	if sonarqube.Status.Migration.State != string(api_client.MigrationRequired) {
+		t.Error("verifyServerStatus: migration state not recorded")
+	}
+	if c := sonarqube.Status.Conditions.GetCondition(sonarsourcev1alpha1.ConditionMigrating); c == nil || c.Reason != sonarsourcev1alpha1.ConditionMigrationRequired {
+		t.Error("verifyServerStatus: migration required condition not set")
+	}
+
+	sonarqube.Spec.MigrateDatabase = &[]bool{true}[0]
+	err = r.client.Update(context.TODO(), sonarqube)
+	if err != nil {
+		t.Fatalf("verifyServerStatus: (%v)", err)
+	}
+
+	_, err = r.verifyServerStatus(sonarqube, apiMock)
+	if utils.ReasonForError(err) != utils.ErrorReasonServerWaiting {
+		t.Error("verifyServerStatus: server waiting error not thrown while migration is running")
+	}
+	err = r.client.Get(context.TODO(), namespacedName, sonarqube)
+	if err != nil {
+		t.Fatalf("verifyServerStatus: (%v)", err)
+	}
+	if sonarqube.Status.Migration.State != string(api_client.MigrationRunning) || sonarqube.Status.Migration.StartedAt == "" {
+		t.Error("verifyServerStatus: running migration not recorded")
+	}
+	if !sonarqube.Status.Conditions.IsTrueFor(sonarsourcev1alpha1.ConditionMigrating) {
+		t.Error("verifyServerStatus: condition migrating not set")
+	}
+
+	apiMock.InfoOutput.Status = api_client.SystemUp
+	apiMock.DBMigrationStatusOutput.State = api_client.MigrationSucceeded
+	_, err = r.verifyServerStatus(sonarqube, apiMock)
+	if err != nil {
+		t.Errorf("verifyServerStatus: returned error even though migration succeeded (%v)", err)
+	}
+	err = r.client.Get(context.TODO(), namespacedName, sonarqube)
+	if err != nil {
+		t.Fatalf("verifyServerStatus: (%v)", err)
+	}
+	if c := sonarqube.Status.Conditions.GetCondition(sonarsourcev1alpha1.ConditionMigrating); c == nil || c.IsTrue() || c.Reason != sonarsourcev1alpha1.ConditionMigrationSucceeded {
+		t.Error("verifyServerStatus: migration succeeded condition not set")
+	}
+
+	apiMock.InfoOutput.Status = api_client.SystemDBMigrationRunning
+	apiMock.DBMigrationStatusOutput.State = api_client.MigrationFailed
+	_, err = r.verifyServerStatus(sonarqube, apiMock)
+	if utils.ReasonForError(err) != utils.ErrorReasonServerDown {
+		t.Error("verifyServerStatus: server down error not thrown when migration failed")
+	}
+}
@@ -0,0 +1,150 @@
+package sonarqube
+
+import (
+	"fmt"
+
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	"github.com/operator-framework/operator-sdk/pkg/metrics"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// ServiceMonitorGVK is the prometheus-operator ServiceMonitor kind, service monitors are handled as unstructured
+// objects so the operator doesn't depend on the prometheus-operator api
+var ServiceMonitorGVK = schema.GroupVersionKind{Group: "monitoring.coreos.com", Version: "v1", Kind: "ServiceMonitor"}
+
+// Reconciles the ServiceMonitor scraping /api/monitoring/metrics of SonarQube with the monitoring passcode
+// The ServiceMonitor is skipped when prometheus-operator is not installed
+// Returns: Error
+// If Error is non-nil, the ServiceMonitor is not in expected state
+// Errors:
+//   ErrorReasonSpecInvalid: returned when the passcode secret or CA bundle in spec is invalid
+//   ErrorReasonResourceCreate: returned when ServiceMonitor or passcode Secret does not exists
+//   ErrorReasonResourceUpdate: returned when ServiceMonitor was updated to meet expected state or removed
+//   ErrorReasonUnknown: returned when unhandled error from client occurs
+func (r *ReconcileSonarQube) ReconcileMonitoring(cr *sonarsourcev1alpha1.SonarQube) error {
+	if !monitored(cr) {
+		return r.removeOwned(cr, newServiceMonitor(), "servicemonitor")
+	}
+
+	passcodeSecret, err := r.ReconcilePasscode(cr)
+	if err != nil {
+		return err
+	}
+
+	scheme := "http"
+	if servesTLS(cr) {
+		scheme = "https"
+	}
+
+	// SonarQube accepts the monitoring passcode as a bearer token on /api/monitoring/metrics
+	endpoint := map[string]interface{}{
+		"port":   "web",
+		"path":   webContext(cr) + "/api/monitoring/metrics",
+		"scheme": scheme,
+		"bearerTokenSecret": map[string]interface{}{
+			"name": passcodeSecret.Name,
+			"key":  sonarsourcev1alpha1.PasscodeSecretPasscode,
+		},
+	}
+	if cr.Spec.Monitoring.Interval != nil {
+		endpoint["interval"] = *cr.Spec.Monitoring.Interval
+	}
+	if servesTLS(cr) {
+		tlsConfig, err := r.getServiceMonitorTLS(cr)
+		if err != nil {
+			return err
+		}
+		endpoint["tlsConfig"] = tlsConfig
+	}
+
+	matchLabels := make(map[string]interface{})
+	for k, v := range r.SelectorLabels(cr) {
+		matchLabels[k] = v
+	}
+
+	spec := map[string]interface{}{
+		"endpoints": []interface{}{endpoint},
+		"selector": map[string]interface{}{
+			"matchLabels": matchLabels,
+		},
+		"namespaceSelector": map[string]interface{}{
+			"matchNames": []interface{}{cr.Namespace},
+		},
+	}
+
+	labels := r.Labels(cr)
+	for k, v := range cr.Spec.Monitoring.Labels {
+		labels[k] = v
+	}
+
+	serviceMonitor := newServiceMonitor()
+	serviceMonitor.SetNamespace(cr.Namespace)
+	serviceMonitor.SetName(cr.Name)
+	serviceMonitor.SetLabels(labels)
+	if err := unstructured.SetNestedField(serviceMonitor.Object, spec, "spec"); err != nil {
+		return err
+	}
+
+	if err := controllerutil.SetControllerReference(cr, serviceMonitor, r.scheme); err != nil {
+		return err
+	}
+
+	err = utils.ApplyResource(r.client, r.scheme, serviceMonitor, newServiceMonitor(), "")
+	if err != nil && meta.IsNoMatchError(err) {
+		log.Info("Install prometheus-operator in your cluster to create ServiceMonitor objects", "error", metrics.ErrServiceMonitorNotPresent.Error(), "SonarQube.Name", cr.Name)
+		return nil
+	}
+	return err
+}
+
+// getServiceMonitorTLS returns the tls config Prometheus verifies pods serving https with,
+// with the CA bundle or insecure skip verify in spec
+func (r *ReconcileSonarQube) getServiceMonitorTLS(cr *sonarsourcev1alpha1.SonarQube) (map[string]interface{}, error) {
+	service := fmt.Sprintf("%s.%s.svc", cr.Name, cr.Namespace)
+	tlsConfig := map[string]interface{}{
+		"serverName": service,
+	}
+
+	// The bundle is read to validate it, Prometheus reads it from the ConfigMap or Secret itself
+	bundle, err := r.getCABundle(cr)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := cr.Spec.TLS
+	key := DefaultCAKey
+	if spec.CAKey != nil {
+		key = *spec.CAKey
+	}
+
+	switch {
+	case spec.InsecureSkipVerify != nil && *spec.InsecureSkipVerify:
+		tlsConfig["insecureSkipVerify"] = true
+	case bundle != nil && spec.CAConfigMap != nil:
+		tlsConfig["ca"] = map[string]interface{}{
+			"configMap": map[string]interface{}{"name": *spec.CAConfigMap, "key": key},
+		}
+	case bundle != nil:
+		tlsConfig["ca"] = map[string]interface{}{
+			"secret": map[string]interface{}{"name": *spec.CASecret, "key": key},
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+func newServiceMonitor() *unstructured.Unstructured {
+	serviceMonitor := &unstructured.Unstructured{}
+	serviceMonitor.SetGroupVersionKind(ServiceMonitorGVK)
+	return serviceMonitor
+}
+
+// monitored checks a ServiceMonitor is requested in spec, search nodes don't serve metrics
+func monitored(cr *sonarsourcev1alpha1.SonarQube) bool {
+	return cr.Spec.Monitoring != nil && cr.Spec.Monitoring.ServiceMonitor != nil && *cr.Spec.Monitoring.ServiceMonitor &&
+		nodeType(cr) != sonarsourcev1alpha1.Search
+}
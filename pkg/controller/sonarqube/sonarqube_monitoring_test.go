@@ -0,0 +1,132 @@
+package sonarqube
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jlfowle/sonarqube-operator/pkg/api_client"
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+// TestSonarQubeMonitoring runs ReconcileSonarQube.ReconcileMonitoring() against a
+// fake client
+func TestSonarQubeMonitoring(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name           = "sonarqube-operator"
+		namespace      = "sonarqube"
+		namespacedName = types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		}
+	)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer server.Close()
+	bundle := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+
+	// A SonarQube resource with metadata and spec.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{
+			Monitoring: &sonarsourcev1alpha1.Monitoring{
+				ServiceMonitor: &[]bool{true}[0],
+				Interval:       &[]string{"30s"}[0],
+				Labels:         map[string]string{"prometheus": "k8s"},
+			},
+			TLS: &sonarsourcev1alpha1.TLS{
+				Secret:      &[]string{"sonarqube-tls"}[0],
+				CAConfigMap: &[]string{"ca"}[0],
+			},
+		},
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ca",
+			Namespace: namespace,
+		},
+		Data: map[string]string{
+			DefaultCAKey: bundle,
+		},
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+		configMap,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
+	// Create a fake client to mock API calls.
+	cl := newFakeClient(s, objs...)
+	// Create a ReconcileSonarQube object with the scheme and fake client.
+	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: &api_client.APIClientMock{}}
+
+	// The passcode secret is generated before the ServiceMonitor references it
+	err := r.ReconcileMonitoring(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceCreate {
+		t.Errorf("ReconcileMonitoring: resource created error not thrown when creating passcode secret (%v)", err)
+	}
+	err = r.ReconcileMonitoring(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceCreate {
+		t.Errorf("ReconcileMonitoring: resource created error not thrown when creating ServiceMonitor (%v)", err)
+	}
+
+	serviceMonitor := newServiceMonitor()
+	err = r.client.Get(context.TODO(), namespacedName, serviceMonitor)
+	if err != nil {
+		t.Fatalf("ReconcileMonitoring: (%v)", err)
+	}
+	if serviceMonitor.GetLabels()["prometheus"] != "k8s" {
+		t.Error("ReconcileMonitoring: service monitor labels not set from spec")
+	}
+	endpoints, _, _ := unstructured.NestedSlice(serviceMonitor.Object, "spec", "endpoints")
+	if len(endpoints) != 1 {
+		t.Fatalf("ReconcileMonitoring: unexpected endpoints %v", endpoints)
+	}
+	endpoint := endpoints[0].(map[string]interface{})
+	if endpoint["path"] != "/api/monitoring/metrics" || endpoint["scheme"] != "https" || endpoint["interval"] != "30s" {
+		t.Errorf("ReconcileMonitoring: unexpected endpoint %v", endpoint)
+	}
+	if secret, _, _ := unstructured.NestedString(endpoint, "bearerTokenSecret", "name"); secret != name+"-passcode" {
+		t.Error("ReconcileMonitoring: passcode secret not set as bearer token")
+	}
+	if ca, _, _ := unstructured.NestedString(endpoint, "tlsConfig", "ca", "configMap", "name"); ca != "ca" {
+		t.Error("ReconcileMonitoring: CA bundle not set in tls config")
+	}
+	if serverName, _, _ := unstructured.NestedString(endpoint, "tlsConfig", "serverName"); serverName != "sonarqube-operator.sonarqube.svc" {
+		t.Errorf("ReconcileMonitoring: unexpected server name %s", serverName)
+	}
+
+	sonarqube.Spec.Monitoring.ServiceMonitor = &[]bool{false}[0]
+	err = r.ReconcileMonitoring(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceUpdate {
+		t.Errorf("ReconcileMonitoring: resource update error not thrown when removing ServiceMonitor (%v)", err)
+	}
+	err = r.client.Get(context.TODO(), namespacedName, newServiceMonitor())
+	if err == nil || !errors.IsNotFound(err) {
+		t.Errorf("ReconcileMonitoring: ServiceMonitor not removed (%v)", err)
+	}
+	err = r.ReconcileMonitoring(sonarqube)
+	if err != nil {
+		t.Errorf("ReconcileMonitoring: returned error when not monitored (%v)", err)
+	}
+}
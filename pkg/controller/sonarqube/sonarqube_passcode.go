@@ -0,0 +1,109 @@
+package sonarqube
+
+import (
+	"context"
+	"fmt"
+
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// PasscodeLength is the length of generated monitoring passcodes
+	PasscodeLength = 32
+)
+
+// Reconciles the monitoring passcode Secret for SonarQube, set as sonar.web.systemPasscode
+// A passcode is generated when no passcode secret is set in spec
+// Returns: Secret, Error
+// If Error is non-nil, Secret is not in expected state
+// Errors:
+//   ErrorReasonSpecInvalid: returned when the passcode secret in spec doesn't exist or is missing the passcode
+//   ErrorReasonResourceCreate: returned when Secret does not exists
+//   ErrorReasonResourceUpdate: returned when Secret was updated to meet expected state or annotated to be watched
+//   ErrorReasonUnknown: returned when unhandled error from client occurs
+func (r *ReconcileSonarQube) ReconcilePasscode(cr *sonarsourcev1alpha1.SonarQube) (*corev1.Secret, error) {
+	if cr.Spec.Probes != nil && cr.Spec.Probes.PasscodeSecret != nil {
+		return r.getPasscodeSecret(cr)
+	}
+
+	newSecret, err := r.newPasscodeSecret(cr)
+	if err != nil {
+		return newSecret, err
+	}
+
+	foundSecret := &corev1.Secret{}
+	if err := utils.ApplyResource(r.client, r.scheme, newSecret, foundSecret, ""); err != nil {
+		return foundSecret, err
+	}
+
+	if len(foundSecret.Data[sonarsourcev1alpha1.PasscodeSecretPasscode]) == 0 {
+		return foundSecret, &utils.Error{
+			Reason:  utils.ErrorReasonResourceInvalid,
+			Message: fmt.Sprintf("passcode secret %s must contain %s", foundSecret.Name, sonarsourcev1alpha1.PasscodeSecretPasscode),
+		}
+	}
+
+	return foundSecret, nil
+}
+
+// getPasscodeSecret returns the passcode secret in spec, it is used as it is
+func (r *ReconcileSonarQube) getPasscodeSecret(cr *sonarsourcev1alpha1.SonarQube) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: *cr.Spec.Probes.PasscodeSecret, Namespace: cr.Namespace}, secret)
+	if err != nil && errors.IsNotFound(err) {
+		return secret, &utils.Error{
+			Reason:  utils.ErrorReasonSpecInvalid,
+			Message: fmt.Sprintf("passcode secret %s doesn't exist", *cr.Spec.Probes.PasscodeSecret),
+		}
+	} else if err != nil {
+		return secret, err
+	}
+
+	if !utils.IsOwner(cr, secret) {
+		if err := r.watchSecret(cr, secret, sonarsourcev1alpha1.SecretAnnotation); err != nil {
+			return secret, err
+		}
+	}
+
+	if len(secret.Data[sonarsourcev1alpha1.PasscodeSecretPasscode]) == 0 {
+		return secret, &utils.Error{
+			Reason:  utils.ErrorReasonSpecInvalid,
+			Message: fmt.Sprintf("passcode secret %s must contain %s", secret.Name, sonarsourcev1alpha1.PasscodeSecretPasscode),
+		}
+	}
+
+	return secret, nil
+}
+
+// newPasscodeSecret returns the secret holding the generated monitoring passcode.
+// Data is only set when the secret is created
+func (r *ReconcileSonarQube) newPasscodeSecret(cr *sonarsourcev1alpha1.SonarQube) (*corev1.Secret, error) {
+	passcode, err := utils.GenPassword(PasscodeLength)
+	if err != nil {
+		return nil, err
+	}
+
+	dep := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: cr.Namespace,
+			Name:      fmt.Sprintf("%s-passcode", cr.Name),
+			Labels:    r.PodLabels(cr),
+		},
+		Data: map[string][]byte{
+			sonarsourcev1alpha1.PasscodeSecretPasscode: []byte(passcode),
+		},
+		Type: corev1.SecretTypeOpaque,
+	}
+
+	if err := controllerutil.SetControllerReference(cr, dep, r.scheme); err != nil {
+		return dep, err
+	}
+
+	return dep, nil
+}
@@ -0,0 +1,203 @@
+package sonarqube
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/jlfowle/sonarqube-operator/pkg/api_client"
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	"github.com/operator-framework/operator-sdk/pkg/status"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	VolumePathPlugins string = VolumePathExtensions + "/plugins"
+)
+
+// pluginsScript installs the plugins listed in $PLUGINS, one "<file> <url> <key> <version>" line per plugin.
+// Jars installed by a previous run are tracked in a manifest so jars no longer listed are removed
+// while jars added by other means are left in place. Plugins without url are resolved from the update center
+const pluginsScript = `set -e
+dir="$PLUGINS_DIR"
+manifest="$dir/.operator-plugins"
+mkdir -p "$dir"
+touch "$manifest"
+while read -r file; do
+  [ -n "$file" ] || continue
+  if ! printf '%s\n' "$PLUGINS" | cut -d' ' -f1 | grep -qxF "$file"; then
+    echo "removing plugin $file"
+    rm -f "$dir/$file"
+  fi
+done < "$manifest"
+: > "$manifest.new"
+printf '%s\n' "$PLUGINS" | while read -r file url key version; do
+  [ -n "$file" ] || continue
+  if [ ! -f "$dir/$file" ]; then
+    if [ "$url" = "-" ]; then
+      if [ ! -f /tmp/update-center.properties ]; then
+        case "$UPDATE_CENTER_URL" in
+          file://*) cp "${UPDATE_CENTER_URL#file://}" /tmp/update-center.properties ;;
+          *) wget -q -O /tmp/update-center.properties "$UPDATE_CENTER_URL" ;;
+        esac
+      fi
+      url=$(awk -v k="$key.$version.downloadUrl" 'index($0, k "=") == 1 { print substr($0, length(k) + 2) }' /tmp/update-center.properties | sed 's/\\:/:/g')
+      if [ -z "$url" ]; then
+        echo "plugin $key $version not found in update center"
+        exit 1
+      fi
+    fi
+    echo "installing plugin $file"
+    wget -q -O "$dir/$file.download" "$url"
+    mv "$dir/$file.download" "$dir/$file"
+  fi
+  echo "$file" >> "$manifest.new"
+done
+mv "$manifest.new" "$manifest"
+`
+
+var pluginFileRegexp = regexp.MustCompile(`^[A-Za-z0-9._-]+\.jar$`)
+
+// newPluginsContainer returns the init container installing the plugins in spec on the extensions volume.
+// The container runs even when no plugins are listed so previously installed plugins are removed
+func newPluginsContainer(cr *sonarsourcev1alpha1.SonarQube, image, updateCenterURL string, extensions corev1.VolumeMount) (*corev1.Container, error) {
+	var plugins []string
+	for _, plugin := range cr.Spec.Plugins {
+		file, err := pluginFile(plugin)
+		if err != nil {
+			return nil, err
+		}
+		source, version := "-", "-"
+		if plugin.URL != nil {
+			source = *plugin.URL
+		}
+		if plugin.Version != nil {
+			version = *plugin.Version
+		}
+		plugins = append(plugins, strings.Join([]string{file, source, plugin.Key, version}, " "))
+	}
+
+	return &corev1.Container{
+		Name:    "plugins",
+		Image:   image,
+		Command: []string{"sh", "-c", pluginsScript},
+		Env: []corev1.EnvVar{
+			{
+				Name:  "PLUGINS",
+				Value: strings.Join(plugins, "\n"),
+			},
+			{
+				Name:  "PLUGINS_DIR",
+				Value: VolumePathPlugins,
+			},
+			{
+				Name:  "UPDATE_CENTER_URL",
+				Value: updateCenterURL,
+			},
+		},
+		VolumeMounts:    []corev1.VolumeMount{extensions},
+		ImagePullPolicy: imagePullPolicy(cr),
+	}, nil
+}
+
+// pluginFile returns the name of the jar a plugin is installed as, the file name of the url
+// or <key>-<version>.jar for plugins resolved from the update center
+func pluginFile(plugin sonarsourcev1alpha1.Plugin) (string, error) {
+	if plugin.URL == nil && plugin.Version == nil {
+		return "", &utils.Error{
+			Reason:  utils.ErrorReasonSpecInvalid,
+			Message: fmt.Sprintf("plugin %s must have a version or url", plugin.Key),
+		}
+	}
+
+	var file string
+	if plugin.URL == nil {
+		file = fmt.Sprintf("%s-%s.jar", plugin.Key, *plugin.Version)
+	} else {
+		u, err := url.Parse(*plugin.URL)
+		if err != nil || u.Scheme == "" || u.Host == "" || strings.ContainsAny(*plugin.URL, " \t\n") {
+			return "", &utils.Error{
+				Reason:  utils.ErrorReasonSpecInvalid,
+				Message: fmt.Sprintf("plugin %s url %s is invalid", plugin.Key, *plugin.URL),
+			}
+		}
+		file = path.Base(u.Path)
+	}
+
+	if !pluginFileRegexp.MatchString(file) {
+		return "", &utils.Error{
+			Reason:  utils.ErrorReasonSpecInvalid,
+			Message: fmt.Sprintf("plugin %s must be a jar file, got %s", plugin.Key, file),
+		}
+	}
+
+	return file, nil
+}
+
+// Reconciles plugins reported by SonarQube with the plugins in spec
+// Returns: Error
+// A mismatch is reported with the PluginsMismatched condition and doesn't return an error
+// Errors:
+//   ErrorReasonServerAuth: returned when the credentials can't read the installed plugins
+//   ErrorReasonUnknown: returned when unhandled error from client occurs
+func (r *ReconcileSonarQube) verifyPlugins(cr *sonarsourcev1alpha1.SonarQube, apiClient api_client.APIReader) error {
+	if len(cr.Spec.Plugins) == 0 {
+		if cr.Status.Conditions.IsTrueFor(sonarsourcev1alpha1.ConditionPluginsMismatched) {
+			newStatus := cr.DeepCopy()
+			newStatus.Status.Conditions.SetCondition(status.Condition{
+				Type:   sonarsourcev1alpha1.ConditionPluginsMismatched,
+				Status: corev1.ConditionFalse,
+				Reason: sonarsourcev1alpha1.ConditionPluginsInstalled,
+			})
+			utils.UpdateStatus(r.client, newStatus, cr)
+		}
+		return nil
+	}
+
+	installed, err := apiClient.InstalledPlugins()
+	if err != nil {
+		return parseAPIError(err)
+	} else if installed == nil {
+		return fmt.Errorf("nil returned for installed plugins")
+	}
+
+	reported := make(map[string]api_client.InstalledPlugin)
+	for _, plugin := range installed.Plugins {
+		reported[plugin.Key] = plugin
+	}
+
+	var mismatched []string
+	for _, plugin := range cr.Spec.Plugins {
+		file, err := pluginFile(plugin)
+		if err != nil {
+			return err
+		}
+		found, ok := reported[plugin.Key]
+		if !ok {
+			mismatched = append(mismatched, fmt.Sprintf("%s not installed", plugin.Key))
+		} else if found.Filename != file {
+			mismatched = append(mismatched, fmt.Sprintf("%s installed from %s", plugin.Key, found.Filename))
+		}
+	}
+
+	condition := status.Condition{
+		Type:    sonarsourcev1alpha1.ConditionPluginsMismatched,
+		Status:  corev1.ConditionFalse,
+		Reason:  sonarsourcev1alpha1.ConditionPluginsInstalled,
+		Message: fmt.Sprintf("%d plugins installed", len(cr.Spec.Plugins)),
+	}
+	if len(mismatched) > 0 {
+		condition.Status = corev1.ConditionTrue
+		condition.Reason = sonarsourcev1alpha1.ConditionPluginsMissing
+		condition.Message = strings.Join(mismatched, ", ")
+	}
+
+	newStatus := cr.DeepCopy()
+	newStatus.Status.Conditions.SetCondition(condition)
+	utils.UpdateStatus(r.client, newStatus, cr)
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package sonarqube
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jlfowle/sonarqube-operator/pkg/api_client"
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+// TestSonarQubePlugins runs ReconcileSonarQube.verifyPlugins() against a
+// fake client
+func TestSonarQubePlugins(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name           = "sonarqube-operator"
+		namespace      = "sonarqube"
+		namespacedName = types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		}
+	)
+
+	// A SonarQube resource with metadata and spec.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{
+			Plugins: []sonarsourcev1alpha1.Plugin{
+				{
+					Key:     "java",
+					Version: &[]string{"6.5.0.22421"}[0],
+				},
+				{
+					Key: "checkstyle",
+					URL: &[]string{"https://github.com/checkstyle/sonar-checkstyle/releases/download/4.33/checkstyle-sonar-plugin-4.33.jar"}[0],
+				},
+			},
+		},
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
+	// Create a fake client to mock API calls.
+	cl := newFakeClient(s, objs...)
+	// Create a ReconcileSonarQube object with the scheme and fake client.
+	apiMock := &api_client.APIClientMock{
+		InstalledPluginsOutput: &api_client.InstalledPlugins{
+			Plugins: []api_client.InstalledPlugin{
+				{
+					Key:      "java",
+					Version:  "6.4 (build 21967)",
+					Filename: "java-6.4.0.21967.jar",
+				},
+			},
+		},
+	}
+	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: apiMock}
+
+	container, err := newPluginsContainer(sonarqube, "sonarqube", DefaultUpdateCenterURL, volumeMount(podVolumes(sonarqube, nil), VolumeExtensions, VolumePathExtensions))
+	if err != nil {
+		t.Fatalf("newPluginsContainer: (%v)", err)
+	}
+	expected := strings.Join([]string{
+		"java-6.5.0.22421.jar - java 6.5.0.22421",
+		"checkstyle-sonar-plugin-4.33.jar https://github.com/checkstyle/sonar-checkstyle/releases/download/4.33/checkstyle-sonar-plugin-4.33.jar checkstyle -",
+	}, "\n")
+	if container.Env[0].Name != "PLUGINS" || container.Env[0].Value != expected {
+		t.Errorf("newPluginsContainer: unexpected plugins %s", container.Env[0].Value)
+	}
+
+	err = r.verifyPlugins(sonarqube, apiMock)
+	if err != nil {
+		t.Errorf("verifyPlugins: returned error on mismatch (%v)", err)
+	}
+	err = r.client.Get(context.TODO(), namespacedName, sonarqube)
+	if err != nil {
+		t.Fatalf("verifyPlugins: (%v)", err)
+	}
+	condition := sonarqube.Status.Conditions.GetCondition(sonarsourcev1alpha1.ConditionPluginsMismatched)
+	if condition == nil || !condition.IsTrue() || condition.Message != "java installed from java-6.4.0.21967.jar, checkstyle not installed" {
+		t.Errorf("verifyPlugins: mismatch not reported %v", condition)
+	}
+
+	apiMock.InstalledPluginsOutput.Plugins = []api_client.InstalledPlugin{
+		{Key: "java", Filename: "java-6.5.0.22421.jar"},
+		{Key: "checkstyle", Filename: "checkstyle-sonar-plugin-4.33.jar"},
+	}
+	err = r.verifyPlugins(sonarqube, apiMock)
+	if err != nil {
+		t.Errorf("verifyPlugins: returned error even though plugins are installed (%v)", err)
+	}
+	if !sonarqube.Status.Conditions.IsFalseFor(sonarsourcev1alpha1.ConditionPluginsMismatched) {
+		t.Error("verifyPlugins: mismatch not cleared when plugins are installed")
+	}
+
+	// Plugins need a version or url
+	sonarqube.Spec.Plugins = append(sonarqube.Spec.Plugins, sonarsourcev1alpha1.Plugin{Key: "python"})
+	err = r.verifyPlugins(sonarqube, apiMock)
+	if utils.ReasonForError(err) != utils.ErrorReasonSpecInvalid {
+		t.Error("verifyPlugins: spec invalid error not thrown when plugin has no version or url")
+	}
+
+	_, err = pluginFile(sonarsourcev1alpha1.Plugin{Key: "checkstyle", URL: &[]string{"https://example.com/download?id=1"}[0]})
+	if utils.ReasonForError(err) != utils.ErrorReasonSpecInvalid {
+		t.Error("pluginFile: spec invalid error not thrown when url is not a jar")
+	}
+}
@@ -0,0 +1,128 @@
+package sonarqube
+
+import (
+	"fmt"
+
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	// PasscodeHeader is the header SonarQube reads the monitoring passcode from
+	PasscodeHeader string = "X-Sonar-Passcode"
+)
+
+// livenessScript calls /api/system/liveness on localhost with the monitoring passcode, with curl or
+// with wget when the image has no curl
+const livenessScript = `url=%[2]s
+if command -v curl >/dev/null; then
+  curl -fs -o /dev/null -H "%[1]s: $SONAR_WEB_SYSTEMPASSCODE" "$url"
+else
+  wget -q -O /dev/null --header "%[1]s: $SONAR_WEB_SYSTEMPASSCODE" "$url"
+fi
+`
+
+// setProbes sets the liveness, readiness and startup probes of the SonarQube container of nodeType.
+// Web nodes are probed over http on the web port or https on the TLS proxy, search nodes over tcp on the search port.
+// Web nodes read the monitoring passcode from the passcode secret, the liveness endpoint is checked with it from the container
+// Errors:
+//   ErrorReasonSpecInvalid: returned when the passcode secret in spec is invalid, see ReconcilePasscode
+//   ErrorReasonResourceCreate: returned when the passcode secret does not exists
+//   ErrorReasonResourceUpdate: returned when the passcode secret was updated or annotated to be watched
+//   ErrorReasonUnknown: returned when unhandled error from client occurs
+func (r *ReconcileSonarQube) setProbes(cr *sonarsourcev1alpha1.SonarQube, nodeType sonarsourcev1alpha1.ServerType, container *corev1.Container) error {
+	spec := cr.Spec.Probes
+	if spec == nil {
+		spec = &sonarsourcev1alpha1.Probes{}
+	}
+
+	if nodeType == sonarsourcev1alpha1.Search {
+		port := tcpProbe(sonarsourcev1alpha1.SearchPort)
+		container.LivenessProbe = newProbe(port, spec.Liveness, 60, 5, 10, 3)
+		container.ReadinessProbe = newProbe(port, spec.Readiness, 0, 5, 10, 3)
+		container.StartupProbe = newProbe(port, spec.Startup, 0, 5, 10, 60)
+		return nil
+	}
+
+	scheme := corev1.URISchemeHTTP
+	if servesTLS(cr) {
+		scheme = corev1.URISchemeHTTPS
+	}
+	status := corev1.Handler{
+		HTTPGet: &corev1.HTTPGetAction{
+			Path:   webContext(cr) + "/api/system/status",
+			Port:   intstr.FromInt(int(webPort(cr))),
+			Scheme: scheme,
+		},
+	}
+
+	passcodeSecret, err := r.ReconcilePasscode(cr)
+	if err != nil {
+		return err
+	}
+
+	liveness := tcpProbe(webPort(cr))
+	if spec.LivenessEndpoint != nil && *spec.LivenessEndpoint {
+		livenessURL := fmt.Sprintf("http://127.0.0.1:%d%s/api/system/liveness", sonarsourcev1alpha1.ApplicationWebPort, webContext(cr))
+		// The probe reads the passcode from the environment of the container so it never shows in the pod spec
+		liveness = corev1.Handler{
+			Exec: &corev1.ExecAction{
+				Command: []string{"/bin/sh", "-c", fmt.Sprintf(livenessScript, PasscodeHeader, livenessURL)},
+			},
+		}
+	}
+
+	container.LivenessProbe = newProbe(liveness, spec.Liveness, 60, 5, 10, 3)
+	container.ReadinessProbe = newProbe(status, spec.Readiness, 0, 5, 10, 3)
+	container.StartupProbe = newProbe(status, spec.Startup, 0, 5, 10, 60)
+
+	container.Env = append(container.Env, corev1.EnvVar{
+		Name: "SONAR_WEB_SYSTEMPASSCODE",
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: passcodeSecret.Name},
+				Key:                  sonarsourcev1alpha1.PasscodeSecretPasscode,
+			},
+		},
+	})
+
+	return nil
+}
+
+// newProbe returns a probe running handler with the timings in spec, unset timings take the given defaults
+func newProbe(handler corev1.Handler, timings *sonarsourcev1alpha1.ProbeTimings, initialDelay, timeout, period, failureThreshold int32) *corev1.Probe {
+	probe := &corev1.Probe{
+		Handler:             handler,
+		InitialDelaySeconds: initialDelay,
+		TimeoutSeconds:      timeout,
+		PeriodSeconds:       period,
+		SuccessThreshold:    1,
+		FailureThreshold:    failureThreshold,
+	}
+
+	if timings == nil {
+		return probe
+	}
+	if timings.InitialDelaySeconds != nil {
+		probe.InitialDelaySeconds = *timings.InitialDelaySeconds
+	}
+	if timings.TimeoutSeconds != nil {
+		probe.TimeoutSeconds = *timings.TimeoutSeconds
+	}
+	if timings.PeriodSeconds != nil {
+		probe.PeriodSeconds = *timings.PeriodSeconds
+	}
+	if timings.FailureThreshold != nil {
+		probe.FailureThreshold = *timings.FailureThreshold
+	}
+	return probe
+}
+
+func tcpProbe(port int32) corev1.Handler {
+	return corev1.Handler{
+		TCPSocket: &corev1.TCPSocketAction{
+			Port: intstr.FromInt(int(port)),
+		},
+	}
+}
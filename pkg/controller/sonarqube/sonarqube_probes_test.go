@@ -0,0 +1,229 @@
+package sonarqube
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/jlfowle/sonarqube-operator/pkg/api_client"
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+// TestSonarQubeProbes runs ReconcileSonarQube.setProbes() against a
+// fake client with probes in spec
+func TestSonarQubeProbes(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name      = "sonarqube-operator"
+		namespace = "sonarqube"
+	)
+
+	// A SonarQube resource with metadata and spec.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "passcode",
+			Namespace: namespace,
+		},
+		Data: map[string][]byte{
+			sonarsourcev1alpha1.PasscodeSecretPasscode: []byte("monitoring"),
+		},
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+		secret,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
+	// Create a fake client to mock API calls.
+	cl := newFakeClient(s, objs...)
+	// Create a ReconcileSonarQube object with the scheme and fake client.
+	apiMock := &api_client.APIClientMock{}
+	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: apiMock}
+
+	// A passcode is generated when none is set in spec
+	err := r.setProbes(sonarqube, sonarsourcev1alpha1.AIO, &corev1.Container{})
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceCreate {
+		t.Errorf("setProbes: resource create error not thrown when generating passcode (%v)", err)
+	}
+
+	// Defaults keep the tcp liveness check and hold it back with a startup probe
+	container := &corev1.Container{}
+	if err := r.setProbes(sonarqube, sonarsourcev1alpha1.AIO, container); err != nil {
+		t.Fatalf("setProbes: (%v)", err)
+	}
+	if container.LivenessProbe.TCPSocket == nil || container.LivenessProbe.InitialDelaySeconds != 60 {
+		t.Error("setProbes: liveness probe isn't the default tcp check")
+	}
+	if container.ReadinessProbe.HTTPGet == nil || container.ReadinessProbe.HTTPGet.Path != "/api/system/status" {
+		t.Error("setProbes: readiness probe doesn't check /api/system/status")
+	}
+	if container.StartupProbe == nil || container.StartupProbe.FailureThreshold != 60 {
+		t.Error("setProbes: startup probe not set")
+	}
+	if container.LivenessProbe.TimeoutSeconds != 5 || container.ReadinessProbe.TimeoutSeconds != 5 {
+		t.Error("setProbes: liveness and readiness probes don't default to a 5s timeout")
+	}
+
+	if len(container.Env) != 1 || container.Env[0].ValueFrom.SecretKeyRef.Name != name+"-passcode" {
+		t.Errorf("setProbes: generated passcode not set for sonar.web.systemPasscode (%v)", container.Env)
+	}
+
+	sonarqube.Spec.Probes = &sonarsourcev1alpha1.Probes{
+		LivenessEndpoint: &[]bool{true}[0],
+		PasscodeSecret:   &[]string{"missing"}[0],
+	}
+	err = r.setProbes(sonarqube, sonarsourcev1alpha1.AIO, &corev1.Container{})
+	if utils.ReasonForError(err) != utils.ErrorReasonSpecInvalid {
+		t.Errorf("setProbes: spec invalid error not thrown for missing passcode secret (%v)", err)
+	}
+
+	sonarqube.Spec.Probes = &sonarsourcev1alpha1.Probes{
+		LivenessEndpoint: &[]bool{true}[0],
+		PasscodeSecret:   &secret.Name,
+		Readiness: &sonarsourcev1alpha1.ProbeTimings{
+			TimeoutSeconds: &[]int32{15}[0],
+		},
+		Startup: &sonarsourcev1alpha1.ProbeTimings{
+			PeriodSeconds:    &[]int32{20}[0],
+			FailureThreshold: &[]int32{90}[0],
+		},
+	}
+	// Referenced secrets are annotated to be watched before they are used
+	err = r.setProbes(sonarqube, sonarsourcev1alpha1.AIO, &corev1.Container{})
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceUpdate {
+		t.Errorf("setProbes: resource update error not thrown when watching passcode secret (%v)", err)
+	}
+
+	container = &corev1.Container{}
+	if err := r.setProbes(sonarqube, sonarsourcev1alpha1.AIO, container); err != nil {
+		t.Fatalf("setProbes: (%v)", err)
+	}
+	liveness := container.LivenessProbe.Exec
+	if liveness == nil || len(liveness.Command) != 3 || !strings.Contains(liveness.Command[2], "/api/system/liveness") || !strings.Contains(liveness.Command[2], "$SONAR_WEB_SYSTEMPASSCODE") {
+		t.Errorf("setProbes: liveness probe doesn't check /api/system/liveness with the passcode (%v)", liveness)
+	}
+	if probe, _ := json.Marshal(container.LivenessProbe); strings.Contains(string(probe), "monitoring") {
+		t.Error("setProbes: passcode set in plain text in the liveness probe")
+	}
+	if container.ReadinessProbe.TimeoutSeconds != 15 || container.ReadinessProbe.PeriodSeconds != 10 {
+		t.Error("setProbes: readiness timings not applied over defaults")
+	}
+	if container.StartupProbe.PeriodSeconds != 20 || container.StartupProbe.FailureThreshold != 90 {
+		t.Error("setProbes: startup timings not applied")
+	}
+	var passcode bool
+	for _, env := range container.Env {
+		if env.Name == "SONAR_WEB_SYSTEMPASSCODE" && env.ValueFrom.SecretKeyRef.Name == secret.Name {
+			passcode = true
+		}
+	}
+	if !passcode {
+		t.Error("setProbes: passcode not set for sonar.web.systemPasscode")
+	}
+
+	// Search nodes have no web port and keep tcp checks
+	container = &corev1.Container{}
+	if err := r.setProbes(sonarqube, sonarsourcev1alpha1.Search, container); err != nil {
+		t.Fatalf("setProbes: (%v)", err)
+	}
+	if container.LivenessProbe.TCPSocket == nil || container.ReadinessProbe.TCPSocket == nil || container.StartupProbe.TCPSocket == nil {
+		t.Error("setProbes: search node probes aren't tcp checks")
+	}
+}
+
+// TestSonarQubeProbesDrift runs ReconcileSonarQube.ReconcileDeployment() against a
+// fake client with probe timings changed in spec
+func TestSonarQubeProbesDrift(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name      = "sonarqube-operator"
+		namespace = "sonarqube"
+	)
+
+	// A SonarQube resource with metadata and spec.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{},
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
+	// Create a fake client to mock API calls.
+	cl := newFakeClient(s, objs...)
+	// Create a ReconcileSonarQube object with the scheme and fake client.
+	apiMock := &api_client.APIClientMock{}
+	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: apiMock}
+
+	// Take care of dependencies and deployment, if there is an unkown error here there is not much to do
+	for {
+		_, err := r.ReconcileDeployment(sonarqube)
+		if err != nil && utils.ReasonForError(err) == utils.ErrorReasonUnknown {
+			t.Fatalf("reconcileDeployment: (%v)", err)
+		} else if err == nil {
+			break
+		}
+	}
+
+	sonarqube.Spec.Probes = &sonarsourcev1alpha1.Probes{
+		Liveness: &sonarsourcev1alpha1.ProbeTimings{
+			InitialDelaySeconds: &[]int32{300}[0],
+		},
+		Startup: &sonarsourcev1alpha1.ProbeTimings{
+			FailureThreshold: &[]int32{120}[0],
+		},
+	}
+	err := r.client.Update(context.TODO(), sonarqube)
+	if err != nil {
+		t.Fatalf("reconcileDeployment: (%v)", err)
+	}
+
+	// The dependencies are updated with the new revision before the deployment
+	var message string
+	for i := 0; i < 5; i++ {
+		_, err = r.ReconcileDeployment(sonarqube)
+		if err == nil || utils.ReasonForError(err) == utils.ErrorReasonUnknown {
+			t.Fatalf("reconcileDeployment: deployment not updated after probes changed (%v)", err)
+		}
+		if strings.HasPrefix(err.(*utils.Error).Message, "updated deployment") {
+			message = err.(*utils.Error).Message
+			break
+		}
+	}
+	for _, field := range []string{"liveness probe", "startup probe"} {
+		if !strings.Contains(message, field) {
+			t.Errorf("reconcileDeployment: %s not in update message %q", field, message)
+		}
+	}
+	if strings.Contains(message, "readiness probe") {
+		t.Errorf("reconcileDeployment: unchanged readiness probe in update message %q", message)
+	}
+}
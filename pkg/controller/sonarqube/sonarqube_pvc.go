@@ -1,11 +1,17 @@
 package sonarqube
 
 import (
+	"context"
+	"fmt"
+
 	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
 	"github.com/jlfowle/sonarqube-operator/pkg/utils"
 	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
@@ -13,76 +19,367 @@ import (
 // Returns: map[Volume]*PersistentVolumeClaim, Error
 // If Error is non-nil, map[Volume]*PersistentVolumeClaim is not in expected state
 // Errors:
+//   ErrorReasonSpecInvalid: returned when the storage class changed, the storage size shrank or grew while
+//                           the storage class doesn't allow volume expansion
 //   ErrorReasonResourceCreate: returned when any PersistentVolumeClaim does not exists
 //   ErrorReasonResourceUpdate: returned when any PersistentVolumeClaim was updated to meet expected state
+//   ErrorReasonResourceWaiting: returned when the existing PersistentVolumeClaim of a volume does not exists
 //   ErrorReasonUnknown: returned when unhandled error from client occurs
-func (r *ReconcileSonarQube) ReconcilePVC(cr *sonarsourcev1alpha1.SonarQube) (*corev1.PersistentVolumeClaim, error) {
-	pvc, err := r.findPVC(cr)
-	if err != nil {
-		return pvc, err
+func (r *ReconcileSonarQube) ReconcilePVC(cr *sonarsourcev1alpha1.SonarQube) (map[Volume]*corev1.PersistentVolumeClaim, error) {
+	pvcs := make(map[Volume]*corev1.PersistentVolumeClaim)
+
+	var err error
+	for _, volume := range allVolumes {
+		var pvc *corev1.PersistentVolumeClaim
+		pvc, err = r.findVolumePVC(cr, volume)
+		if pvc != nil {
+			pvcs[volume] = pvc
+		}
+		if err != nil {
+			break
+		}
 	}
 
+	// A storage claim no volume is kept in is only mounted while volumes are copied from it
+	if !usesStorage(cr) && len(migratedVolumes(podVolumes(cr, pvcs))) == 0 {
+		delete(pvcs, VolumeStorage)
+	}
+
+	data := dataVolume(cr)
 	newStatus := cr.DeepCopy()
+	newStatus.Status.Storage = sonarsourcev1alpha1.StorageStatus{}
+	if managedVolume(cr, data) {
+		newStatus.Status.Storage.Requested = storageSize(cr, data)
+	}
+	if pvc, ok := pvcs[data]; ok {
+		newStatus.Status.Storage.Claim = pvc.Name
+		if data == VolumeStorage {
+			newStatus.Status.Storage.SubPath = string(VolumeData)
+		}
+		if requested, ok := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; ok && !managedVolume(cr, data) {
+			newStatus.Status.Storage.Requested = requested.String()
+		}
+		if capacity, ok := pvc.Status.Capacity[corev1.ResourceStorage]; ok {
+			newStatus.Status.Storage.Capacity = capacity.String()
+		}
+	}
 
 	utils.UpdateStatus(r.client, newStatus, cr)
+	return pvcs, err
+}
+
+// findVolumePVC returns the PersistentVolumeClaim volume is kept in, nil when volume is not kept in a claim of its own
+func (r *ReconcileSonarQube) findVolumePVC(cr *sonarsourcev1alpha1.SonarQube, volume Volume) (*corev1.PersistentVolumeClaim, error) {
+	source := volumeSource(cr, volume)
+
+	switch {
+	case volume == VolumeStorage && !usesStorage(cr):
+		// The storage claim is left as it is, it only holds volumes that moved out of it
+		return r.getPVC(cr.Namespace, pvcName(cr, volume))
+	case volume == VolumeStorage:
+		return r.findPVC(cr, volume)
+	case source == nil || source.EmptyDir != nil:
+		return nil, nil
+	case source.ClaimName != nil:
+		pvc, err := r.getPVC(cr.Namespace, *source.ClaimName)
+		if err == nil && pvc == nil {
+			return nil, &utils.Error{
+				Reason:  utils.ErrorReasonResourceWaiting,
+				Message: fmt.Sprintf("waiting for persistentvolumeclaim %s of volume %s", *source.ClaimName, volume),
+			}
+		}
+		return pvc, err
+	default:
+		return r.findPVC(cr, volume)
+	}
+}
+
+// getPVC returns the PersistentVolumeClaim name, nil when it doesn't exist or is being removed
+func (r *ReconcileSonarQube) getPVC(namespace, name string) (*corev1.PersistentVolumeClaim, error) {
+	pvc := &corev1.PersistentVolumeClaim{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, pvc)
+	if err != nil && errors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if pvc.DeletionTimestamp != nil {
+		return nil, nil
+	}
+
 	return pvc, nil
 }
 
-func (r *ReconcileSonarQube) findPVC(cr *sonarsourcev1alpha1.SonarQube) (*corev1.PersistentVolumeClaim, error) {
-	newPVC, err := r.newPVC(cr)
+func (r *ReconcileSonarQube) findPVC(cr *sonarsourcev1alpha1.SonarQube, volume Volume) (*corev1.PersistentVolumeClaim, error) {
+	newPVC, err := r.newPVC(cr, volume)
 	if err != nil {
 		return newPVC, err
 	}
 
+	// Snapshots are restored to the claim holding the data volume
+	snapshot, restore := cr.Annotations[sonarsourcev1alpha1.RestoreSnapshotAnnotation]
+	restore = restore && volume == dataVolume(cr)
+
 	foundPVC := &corev1.PersistentVolumeClaim{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: newPVC.Name, Namespace: newPVC.Namespace}, foundPVC)
+	if err != nil && errors.IsNotFound(err) {
+		if restore {
+			newPVC.Spec.DataSource = snapshotDataSource(snapshot)
+		}
+	} else if err != nil {
+		return foundPVC, err
+	} else {
+		if restore && !restoredFrom(foundPVC, snapshot) {
+			return foundPVC, r.replacePVC(cr, foundPVC, snapshot)
+		}
+		if err := r.verifyPVCResize(newPVC, foundPVC); err != nil {
+			return foundPVC, err
+		}
+		// The data source of a claim can't change, claims restored from a snapshot keep it
+		newPVC.Spec.DataSource = foundPVC.Spec.DataSource
+	}
+
+	return foundPVC, utils.ApplyResource(r.client, r.scheme, newPVC, foundPVC, "")
+}
 
-	return foundPVC, utils.CreateResourceIfNotFound(r.client, newPVC, foundPVC)
+// replacePVC removes the PersistentVolumeClaim of a shut down SonarQube so it is created again from snapshot
+// Errors:
+//   ErrorReasonResourceWaiting: returned while SonarQube isn't shut down or the claim is being removed
+//   ErrorReasonResourceUpdate: returned when the claim was removed
+//   ErrorReasonUnknown: returned when unhandled error from client occurs
+func (r *ReconcileSonarQube) replacePVC(cr *sonarsourcev1alpha1.SonarQube, pvc *corev1.PersistentVolumeClaim, snapshot string) error {
+	if cr.Spec.Shutdown == nil || !*cr.Spec.Shutdown {
+		return &utils.Error{
+			Reason:  utils.ErrorReasonResourceWaiting,
+			Message: fmt.Sprintf("waiting for shutdown to restore snapshot %s", snapshot),
+		}
+	}
+
+	if pvc.DeletionTimestamp != nil {
+		return &utils.Error{
+			Reason:  utils.ErrorReasonResourceWaiting,
+			Message: fmt.Sprintf("waiting for removal of persistentvolumeclaim %s to restore snapshot %s", pvc.Name, snapshot),
+		}
+	}
+
+	if err := r.client.Delete(context.TODO(), pvc); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	return &utils.Error{
+		Reason:  utils.ErrorReasonResourceUpdate,
+		Message: fmt.Sprintf("removed persistentvolumeclaim %s to restore snapshot %s", pvc.Name, snapshot),
+	}
 }
 
-func (r *ReconcileSonarQube) newPVC(cr *sonarsourcev1alpha1.SonarQube) (*corev1.PersistentVolumeClaim, error) {
+// verifyPVCResize checks the claim found can be changed to newPVC.
+// The storage class of a claim can't change and claims only grow when their storage class allows volume expansion
+// Errors:
+//   ErrorReasonSpecInvalid: returned when the claim can't be changed to newPVC
+//   ErrorReasonUnknown: returned when unhandled error from client occurs
+func (r *ReconcileSonarQube) verifyPVCResize(newPVC, foundPVC *corev1.PersistentVolumeClaim) error {
+	if newPVC.Spec.StorageClassName != nil && storageClassName(foundPVC) != *newPVC.Spec.StorageClassName {
+		return &utils.Error{
+			Reason:  utils.ErrorReasonSpecInvalid,
+			Message: fmt.Sprintf("storage class of persistentvolumeclaim %s is %s, it can't be changed to %s", foundPVC.Name, storageClassName(foundPVC), *newPVC.Spec.StorageClassName),
+		}
+	}
+
+	requested := newPVC.Spec.Resources.Requests[corev1.ResourceStorage]
+	current := foundPVC.Spec.Resources.Requests[corev1.ResourceStorage]
+	switch requested.Cmp(current) {
+	case -1:
+		return &utils.Error{
+			Reason:  utils.ErrorReasonSpecInvalid,
+			Message: fmt.Sprintf("storage size of persistentvolumeclaim %s can't shrink from %s to %s", foundPVC.Name, current.String(), requested.String()),
+		}
+	case 1:
+		if foundPVC.Spec.StorageClassName == nil || *foundPVC.Spec.StorageClassName == "" {
+			return &utils.Error{
+				Reason:  utils.ErrorReasonSpecInvalid,
+				Message: fmt.Sprintf("persistentvolumeclaim %s has no storage class, it can't be expanded to %s", foundPVC.Name, requested.String()),
+			}
+		}
+
+		storageClass := &storagev1.StorageClass{}
+		err := r.client.Get(context.TODO(), types.NamespacedName{Name: *foundPVC.Spec.StorageClassName}, storageClass)
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		if err != nil || storageClass.AllowVolumeExpansion == nil || !*storageClass.AllowVolumeExpansion {
+			return &utils.Error{
+				Reason:  utils.ErrorReasonSpecInvalid,
+				Message: fmt.Sprintf("storage class %s doesn't allow volume expansion, persistentvolumeclaim %s can't be expanded to %s", *foundPVC.Spec.StorageClassName, foundPVC.Name, requested.String()),
+			}
+		}
+	}
+
+	return nil
+}
+
+func storageClassName(pvc *corev1.PersistentVolumeClaim) string {
+	if pvc.Spec.StorageClassName == nil {
+		return ""
+	}
+	return *pvc.Spec.StorageClassName
+}
+
+func snapshotDataSource(snapshot string) *corev1.TypedLocalObjectReference {
+	return &corev1.TypedLocalObjectReference{
+		APIGroup: &[]string{"snapshot.storage.k8s.io"}[0],
+		Kind:     "VolumeSnapshot",
+		Name:     snapshot,
+	}
+}
+
+// restoredFrom checks the claim was created from snapshot
+func restoredFrom(pvc *corev1.PersistentVolumeClaim, snapshot string) bool {
+	return pvc.Spec.DataSource != nil && pvc.Spec.DataSource.Kind == "VolumeSnapshot" && pvc.Spec.DataSource.Name == snapshot
+}
+
+func (r *ReconcileSonarQube) newPVC(cr *sonarsourcev1alpha1.SonarQube, volume Volume) (*corev1.PersistentVolumeClaim, error) {
 	labels := r.Labels(cr)
 
+	spec, err := r.newPVCSpec(cr, volume)
+	if err != nil {
+		return nil, err
+	}
+
 	dep := &corev1.PersistentVolumeClaim{
 		ObjectMeta: v1.ObjectMeta{
-			Name:      cr.Name,
+			Name:      pvcName(cr, volume),
 			Namespace: cr.Namespace,
 			Labels:    labels,
 		},
-		Spec: corev1.PersistentVolumeClaimSpec{
-			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
-			Resources: corev1.ResourceRequirements{
-				Requests: corev1.ResourceList{},
-			},
-			VolumeMode: &[]corev1.PersistentVolumeMode{corev1.PersistentVolumeFilesystem}[0],
-		},
+		Spec: *spec,
 	}
 
-	var storageSize string
-	if cr.Spec.NodeConfig.StorageSize == nil {
-		storageSize = DefaultVolumeSize
-	} else {
-		storageSize = *cr.Spec.NodeConfig.StorageSize
+	if err := controllerutil.SetControllerReference(cr, dep, r.scheme); err != nil {
+		return dep, err
 	}
 
-	if cr.Spec.NodeConfig.StorageClass != nil {
-		dep.Spec.StorageClassName = cr.Spec.NodeConfig.StorageClass
+	return dep, nil
+}
+
+// newPVCSpec returns the claim spec of volume, the storage volume spec is shared by the PersistentVolumeClaim
+// and the volume claim template of search nodes
+func (r *ReconcileSonarQube) newPVCSpec(cr *sonarsourcev1alpha1.SonarQube, volume Volume) (*corev1.PersistentVolumeClaimSpec, error) {
+	spec := &corev1.PersistentVolumeClaimSpec{
+		AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{},
+		},
+		VolumeMode: &[]corev1.PersistentVolumeMode{corev1.PersistentVolumeFilesystem}[0],
 	}
 
-	if size, err := resource.ParseQuantity(storageSize); err != nil {
+	spec.StorageClassName = storageClass(cr, volume)
+
+	if size, err := resource.ParseQuantity(storageSize(cr, volume)); err != nil {
 		return nil, err
 	} else {
-		dep.Spec.Resources.Requests[corev1.ResourceStorage] = size
-	}
-
-	if err := controllerutil.SetControllerReference(cr, dep, r.scheme); err != nil {
-		return dep, err
+		spec.Resources.Requests[corev1.ResourceStorage] = size
 	}
 
-	return dep, nil
+	return spec, nil
 }
 
+// Volume of SonarQube, data, extensions and logs are kept in the storage volume unless they have a volume of their own
 type Volume string
 
+const (
+	VolumeStorage    Volume = "storage"
+	VolumeData       Volume = "data"
+	VolumeExtensions Volume = "extensions"
+	VolumeLogs       Volume = "logs"
+	VolumeTemp       Volume = "temp"
+)
+
 const (
 	DefaultVolumeSize = "1Gi"
 )
+
+var (
+	allVolumes = []Volume{VolumeStorage, VolumeData, VolumeExtensions, VolumeLogs, VolumeTemp}
+	// storageVolumes are kept in the storage volume at their name unless they have a volume of their own
+	storageVolumes = []Volume{VolumeData, VolumeExtensions, VolumeLogs}
+)
+
+// volumeSource returns the source of volume in spec, nil when volume has none
+func volumeSource(cr *sonarsourcev1alpha1.SonarQube, volume Volume) *sonarsourcev1alpha1.VolumeSource {
+	volumes := cr.Spec.NodeConfig.Volumes
+	if volumes == nil {
+		return nil
+	}
+
+	switch volume {
+	case VolumeData:
+		return volumes.Data
+	case VolumeExtensions:
+		return volumes.Extensions
+	case VolumeLogs:
+		return volumes.Logs
+	case VolumeTemp:
+		return volumes.Temp
+	}
+	return nil
+}
+
+// managedVolume checks volume is kept in a PersistentVolumeClaim created by the operator
+func managedVolume(cr *sonarsourcev1alpha1.SonarQube, volume Volume) bool {
+	if volume == VolumeStorage {
+		return true
+	}
+	source := volumeSource(cr, volume)
+	return source != nil && source.EmptyDir == nil && source.ClaimName == nil
+}
+
+// usesStorage checks any volume is kept in the storage volume
+func usesStorage(cr *sonarsourcev1alpha1.SonarQube) bool {
+	for _, volume := range storageVolumes {
+		if volumeSource(cr, volume) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// dataVolume returns the volume holding the data of SonarQube
+func dataVolume(cr *sonarsourcev1alpha1.SonarQube) Volume {
+	if volumeSource(cr, VolumeData) == nil {
+		return VolumeStorage
+	}
+	return VolumeData
+}
+
+// pvcName returns the name of the PersistentVolumeClaim created for volume
+func pvcName(cr *sonarsourcev1alpha1.SonarQube, volume Volume) string {
+	if volume == VolumeStorage {
+		return cr.Name
+	}
+	return fmt.Sprintf("%s-%s", cr.Name, volume)
+}
+
+// storageSize returns the storage size of volume set in spec or the default size
+func storageSize(cr *sonarsourcev1alpha1.SonarQube, volume Volume) string {
+	size := cr.Spec.NodeConfig.StorageSize
+	if volume != VolumeStorage {
+		size = nil
+		if source := volumeSource(cr, volume); source != nil {
+			size = source.Size
+		}
+	}
+
+	if size == nil {
+		return DefaultVolumeSize
+	}
+	return *size
+}
+
+// storageClass returns the storage class of volume set in spec, volumes without one use the storage class of nodeConfig
+func storageClass(cr *sonarsourcev1alpha1.SonarQube, volume Volume) *string {
+	if source := volumeSource(cr, volume); source != nil && source.StorageClass != nil {
+		return source.StorageClass
+	}
+	return cr.Spec.NodeConfig.StorageClass
+}
@@ -6,12 +6,14 @@ import (
 	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
 	"github.com/jlfowle/sonarqube-operator/pkg/utils"
 	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
-	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
 	"testing"
 )
@@ -44,7 +46,7 @@ func TestSonarQubePVC(t *testing.T) {
 	s := scheme.Scheme
 	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
 	// Create a fake client to mock API calls.
-	cl := fake.NewFakeClientWithScheme(s, objs...)
+	cl := newFakeClient(s, objs...)
 	// Create a ReconcileSonarQube object with the scheme and fake client.
 	apiMock := &api_client.APIClientMock{}
 	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: apiMock}
@@ -61,3 +63,368 @@ func TestSonarQubePVC(t *testing.T) {
 		t.Fatalf(ReconcileErrorFormat, err)
 	}
 }
+
+// TestSonarQubePVCRestore runs ReconcileSonarQube.ReconcilePVC() against a
+// fake client with a snapshot to restore
+func TestSonarQubePVCRestore(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name      = "sonarqube-operator"
+		namespace = "sonarqube"
+		snapshot  = "backup-1"
+	)
+
+	// A SonarQube resource with metadata and spec.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{},
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
+	// Create a fake client to mock API calls.
+	cl := newFakeClient(s, objs...)
+	// Create a ReconcileSonarQube object with the scheme and fake client.
+	apiMock := &api_client.APIClientMock{}
+	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: apiMock}
+
+	_, err := r.ReconcilePVC(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceCreate {
+		t.Fatal("reconcilePVC: resource created error not thrown when creating pvc")
+	}
+
+	sonarqube.Annotations = map[string]string{sonarsourcev1alpha1.RestoreSnapshotAnnotation: snapshot}
+	err = r.client.Update(context.TODO(), sonarqube)
+	if err != nil {
+		t.Fatalf("update sonarqube: (%v)", err)
+	}
+
+	_, err = r.ReconcilePVC(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceWaiting {
+		t.Error("reconcilePVC: resource waiting error not thrown before shutdown")
+	}
+
+	sonarqube.Spec.Shutdown = &[]bool{true}[0]
+	err = r.client.Update(context.TODO(), sonarqube)
+	if err != nil {
+		t.Fatalf("update sonarqube: (%v)", err)
+	}
+
+	_, err = r.ReconcilePVC(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceUpdate {
+		t.Error("reconcilePVC: resource updated error not thrown when removing pvc")
+	}
+
+	_, err = r.ReconcilePVC(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceCreate {
+		t.Error("reconcilePVC: resource created error not thrown when restoring pvc")
+	}
+	dataPVC := &corev1.PersistentVolumeClaim{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: sonarqube.Name, Namespace: namespace}, dataPVC)
+	if err != nil {
+		t.Fatalf(ReconcileErrorFormat, err)
+	}
+	if dataPVC.Spec.DataSource == nil || dataPVC.Spec.DataSource.Name != snapshot {
+		t.Error("reconcilePVC: pvc not restored from snapshot")
+	}
+
+	delete(sonarqube.Annotations, sonarsourcev1alpha1.RestoreSnapshotAnnotation)
+	err = r.client.Update(context.TODO(), sonarqube)
+	if err != nil {
+		t.Fatalf("update sonarqube: (%v)", err)
+	}
+
+	_, err = r.ReconcilePVC(sonarqube)
+	if err != nil {
+		t.Errorf("reconcilePVC: returned error once restored (%v)", err)
+	}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: sonarqube.Name, Namespace: namespace}, dataPVC)
+	if err != nil {
+		t.Fatalf(ReconcileErrorFormat, err)
+	}
+	if dataPVC.Spec.DataSource == nil {
+		t.Error("reconcilePVC: data source of restored pvc removed")
+	}
+}
+
+// TestSonarQubePVCResize runs ReconcileSonarQube.ReconcilePVC() against a
+// fake client with storage size changes
+func TestSonarQubePVCResize(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name           = "sonarqube-operator"
+		namespace      = "sonarqube"
+		namespacedName = types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		}
+	)
+
+	// A SonarQube resource with metadata and spec.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{
+			NodeConfig: sonarsourcev1alpha1.NodeConfig{
+				StorageClass: &[]string{"standard"}[0],
+				StorageSize:  &[]string{"2Gi"}[0],
+			},
+		},
+	}
+	// A bound claim provisioned by a storage class without volume expansion.
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			StorageClassName: &[]string{"standard"}[0],
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: resource.MustParse("1Gi"),
+				},
+			},
+		},
+		Status: corev1.PersistentVolumeClaimStatus{
+			Phase: corev1.ClaimBound,
+			Capacity: corev1.ResourceList{
+				corev1.ResourceStorage: resource.MustParse("1Gi"),
+			},
+		},
+	}
+	storageClass := &storagev1.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "standard",
+		},
+		AllowVolumeExpansion: &[]bool{false}[0],
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+		pvc,
+		storageClass,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
+	if err := controllerutil.SetControllerReference(sonarqube, pvc, s); err != nil {
+		t.Fatalf("set owner: (%v)", err)
+	}
+	// Create a fake client to mock API calls.
+	cl := newFakeClient(s, objs...)
+	// Create a ReconcileSonarQube object with the scheme and fake client.
+	apiMock := &api_client.APIClientMock{}
+	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: apiMock}
+
+	_, err := r.ReconcilePVC(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonSpecInvalid {
+		t.Error("reconcilePVC: spec invalid error not thrown when storage class doesn't allow expansion")
+	}
+	if sonarqube.Status.Storage.Requested != "2Gi" || sonarqube.Status.Storage.Capacity != "1Gi" {
+		t.Errorf("reconcilePVC: storage status not recorded (%v)", sonarqube.Status.Storage)
+	}
+
+	storageClass.AllowVolumeExpansion = &[]bool{true}[0]
+	err = r.client.Update(context.TODO(), storageClass)
+	if err != nil {
+		t.Fatalf("update storageclass: (%v)", err)
+	}
+
+	_, err = r.ReconcilePVC(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceUpdate {
+		t.Errorf("reconcilePVC: resource updated error not thrown when expanding pvc (%v)", err)
+	}
+	dataPVC := &corev1.PersistentVolumeClaim{}
+	err = r.client.Get(context.TODO(), namespacedName, dataPVC)
+	if err != nil {
+		t.Fatalf(ReconcileErrorFormat, err)
+	}
+	if size := dataPVC.Spec.Resources.Requests[corev1.ResourceStorage]; size.String() != "2Gi" {
+		t.Errorf("reconcilePVC: pvc request not expanded (%s)", size.String())
+	}
+
+	sonarqube.Spec.NodeConfig.StorageSize = &[]string{"1Gi"}[0]
+	err = r.client.Update(context.TODO(), sonarqube)
+	if err != nil {
+		t.Fatalf("update sonarqube: (%v)", err)
+	}
+	_, err = r.ReconcilePVC(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonSpecInvalid {
+		t.Error("reconcilePVC: spec invalid error not thrown when shrinking pvc")
+	}
+
+	sonarqube.Spec.NodeConfig.StorageSize = &[]string{"2Gi"}[0]
+	sonarqube.Spec.NodeConfig.StorageClass = &[]string{"fast"}[0]
+	err = r.client.Update(context.TODO(), sonarqube)
+	if err != nil {
+		t.Fatalf("update sonarqube: (%v)", err)
+	}
+	_, err = r.ReconcilePVC(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonSpecInvalid {
+		t.Error("reconcilePVC: spec invalid error not thrown when changing storage class")
+	}
+}
+
+// TestSonarQubePVCVolumes runs ReconcileSonarQube.ReconcilePVC() against a
+// fake client with volumes moved out of an existing storage claim
+func TestSonarQubePVCVolumes(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name           = "sonarqube-operator"
+		namespace      = "sonarqube"
+		namespacedName = types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		}
+	)
+
+	// A SonarQube resource with data, extensions and logs out of the storage volume.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{
+			NodeConfig: sonarsourcev1alpha1.NodeConfig{
+				Volumes: &sonarsourcev1alpha1.Volumes{
+					Data: &sonarsourcev1alpha1.VolumeSource{
+						Size: &[]string{"5Gi"}[0],
+					},
+					Extensions: &sonarsourcev1alpha1.VolumeSource{
+						EmptyDir: &corev1.EmptyDirVolumeSource{},
+					},
+					Logs: &sonarsourcev1alpha1.VolumeSource{
+						ClaimName: &[]string{"logs"}[0],
+					},
+				},
+			},
+		},
+	}
+	// The storage claim of SonarQube before volumes were moved out.
+	storagePVC := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+		storagePVC,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
+	// Create a fake client to mock API calls.
+	cl := newFakeClient(s, objs...)
+	// Create a ReconcileSonarQube object with the scheme and fake client.
+	apiMock := &api_client.APIClientMock{}
+	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: apiMock}
+
+	_, err := r.ReconcilePVC(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceCreate {
+		t.Errorf("reconcilePVC: resource created error not thrown when creating data pvc (%v)", err)
+	}
+	dataPVC := &corev1.PersistentVolumeClaim{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: name + "-data", Namespace: namespace}, dataPVC)
+	if err != nil {
+		t.Fatalf("reconcilePVC: data pvc not created (%v)", err)
+	}
+	if size := dataPVC.Spec.Resources.Requests[corev1.ResourceStorage]; size.String() != "5Gi" {
+		t.Errorf("reconcilePVC: data pvc requests %s", size.String())
+	}
+
+	_, err = r.ReconcilePVC(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceWaiting {
+		t.Errorf("reconcilePVC: resource waiting error not thrown when logs claim is missing (%v)", err)
+	}
+
+	logsPVC := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "logs",
+			Namespace: namespace,
+		},
+	}
+	if err := r.client.Create(context.TODO(), logsPVC); err != nil {
+		t.Fatalf("create logs pvc: (%v)", err)
+	}
+
+	pvcs, err := r.ReconcilePVC(sonarqube)
+	if err != nil {
+		t.Fatalf("reconcilePVC: (%v)", err)
+	}
+	if pvcs[VolumeStorage] == nil || pvcs[VolumeData] == nil || pvcs[VolumeLogs] == nil || pvcs[VolumeExtensions] != nil {
+		t.Errorf("reconcilePVC: unexpected claims %v", pvcs)
+	}
+
+	sonarqube = &sonarsourcev1alpha1.SonarQube{}
+	if err := r.client.Get(context.TODO(), namespacedName, sonarqube); err != nil {
+		t.Fatalf("get sonarqube: (%v)", err)
+	}
+	if sonarqube.Status.Storage.Claim != name+"-data" || sonarqube.Status.Storage.SubPath != "" || sonarqube.Status.Storage.Requested != "5Gi" {
+		t.Errorf("reconcilePVC: unexpected storage status %v", sonarqube.Status.Storage)
+	}
+
+	for {
+		_, err := r.ReconcilePasscode(sonarqube)
+		if err != nil && utils.ReasonForError(err) == utils.ErrorReasonUnknown {
+			t.Fatalf("reconcilePasscode: (%v)", err)
+		} else if err == nil {
+			break
+		}
+	}
+
+	// Data and logs are copied out of the storage claim before the plugins are installed
+	volumes := podVolumes(sonarqube, pvcs)
+	template, err := r.newPodTemplate(sonarqube, sonarsourcev1alpha1.AIO, r.PodLabels(sonarqube), &corev1.ServiceAccount{}, &corev1.Secret{}, volumes)
+	if err != nil {
+		t.Fatalf("newPodTemplate: (%v)", err)
+	}
+	initContainers := template.Spec.InitContainers
+	if len(initContainers) != 2 || initContainers[0].Name != "migrate-volumes" || initContainers[0].Env[0].Value != "data logs" {
+		t.Fatalf("newPodTemplate: volumes not migrated before plugins are installed (%v)", initContainers)
+	}
+	mounts := make(map[string]corev1.VolumeMount)
+	for _, mount := range template.Spec.Containers[0].VolumeMounts {
+		mounts[mount.MountPath] = mount
+	}
+	if mount := mounts[VolumePathData]; mount.Name != string(VolumeData) || mount.SubPath != "" {
+		t.Errorf("newPodTemplate: data mounted from %s/%s", mount.Name, mount.SubPath)
+	}
+	if mount := initContainers[1].VolumeMounts[0]; mount.Name != string(VolumeExtensions) || mount.SubPath != "" {
+		t.Errorf("newPodTemplate: plugins installed in %s/%s", mount.Name, mount.SubPath)
+	}
+
+	// The storage claim is no longer mounted once it is deleted
+	if err := r.client.Delete(context.TODO(), storagePVC); err != nil {
+		t.Fatalf("delete storage pvc: (%v)", err)
+	}
+	pvcs, err = r.ReconcilePVC(sonarqube)
+	if err != nil {
+		t.Fatalf("reconcilePVC: (%v)", err)
+	}
+	if _, ok := pvcs[VolumeStorage]; ok {
+		t.Error("reconcilePVC: deleted storage pvc still mounted")
+	}
+	if migrated := migratedVolumes(podVolumes(sonarqube, pvcs)); len(migrated) != 0 {
+		t.Errorf("reconcilePVC: volumes %v migrated without storage pvc", migrated)
+	}
+}
@@ -1,6 +1,7 @@
 package sonarqube
 
 import (
+	"encoding/json"
 	"fmt"
 	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
 	"github.com/jlfowle/sonarqube-operator/pkg/utils"
@@ -15,6 +16,7 @@ import (
 // If Error is non-nil, Service is not in expected state
 // Errors:
 //   ErrorReasonSpecUpdate: returned when spec does not have secret name
+//   ErrorReasonSpecInvalid: returned when configuration files are invalid or contain properties managed by the operator
 //   ErrorReasonResourceCreate: returned when secret does not exists
 //   ErrorReasonResourceUpdate: returned when secret was updated to meet expected state
 //   ErrorReasonUnknown: returned when unhandled error from client occurs
@@ -25,29 +27,87 @@ func (r *ReconcileSonarQube) ReconcileSecret(cr *sonarsourcev1alpha1.SonarQube)
 	}
 
 	if !utils.IsOwner(cr, foundSecret) {
-		annotations := foundSecret.GetAnnotations()
-		if val, ok := annotations[sonarsourcev1alpha1.ServerSecretAnnotation]; ok && !strings.Contains(val, cr.Name) {
-			annotations[sonarsourcev1alpha1.ServerSecretAnnotation] = fmt.Sprintf("%s,%s", val, cr.Name)
-			foundSecret.SetAnnotations(annotations)
-			return foundSecret, utils.UpdateResource(r.client, foundSecret, utils.ErrorReasonResourceUpdate, "updated secret annotation")
-		} else if !ok {
-			if annotations == nil {
-				annotations = make(map[string]string)
-			}
-			annotations[sonarsourcev1alpha1.ServerSecretAnnotation] = cr.Name
-			foundSecret.SetAnnotations(annotations)
-			return foundSecret, utils.UpdateResource(r.client, foundSecret, utils.ErrorReasonResourceUpdate, "updated secret annotation")
+		if err := r.watchSecret(cr, foundSecret, sonarsourcev1alpha1.ServerSecretAnnotation); err != nil {
+			return foundSecret, err
 		}
 	}
 
 	err = r.verifySecret(cr, foundSecret)
 	if err != nil {
-		return foundSecret, nil
+		return foundSecret, err
+	}
+
+	err = r.updateRevision(cr, foundSecret)
+	if err != nil {
+		return foundSecret, err
 	}
 
 	return foundSecret, nil
 }
 
+// watchSecret annotates a secret that isn't owned by the SonarQube so changes to it requeue the SonarQube
+func (r *ReconcileSonarQube) watchSecret(cr *sonarsourcev1alpha1.SonarQube, secret *corev1.Secret, annotation string) error {
+	annotations := secret.GetAnnotations()
+	if val, ok := annotations[annotation]; ok && !strings.Contains(val, cr.Name) {
+		annotations[annotation] = fmt.Sprintf("%s,%s", val, cr.Name)
+		secret.SetAnnotations(annotations)
+		return utils.UpdateResource(r.client, secret, utils.ErrorReasonResourceUpdate, "updated secret annotation")
+	} else if !ok {
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		annotations[annotation] = cr.Name
+		secret.SetAnnotations(annotations)
+		return utils.UpdateResource(r.client, secret, utils.ErrorReasonResourceUpdate, "updated secret annotation")
+	}
+	return nil
+}
+
+// updateRevision hashes the spec feeding the pods and the configuration secret data and records it in status
+// so that configuration changes roll the pods
+func (r *ReconcileSonarQube) updateRevision(cr *sonarsourcev1alpha1.SonarQube, secret *corev1.Secret) error {
+	data, err := json.Marshal(secret.Data)
+	if err != nil {
+		return err
+	}
+
+	revision, err := utils.GenVersion(revisionSpec(cr), data)
+	if err != nil {
+		return err
+	}
+
+	if cr.Status.Revision != revision {
+		newStatus := cr.DeepCopy()
+		newStatus.Status.Revision = revision
+		utils.UpdateStatus(r.client, newStatus, cr)
+	}
+
+	return nil
+}
+
+// revisionSpec returns the spec without the fields that don't feed the pod template,
+// changing them doesn't roll the pods
+func revisionSpec(cr *sonarsourcev1alpha1.SonarQube) *sonarsourcev1alpha1.SonarQubeSpec {
+	spec := cr.Spec.DeepCopy()
+	spec.Shutdown = nil
+	spec.UpdatesMinor = nil
+	spec.UpdatesMajor = nil
+	spec.UpdatesBackupMaxAge = nil
+	spec.MigrateDatabase = nil
+	spec.AuthSecret = nil
+	spec.ExternalURL = nil
+	spec.Monitoring = nil
+	spec.Backup = nil
+	if spec.Expose != nil {
+		spec.Expose.Annotations = nil
+	}
+	if spec.ServiceAccount != nil {
+		spec.ServiceAccount.Rules = nil
+	}
+
+	return spec
+}
+
 func (r *ReconcileSonarQube) findSecret(cr *sonarsourcev1alpha1.SonarQube) (*corev1.Secret, error) {
 	newSecret, err := r.newSecret(cr)
 	if err != nil {
@@ -56,11 +116,12 @@ func (r *ReconcileSonarQube) findSecret(cr *sonarsourcev1alpha1.SonarQube) (*cor
 
 	foundSecret := &corev1.Secret{}
 
-	return foundSecret, utils.CreateResourceIfNotFound(r.client, newSecret, foundSecret)
+	return foundSecret, utils.ApplyResource(r.client, r.scheme, newSecret, foundSecret, "")
 }
 
 func (r *ReconcileSonarQube) newSecret(cr *sonarsourcev1alpha1.SonarQube) (*corev1.Secret, error) {
-	labels := r.Labels(cr)
+	// The revision is computed from the secret so it can't be part of the secret labels
+	labels := r.PodLabels(cr)
 
 	dep := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
@@ -76,7 +137,7 @@ func (r *ReconcileSonarQube) newSecret(cr *sonarsourcev1alpha1.SonarQube) (*core
 
 	if cr.Spec.Secret == nil {
 		cr.Spec.Secret = &[]string{fmt.Sprintf("%s-config", cr.Name)}[0]
-		return dep, utils.UpdateResource(r.client, cr, utils.ErrorReasonSpecUpdate, "updated secret")
+		return dep, utils.UpdateResource(r.client, cr, utils.ErrorReasonSpecUpdate, fmt.Sprintf("set secret %s", *cr.Spec.Secret))
 	}
 
 	dep.Name = *cr.Spec.Secret
@@ -88,24 +149,108 @@ func (r *ReconcileSonarQube) newSecret(cr *sonarsourcev1alpha1.SonarQube) (*core
 	return dep, nil
 }
 
-func (r *ReconcileSonarQube) verifySecret(cr *sonarsourcev1alpha1.SonarQube, _ *corev1.Secret) error {
-	/*var sonarProperties *properties.Properties
-	var sonarPropertiesExists bool
-	if v, ok := s.Data["sonar.properties"]; ok {
-		sonarPropertiesExists = ok
-		sonarProperties, _ = properties.Load(v, properties.UTF8)
-	}*/
-
-	var nodeType sonarsourcev1alpha1.ServerType
-	if cr.Spec.Type == nil {
-		nodeType = sonarsourcev1alpha1.AIO
+// Properties set by the operator through environment variables for every node type.
+// Entries ending with "." match every key with that prefix
+var operatorProperties = []string{
+	"sonar.cluster.",
+	"sonar.web.port",
+	"sonar.path.",
+}
+
+// Properties that conflict with a node type
+var nodeTypeProperties = map[sonarsourcev1alpha1.ServerType][]string{
+	sonarsourcev1alpha1.Application: {
+		"sonar.search.",
+	},
+	sonarsourcev1alpha1.Search: {
+		"sonar.search.host",
+		"sonar.web.",
+		"sonar.ce.",
+	},
+}
+
+// Properties set by the operator for the nodes of a cluster managed from a single resource
+var clusterProperties = []string{
+	"sonar.search.host",
+	"sonar.search.port",
+	"sonar.auth.jwtBase64Hs256Secret",
+}
+
+func (r *ReconcileSonarQube) verifySecret(cr *sonarsourcev1alpha1.SonarQube, s *corev1.Secret) error {
+	// wrapper.conf is only checked for syntax, the operator doesn't set any wrapper properties
+	if _, ok := s.Data["wrapper.conf"]; ok {
+		if _, err := utils.GetProperties(s, "wrapper.conf"); err != nil {
+			return &utils.Error{
+				Reason:  utils.ErrorReasonSpecInvalid,
+				Message: fmt.Sprintf("wrapper.conf in secret %s is invalid: %v", s.Name, err),
+			}
+		}
+	}
+
+	if _, ok := s.Data["sonar.properties"]; !ok {
+		return nil
+	}
+	sonarProperties, err := utils.GetProperties(s, "sonar.properties")
+	if err != nil {
+		return &utils.Error{
+			Reason:  utils.ErrorReasonSpecInvalid,
+			Message: fmt.Sprintf("sonar.properties in secret %s is invalid: %v", s.Name, err),
+		}
+	}
+
+	nodeType := nodeType(cr)
+
+	reserved := append([]string{}, operatorProperties...)
+	if nodeType != sonarsourcev1alpha1.Search {
+		// The passcode the probes and the operator call the API with is set on every web node
+		reserved = append(reserved, "sonar.web.systemPasscode")
+	}
+	if cr.Spec.Cluster != nil {
+		// The secret is shared by application and search nodes of a cluster
+		nodeType = "cluster"
+		reserved = append(reserved, clusterProperties...)
 	} else {
-		nodeType = *cr.Spec.Type
+		reserved = append(reserved, nodeTypeProperties[nodeType]...)
+	}
+	if cr.Spec.Database != nil {
+		reserved = append(reserved, "sonar.jdbc.")
+	}
+	if exposeType(cr) != "" {
+		reserved = append(reserved, "sonar.core.serverBaseURL")
+	}
+	if webContext(cr) != "" {
+		reserved = append(reserved, "sonar.web.context")
+	}
+	if servesTLS(cr) {
+		// SonarQube only listens on localhost behind the TLS proxy
+		reserved = append(reserved, "sonar.web.host")
+	}
+	if cr.Spec.UpdateCenter != nil {
+		reserved = append(reserved, "sonar.updatecenter.url")
+	}
+
+	var invalid []string
+	for _, key := range sonarProperties.Keys() {
+		if matchesProperty(key, reserved) {
+			invalid = append(invalid, key)
+		}
 	}
 
-	switch nodeType {
-	case sonarsourcev1alpha1.Application, sonarsourcev1alpha1.Search:
+	if len(invalid) > 0 {
+		return &utils.Error{
+			Reason:  utils.ErrorReasonSpecInvalid,
+			Message: fmt.Sprintf("sonar.properties in secret %s contains properties managed by the operator for %s nodes: %s", s.Name, nodeType, strings.Join(invalid, ", ")),
+		}
 	}
 
 	return nil
 }
+
+func matchesProperty(key string, properties []string) bool {
+	for _, p := range properties {
+		if strings.HasSuffix(p, ".") && strings.HasPrefix(key, p) || key == p {
+			return true
+		}
+	}
+	return false
+}
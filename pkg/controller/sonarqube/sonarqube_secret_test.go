@@ -12,7 +12,6 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
-	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
 	"strings"
 	"testing"
@@ -50,7 +49,7 @@ func TestSonarQubeSecret(t *testing.T) {
 	s := scheme.Scheme
 	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
 	// Create a fake client to mock API calls.
-	cl := fake.NewFakeClientWithScheme(s, objs...)
+	cl := newFakeClient(s, objs...)
 	// Create a ReconcileSonarQube object with the scheme and fake client.
 	apiMock := &api_client.APIClientMock{}
 	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: apiMock}
@@ -125,7 +124,7 @@ func TestSonarQubeSecretUnowned(t *testing.T) {
 	s := scheme.Scheme
 	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
 	// Create a fake client to mock API calls.
-	cl := fake.NewFakeClientWithScheme(s, objs...)
+	cl := newFakeClient(s, objs...)
 	// Create a ReconcileSonarQube object with the scheme and fake client.
 	apiMock := &api_client.APIClientMock{}
 	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: apiMock}
@@ -165,3 +164,57 @@ func TestSonarQubeSecretUnowned(t *testing.T) {
 		t.Error("reconcileSecret: sonarqube2 name not appended to secret annotation")
 	}
 }
+
+// TestSonarQubeSecretProperties runs ReconcileSonarQube.verifySecret() with
+// configuration files containing properties managed by the operator
+func TestSonarQubeSecretProperties(t *testing.T) {
+	search := sonarsourcev1alpha1.Search
+
+	tests := []struct {
+		spec       sonarsourcev1alpha1.SonarQubeSpec
+		properties string
+		invalid    []string
+	}{
+		{sonarsourcev1alpha1.SonarQubeSpec{}, "sonar.log.level=DEBUG\nsonar.search.javaOpts=-Xmx1G\n", nil},
+		{sonarsourcev1alpha1.SonarQubeSpec{}, "sonar.web.port=8080\nsonar.cluster.enabled=true\nsonar.path.data=/data\n", []string{"sonar.web.port", "sonar.cluster.enabled", "sonar.path.data"}},
+		{sonarsourcev1alpha1.SonarQubeSpec{Type: &search}, "sonar.web.context=/sonar\nsonar.search.port=9001\n", []string{"sonar.web.context"}},
+		{sonarsourcev1alpha1.SonarQubeSpec{Database: &sonarsourcev1alpha1.Database{}}, "sonar.jdbc.url=jdbc:postgresql://db/sonar\n", []string{"sonar.jdbc.url"}},
+		{sonarsourcev1alpha1.SonarQubeSpec{}, "sonar.web.systemPasscode=secret\n", []string{"sonar.web.systemPasscode"}},
+		{sonarsourcev1alpha1.SonarQubeSpec{Cluster: &sonarsourcev1alpha1.Cluster{}}, "sonar.web.systemPasscode=secret\n", []string{"sonar.web.systemPasscode"}},
+		{sonarsourcev1alpha1.SonarQubeSpec{Expose: &sonarsourcev1alpha1.Expose{Host: "sonarqube.example.com"}}, "sonar.web.context=/sonar\n", nil},
+		{sonarsourcev1alpha1.SonarQubeSpec{Expose: &sonarsourcev1alpha1.Expose{Host: "sonarqube.example.com", Path: &[]string{"/sonar"}[0]}}, "sonar.web.context=/sonar\n", []string{"sonar.web.context"}},
+		{sonarsourcev1alpha1.SonarQubeSpec{}, "sonar.web.host=0.0.0.0\n", nil},
+		{sonarsourcev1alpha1.SonarQubeSpec{TLS: &sonarsourcev1alpha1.TLS{Secret: &[]string{"tls"}[0]}}, "sonar.web.host=0.0.0.0\n", []string{"sonar.web.host"}},
+	}
+
+	r := &ReconcileSonarQube{}
+	for _, test := range tests {
+		sonarqube := &sonarsourcev1alpha1.SonarQube{Spec: test.spec}
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "test",
+			},
+			Data: map[string][]byte{
+				"sonar.properties": []byte(test.properties),
+				"wrapper.conf":     []byte(""),
+			},
+		}
+
+		err := r.verifySecret(sonarqube, secret)
+		if len(test.invalid) == 0 {
+			if err != nil {
+				t.Errorf("verifySecret: returned error for valid properties (%v)", err)
+			}
+			continue
+		}
+		if utils.ReasonForError(err) != utils.ErrorReasonSpecInvalid {
+			t.Errorf("verifySecret: spec invalid error not thrown for %v", test.invalid)
+			continue
+		}
+		for _, key := range test.invalid {
+			if !strings.Contains(err.Error(), key) {
+				t.Errorf("verifySecret: %s not reported as invalid", key)
+			}
+		}
+	}
+}
@@ -3,10 +3,12 @@ package sonarqube
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/jlfowle/sonarqube-operator/pkg/api_client"
 	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
 	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
 )
 
 func (r *ReconcileSonarQube) ReconcileServer(cr *sonarsourcev1alpha1.SonarQube) error {
@@ -15,13 +17,16 @@ func (r *ReconcileSonarQube) ReconcileServer(cr *sonarsourcev1alpha1.SonarQube)
 		return err
 	}
 
-	var url string
-	if cr.Spec.ExternalURL != nil {
-		url = *cr.Spec.ExternalURL
-	} else {
-		url = fmt.Sprintf("http://%s:%v", service.Spec.ClusterIP, service.Spec.Ports[0].Port)
+	url := serverURL(cr, service)
+	tlsConfig, err := r.getTLSConfig(cr)
+	if err != nil {
+		return err
+	}
+	credentials, err := r.getAPICredentials(cr)
+	if err != nil {
+		return err
 	}
-	apiClient := r.apiClient.New(url)
+	apiClient := r.apiClient.New(url, credentials, tlsConfig)
 
 	/*err = apiClient.Ping()
 	if err != nil {
@@ -32,13 +37,31 @@ func (r *ReconcileSonarQube) ReconcileServer(cr *sonarsourcev1alpha1.SonarQube)
 	}*/
 
 	status, err := r.verifyServerStatus(cr, apiClient)
+	if err != nil {
+		return err
+	}
+
+	err = r.reconcileAdmin(cr, url, tlsConfig)
+	if err != nil {
+		return err
+	}
+
+	err = r.verifyServerHealth(cr, apiClient)
+	if err != nil {
+		return err
+	}
+
+	err = r.verifyPlugins(cr, apiClient)
+	if err != nil {
+		return err
+	}
 
 	err = r.verifyServerVersion(cr, status)
 	if err != nil {
 		return err
 	}
 
-	err = r.verifyUpgrades(cr, apiClient)
+	err = r.verifyUpgrades(cr, status, apiClient)
 	if err != nil {
 		return err
 	}
@@ -46,24 +69,60 @@ func (r *ReconcileSonarQube) ReconcileServer(cr *sonarsourcev1alpha1.SonarQube)
 	return nil
 }
 
-func (r *ReconcileSonarQube) verifyServerStatus(_ *sonarsourcev1alpha1.SonarQube, apiClient api_client.APIReader) (*api_client.Status, error) {
+func (r *ReconcileSonarQube) verifyServerStatus(cr *sonarsourcev1alpha1.SonarQube, apiClient api_client.APIReader) (*api_client.Status, error) {
 	status, err := apiClient.Status()
-	if err != nil {
-		return status, err
+	var previous api_client.SystemStatus
+	servers.update(cr, func(server *serverMetrics) {
+		previous = server.Status
+		server.Status = ""
+		if err == nil {
+			server.Status = status.Status
+		}
+	})
+	if err == nil && status.Status != previous {
+		eventType := corev1.EventTypeNormal
+		if status.Status == api_client.SystemDown {
+			eventType = corev1.EventTypeWarning
+		}
+		r.recorder.event(cr, eventType, EventReasonServerStatus, fmt.Sprintf("sonarqube server status %s", status.Status))
+	}
+	if _, ok := err.(*api_client.AuthenticationError); ok {
+		return status, parseAPIError(err)
+	} else if err != nil {
+		return status, &utils.Error{
+			Reason:  utils.ErrorReasonServerWaiting,
+			Message: fmt.Sprintf("waiting for api to respond (%s)", err.Error()),
+		}
 	}
 
 	switch status.Status {
 	case api_client.SystemDown:
+		if upgradeInProgress(cr) {
+			r.failUpgrade(cr, fmt.Sprintf("sonarqube server status %s", status.Status))
+		}
 		return status, &utils.Error{
 			Reason:  utils.ErrorReasonServerDown,
 			Message: fmt.Sprintf("sonarqube server status %s", status.Status),
 		}
-	case api_client.SystemStarting, api_client.SystemRestarting, api_client.SystemDBMigrationRunning, api_client.SystemDBMigrationNeeded:
+	case api_client.SystemStarting, api_client.SystemRestarting:
+		return status, &utils.Error{
+			Reason:  utils.ErrorReasonServerWaiting,
+			Message: fmt.Sprintf("sonarqube server status %s", status.Status),
+		}
+	case api_client.SystemDBMigrationRunning, api_client.SystemDBMigrationNeeded:
+		if err := r.reconcileMigration(cr, apiClient); err != nil {
+			return status, err
+		}
 		return status, &utils.Error{
 			Reason:  utils.ErrorReasonServerWaiting,
 			Message: fmt.Sprintf("sonarqube server status %s", status.Status),
 		}
 	case api_client.SystemUp:
+		if cr.Status.Conditions.IsTrueFor(sonarsourcev1alpha1.ConditionMigrating) {
+			if err := r.reconcileMigration(cr, apiClient); err != nil {
+				return status, err
+			}
+		}
 		return status, nil
 	default:
 		return status, &utils.Error{
@@ -101,7 +160,7 @@ func (r *ReconcileSonarQube) verifyServerVersion(cr *sonarsourcev1alpha1.SonarQu
 		}
 		return &utils.Error{
 			Reason:  utils.ErrorReasonSpecUpdate,
-			Message: "set version",
+			Message: fmt.Sprintf("set version %s", mmVersion),
 		}
 	}
 
@@ -110,23 +169,28 @@ func (r *ReconcileSonarQube) verifyServerVersion(cr *sonarsourcev1alpha1.SonarQu
 	newStatus.Status.ObservedVersion = string(version)
 	utils.UpdateStatus(r.client, newStatus, cr)
 
+	// Versions in spec may leave out the patch, they match any observed version they prefix
+	servers.update(cr, func(server *serverMetrics) {
+		server.Version = *cr.Spec.Version
+		server.ObservedVersion = string(version)
+		server.VersionMatches = strings.HasPrefix(string(version)+".", *cr.Spec.Version+".")
+	})
+
 	return nil
 }
 
-func (r *ReconcileSonarQube) verifyUpgrades(cr *sonarsourcev1alpha1.SonarQube, apiClient api_client.APIReader) error {
+func (r *ReconcileSonarQube) verifyUpgrades(cr *sonarsourcev1alpha1.SonarQube, status *api_client.Status, apiClient api_client.APIReader) error {
 	upgrades, err := apiClient.Upgrades()
 	if err != nil {
-		return err
+		return parseAPIError(err)
 	} else if upgrades == nil {
 		return fmt.Errorf("nil returned for upgrades")
 	}
 
 	newStatus := cr.DeepCopy()
 
-	newStatus.Status.Upgrades = sonarsourcev1alpha1.Upgrades{
-		Compatible:   []string{},
-		Incompatible: []string{},
-	}
+	newStatus.Status.Upgrades.Compatible = []string{}
+	newStatus.Status.Upgrades.Incompatible = []string{}
 
 	for _, v := range upgrades.Upgrades {
 		if len(v.Plugins.Incompatible) > 0 {
@@ -136,7 +200,27 @@ func (r *ReconcileSonarQube) verifyUpgrades(cr *sonarsourcev1alpha1.SonarQube, a
 		}
 	}
 
+	if message := upgradesMessage(newStatus.Status.Upgrades); message != "" && message != upgradesMessage(cr.Status.Upgrades) {
+		r.recorder.event(cr, corev1.EventTypeNormal, EventReasonUpgradesAvailable, message)
+	}
+
 	utils.UpdateStatus(r.client, newStatus, cr)
 
-	return nil
+	servers.update(cr, func(server *serverMetrics) {
+		server.Compatible = len(newStatus.Status.Upgrades.Compatible)
+		server.Incompatible = len(newStatus.Status.Upgrades.Incompatible)
+	})
+
+	return r.reconcileUpgrade(cr, status, upgrades)
+}
+
+// parseAPIError converts errors for rejected credentials into ErrorReasonServerAuth
+func parseAPIError(err error) error {
+	if authErr, ok := err.(*api_client.AuthenticationError); ok {
+		return &utils.Error{
+			Reason:  utils.ErrorReasonServerAuth,
+			Message: fmt.Sprintf("sonarqube api rejected credentials (%s)", authErr.Error()),
+		}
+	}
+	return err
 }
@@ -5,6 +5,7 @@ import (
 	"github.com/jlfowle/sonarqube-operator/pkg/utils"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
@@ -27,14 +28,6 @@ func (r *ReconcileSonarQube) ReconcileService(cr *sonarsourcev1alpha1.SonarQube)
 
 	utils.UpdateStatus(r.client, newStatus, cr)
 
-	newService, err := r.newService(cr)
-	if err != nil {
-		return service, err
-	}
-	if err := utils.VerifyService(r.client, service, newService); err != nil {
-		return service, err
-	}
-
 	return service, nil
 }
 
@@ -46,18 +39,13 @@ func (r *ReconcileSonarQube) findService(cr *sonarsourcev1alpha1.SonarQube) (*co
 
 	foundService := &corev1.Service{}
 
-	return foundService, utils.CreateResourceIfNotFound(r.client, newService, foundService)
+	return foundService, utils.ApplyResource(r.client, r.scheme, newService, foundService, "")
 }
 
 func (r *ReconcileSonarQube) newService(cr *sonarsourcev1alpha1.SonarQube) (*corev1.Service, error) {
 	labels := r.Labels(cr)
 
-	var nodeType sonarsourcev1alpha1.ServerType
-	if cr.Spec.Type == nil {
-		nodeType = sonarsourcev1alpha1.AIO
-	} else {
-		nodeType = *cr.Spec.Type
-	}
+	nodeType := nodeType(cr)
 
 	dep := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
@@ -66,12 +54,21 @@ func (r *ReconcileSonarQube) newService(cr *sonarsourcev1alpha1.SonarQube) (*cor
 			Labels:    labels,
 		},
 		Spec: corev1.ServiceSpec{
-			Selector: labels,
+			Selector: r.SelectorLabels(cr),
 			Type:     corev1.ServiceTypeClusterIP,
 			Ports:    utils.ServicePorts(nodeType),
 		},
 	}
 
+	// The web port of pods serving https is the TLS proxy
+	if servesTLS(cr) && nodeType != sonarsourcev1alpha1.Search {
+		for i := range dep.Spec.Ports {
+			if dep.Spec.Ports[i].Name == "web" {
+				dep.Spec.Ports[i].TargetPort = intstr.FromString(TLSProxyPortName)
+			}
+		}
+	}
+
 	if err := controllerutil.SetControllerReference(cr, dep, r.scheme); err != nil {
 		return dep, err
 	}
@@ -11,7 +11,6 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
-	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
 	"testing"
 )
@@ -64,7 +63,7 @@ func TestSonarQubeService(t *testing.T) {
 	s := scheme.Scheme
 	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqubeList[0])
 	// Create a fake client to mock API calls.
-	cl := fake.NewFakeClientWithScheme(s, objs...)
+	cl := newFakeClient(s, objs...)
 	// Create a ReconcileSonarQube object with the scheme and fake client.
 	apiMock := &api_client.APIClientMock{}
 	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: apiMock}
@@ -87,5 +86,32 @@ func TestSonarQubeService(t *testing.T) {
 		if err != nil {
 			t.Error("reconcileService: returned error even though Service is in expected state")
 		}
+
+		// Fields not set by the operator are left alone
+		Service.Annotations = map[string]string{"example.com/owner": "user"}
+		err = r.client.Update(context.TODO(), Service)
+		if err != nil {
+			t.Fatalf("reconcileService: (%v)", err)
+		}
+		Service, err = r.ReconcileService(sonarqube)
+		if err != nil {
+			t.Error("reconcileService: returned error for fields not managed by the operator")
+		}
+		if Service.Annotations["example.com/owner"] != "user" {
+			t.Error("reconcileService: annotation added by user removed")
+		}
+
+		sonarqube.Labels = map[string]string{"team": "qa"}
+		Service, err = r.ReconcileService(sonarqube)
+		if utils.ReasonForError(err) != utils.ErrorReasonResourceUpdate {
+			t.Errorf("reconcileService: resource update error not thrown when labels changed (%v)", err)
+		}
+		if Service.Labels["team"] != "qa" || Service.Annotations["example.com/owner"] != "user" {
+			t.Error("reconcileService: labels not applied")
+		}
+		// Labels of the SonarQube resource are left out of selectors
+		if _, ok := Service.Spec.Selector["team"]; ok || len(Service.Spec.Selector) != 3 {
+			t.Errorf("reconcileService: selector not limited to the labels of the operator (%v)", Service.Spec.Selector)
+		}
 	}
 }
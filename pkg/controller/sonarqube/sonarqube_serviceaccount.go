@@ -1,22 +1,44 @@
 package sonarqube
 
 import (
+	"context"
+	"fmt"
+	"strings"
+
 	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
 	"github.com/jlfowle/sonarqube-operator/pkg/utils"
 	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
-// Reconciles Service for SonarQube
-// Returns: Service, Error
-// If Error is non-nil, Service is not in expected state
+// Reconciles ServiceAccount for SonarQube and the Role with the rules in spec bound to it
+// Returns: ServiceAccount, Error
+// If Error is non-nil, ServiceAccount is not in expected state
 // Errors:
-//   ErrorReasonResourceCreate: returned when Service does not exists
-//   ErrorReasonResourceUpdate: returned when Service was updated to meet expected state
+//   ErrorReasonSpecInvalid: returned when annotations or image pull secrets are set for an existing ServiceAccount
+//   ErrorReasonResourceCreate: returned when ServiceAccount, Role or RoleBinding does not exists
+//   ErrorReasonResourceUpdate: returned when ServiceAccount, Role or RoleBinding was updated to meet expected state
+//     or Role and RoleBinding were removed
+//   ErrorReasonResourceWaiting: returned when the existing ServiceAccount does not exists
 //   ErrorReasonUnknown: returned when unhandled error from client occurs
 func (r *ReconcileSonarQube) ReconcileServiceAccount(cr *sonarsourcev1alpha1.SonarQube) (*corev1.ServiceAccount, error) {
-	foundServiceAccount, err := r.findServiceAccount(cr)
+	var foundServiceAccount *corev1.ServiceAccount
+	var err error
+	if createServiceAccount(cr) {
+		foundServiceAccount, err = r.findServiceAccount(cr)
+	} else {
+		foundServiceAccount, err = r.getServiceAccount(cr)
+	}
+	if err != nil {
+		return foundServiceAccount, err
+	}
+
+	err = r.reconcileRBAC(cr, foundServiceAccount)
 	if err != nil {
 		return foundServiceAccount, err
 	}
@@ -24,6 +46,59 @@ func (r *ReconcileSonarQube) ReconcileServiceAccount(cr *sonarsourcev1alpha1.Son
 	return foundServiceAccount, nil
 }
 
+// reconcileRBAC binds a Role with the rules in spec to the service account,
+// the Role and RoleBinding owned by cr are removed when no rules are set
+func (r *ReconcileSonarQube) reconcileRBAC(cr *sonarsourcev1alpha1.SonarQube, serviceAccount *corev1.ServiceAccount) error {
+	if cr.Spec.ServiceAccount == nil || len(cr.Spec.ServiceAccount.Rules) == 0 {
+		if err := r.removeOwned(cr, &rbacv1.RoleBinding{}, "rolebinding"); err != nil {
+			return err
+		}
+		return r.removeOwned(cr, &rbacv1.Role{}, "role")
+	}
+
+	labels := r.Labels(cr)
+
+	role := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: cr.Namespace,
+			Name:      cr.Name,
+			Labels:    labels,
+		},
+		Rules: cr.Spec.ServiceAccount.Rules,
+	}
+	if err := controllerutil.SetControllerReference(cr, role, r.scheme); err != nil {
+		return err
+	}
+	if err := utils.ApplyResource(r.client, r.scheme, role, &rbacv1.Role{}, ""); err != nil {
+		return err
+	}
+
+	roleBinding := &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: cr.Namespace,
+			Name:      cr.Name,
+			Labels:    labels,
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     role.Name,
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      serviceAccount.Name,
+				Namespace: cr.Namespace,
+			},
+		},
+	}
+	if err := controllerutil.SetControllerReference(cr, roleBinding, r.scheme); err != nil {
+		return err
+	}
+
+	return utils.ApplyResource(r.client, r.scheme, roleBinding, &rbacv1.RoleBinding{}, "")
+}
+
 func (r *ReconcileSonarQube) findServiceAccount(cr *sonarsourcev1alpha1.SonarQube) (*corev1.ServiceAccount, error) {
 	newServiceAccount, err := r.newServiceAccount(cr)
 	if err != nil {
@@ -32,7 +107,72 @@ func (r *ReconcileSonarQube) findServiceAccount(cr *sonarsourcev1alpha1.SonarQub
 
 	foundServiceAccount := &corev1.ServiceAccount{}
 
-	return foundServiceAccount, utils.CreateResourceIfNotFound(r.client, newServiceAccount, foundServiceAccount)
+	message, err := r.verifyServiceAccount(newServiceAccount)
+	if err != nil {
+		return foundServiceAccount, err
+	}
+
+	return foundServiceAccount, utils.ApplyResource(r.client, r.scheme, newServiceAccount, foundServiceAccount, message)
+}
+
+// getServiceAccount returns the existing ServiceAccount in spec, it is used as it is
+func (r *ReconcileSonarQube) getServiceAccount(cr *sonarsourcev1alpha1.SonarQube) (*corev1.ServiceAccount, error) {
+	if spec := cr.Spec.ServiceAccount; len(spec.Annotations) > 0 || len(spec.ImagePullSecrets) > 0 {
+		return nil, &utils.Error{
+			Reason:  utils.ErrorReasonSpecInvalid,
+			Message: "annotations and image pull secrets are only set on service accounts created by the operator",
+		}
+	}
+
+	foundServiceAccount := &corev1.ServiceAccount{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: serviceAccountName(cr), Namespace: cr.Namespace}, foundServiceAccount)
+	if err != nil && errors.IsNotFound(err) {
+		return foundServiceAccount, &utils.Error{
+			Reason:  utils.ErrorReasonResourceWaiting,
+			Message: fmt.Sprintf("waiting for service account %s", serviceAccountName(cr)),
+		}
+	}
+
+	return foundServiceAccount, err
+}
+
+// verifyServiceAccount compares the desired service account with the service account in the cluster
+// Returns: message summarizing the changed fields, Error
+// Message is empty when the service account does not exist or nothing changed
+func (r *ReconcileSonarQube) verifyServiceAccount(newServiceAccount *corev1.ServiceAccount) (string, error) {
+	serviceAccount := &corev1.ServiceAccount{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: newServiceAccount.Name, Namespace: newServiceAccount.Namespace}, serviceAccount)
+	if err != nil && errors.IsNotFound(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	var changed []string
+	// Labels and annotations set by others are kept, only the ones of the operator are compared
+	subset := func(field string, actual, desired map[string]string) {
+		for k, v := range desired {
+			if value, ok := actual[k]; !ok || value != v {
+				changed = append(changed, field)
+				return
+			}
+		}
+	}
+
+	subset("labels", serviceAccount.Labels, newServiceAccount.Labels)
+	subset("annotations", serviceAccount.Annotations, newServiceAccount.Annotations)
+	if !equality.Semantic.DeepEqual(serviceAccount.ImagePullSecrets, newServiceAccount.ImagePullSecrets) {
+		changed = append(changed, "image pull secrets")
+	}
+	if newServiceAccount.AutomountServiceAccountToken != nil && !equality.Semantic.DeepEqual(serviceAccount.AutomountServiceAccountToken, newServiceAccount.AutomountServiceAccountToken) {
+		changed = append(changed, "automount token")
+	}
+
+	if len(changed) == 0 {
+		return "", nil
+	}
+
+	return fmt.Sprintf("updated serviceaccount %s", strings.Join(changed, ", ")), nil
 }
 
 func (r *ReconcileSonarQube) newServiceAccount(cr *sonarsourcev1alpha1.SonarQube) (*corev1.ServiceAccount, error) {
@@ -41,15 +181,15 @@ func (r *ReconcileSonarQube) newServiceAccount(cr *sonarsourcev1alpha1.SonarQube
 	dep := &corev1.ServiceAccount{
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace: cr.Namespace,
-			Name:      cr.Name,
+			Name:      serviceAccountName(cr),
 			Labels:    labels,
 		},
 	}
 
-	if cr.Spec.ServiceAccount != nil {
-		dep.Name = *cr.Spec.ServiceAccount
-	} else {
-		dep.Name = cr.Name
+	if spec := cr.Spec.ServiceAccount; spec != nil {
+		dep.Annotations = spec.Annotations
+		dep.ImagePullSecrets = spec.ImagePullSecrets
+		dep.AutomountServiceAccountToken = spec.AutomountToken
 	}
 
 	if err := controllerutil.SetControllerReference(cr, dep, r.scheme); err != nil {
@@ -58,3 +198,24 @@ func (r *ReconcileSonarQube) newServiceAccount(cr *sonarsourcev1alpha1.SonarQube
 
 	return dep, nil
 }
+
+// serviceAccountName returns the name of the service account in spec or the name of SonarQube
+func serviceAccountName(cr *sonarsourcev1alpha1.SonarQube) string {
+	if cr.Spec.ServiceAccount == nil || cr.Spec.ServiceAccount.Name == nil {
+		return cr.Name
+	}
+	return *cr.Spec.ServiceAccount.Name
+}
+
+// createServiceAccount checks the service account is created by the operator
+func createServiceAccount(cr *sonarsourcev1alpha1.SonarQube) bool {
+	return cr.Spec.ServiceAccount == nil || cr.Spec.ServiceAccount.Create == nil || *cr.Spec.ServiceAccount.Create
+}
+
+// automountToken returns the automount of the service account token in spec, nil when the service account decides
+func automountToken(cr *sonarsourcev1alpha1.SonarQube) *bool {
+	if cr.Spec.ServiceAccount == nil {
+		return nil
+	}
+	return cr.Spec.ServiceAccount.AutomountToken
+}
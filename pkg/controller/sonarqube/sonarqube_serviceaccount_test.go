@@ -2,16 +2,17 @@ package sonarqube
 
 import (
 	"context"
+	"encoding/json"
 	"github.com/jlfowle/sonarqube-operator/pkg/api_client"
 	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
 	"github.com/jlfowle/sonarqube-operator/pkg/utils"
 	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
-	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
 	"testing"
 )
@@ -44,7 +45,7 @@ func TestSonarQubeServiceAccount(t *testing.T) {
 	s := scheme.Scheme
 	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
 	// Create a fake client to mock API calls.
-	cl := fake.NewFakeClientWithScheme(s, objs...)
+	cl := newFakeClient(s, objs...)
 	// Create a ReconcileSonarQube object with the scheme and fake client.
 	apiMock := &api_client.APIClientMock{}
 	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: apiMock}
@@ -66,3 +67,284 @@ func TestSonarQubeServiceAccount(t *testing.T) {
 		t.Error("reconcileServiceAccount: returned error even though ServiceAccount is in expected state")
 	}
 }
+
+// TestSonarQubeServiceAccountSpec runs ReconcileSonarQube.ReconcileServiceAccount() against a
+// fake client with a service account in spec drifting from its expected state
+func TestSonarQubeServiceAccountSpec(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name      = "sonarqube-operator"
+		namespace = "sonarqube"
+		account   = "sonarqube"
+	)
+
+	// A SonarQube resource with a service account in spec.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{
+			ServiceAccount: &sonarsourcev1alpha1.ServiceAccount{
+				Name:             &account,
+				Annotations:      map[string]string{"iam.gke.io/gcp-service-account": "sonarqube@project.iam.gserviceaccount.com"},
+				ImagePullSecrets: []corev1.LocalObjectReference{{Name: "registry"}},
+				AutomountToken:   &[]bool{false}[0],
+			},
+		},
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
+	// Create a fake client to mock API calls.
+	cl := newFakeClient(s, objs...)
+	// Create a ReconcileSonarQube object with the scheme and fake client.
+	apiMock := &api_client.APIClientMock{}
+	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: apiMock}
+
+	_, err := r.ReconcileServiceAccount(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceCreate {
+		t.Errorf("reconcileServiceAccount: resource created error not thrown when creating ServiceAccount (%v)", err)
+	}
+	serviceAccount := &corev1.ServiceAccount{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: account, Namespace: namespace}, serviceAccount)
+	if err != nil {
+		t.Fatalf("reconcileServiceAccount: ServiceAccount %s not created (%v)", account, err)
+	}
+	if serviceAccount.Annotations["iam.gke.io/gcp-service-account"] == "" || len(serviceAccount.ImagePullSecrets) != 1 ||
+		serviceAccount.AutomountServiceAccountToken == nil || *serviceAccount.AutomountServiceAccountToken {
+		t.Error("reconcileServiceAccount: ServiceAccount created without the settings in spec")
+	}
+
+	// Annotations of others are kept, changed annotations of the operator are restored
+	serviceAccount.Annotations["iam.gke.io/gcp-service-account"] = "other@project.iam.gserviceaccount.com"
+	serviceAccount.Annotations["example.com/owner"] = "team"
+	if err := r.client.Update(context.TODO(), serviceAccount); err != nil {
+		t.Fatalf("update serviceaccount: (%v)", err)
+	}
+
+	_, err = r.ReconcileServiceAccount(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceUpdate || err.(*utils.Error).Message != "updated serviceaccount annotations" {
+		t.Errorf("reconcileServiceAccount: drift of annotations not reported (%v)", err)
+	}
+
+	_, err = r.ReconcileServiceAccount(sonarqube)
+	if err != nil {
+		t.Errorf("reconcileServiceAccount: returned error even though ServiceAccount is in expected state (%v)", err)
+	}
+}
+
+// TestSonarQubeServiceAccountExisting runs ReconcileSonarQube.ReconcileServiceAccount() against a
+// fake client with an existing service account which isn't created by the operator
+func TestSonarQubeServiceAccountExisting(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name      = "sonarqube-operator"
+		namespace = "sonarqube"
+		account   = "existing"
+	)
+
+	// A SonarQube resource using an existing service account.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{
+			ServiceAccount: &sonarsourcev1alpha1.ServiceAccount{
+				Name:   &account,
+				Create: &[]bool{false}[0],
+			},
+		},
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
+	// Create a fake client to mock API calls.
+	cl := newFakeClient(s, objs...)
+	// Create a ReconcileSonarQube object with the scheme and fake client.
+	apiMock := &api_client.APIClientMock{}
+	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: apiMock}
+
+	_, err := r.ReconcileServiceAccount(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceWaiting {
+		t.Errorf("reconcileServiceAccount: resource waiting error not thrown when ServiceAccount is missing (%v)", err)
+	}
+	serviceAccount := &corev1.ServiceAccount{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: account, Namespace: namespace}, serviceAccount)
+	if !errors.IsNotFound(err) {
+		t.Errorf("reconcileServiceAccount: existing ServiceAccount created by the operator (%v)", err)
+	}
+
+	serviceAccount = &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      account,
+			Namespace: namespace,
+		},
+	}
+	if err := r.client.Create(context.TODO(), serviceAccount); err != nil {
+		t.Fatalf("create serviceaccount: (%v)", err)
+	}
+
+	serviceAccount, err = r.ReconcileServiceAccount(sonarqube)
+	if err != nil {
+		t.Errorf("reconcileServiceAccount: returned error even though ServiceAccount exists (%v)", err)
+	}
+	if serviceAccount.Name != account || metav1.GetControllerOf(serviceAccount) != nil {
+		t.Error("reconcileServiceAccount: existing ServiceAccount not used as it is")
+	}
+
+	sonarqube.Spec.ServiceAccount.Annotations = map[string]string{"example.com/owner": "team"}
+	_, err = r.ReconcileServiceAccount(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonSpecInvalid {
+		t.Errorf("reconcileServiceAccount: spec invalid error not thrown for annotations of an existing ServiceAccount (%v)", err)
+	}
+}
+
+// TestSonarQubeServiceAccountRBAC runs ReconcileSonarQube.ReconcileServiceAccount() against a
+// fake client with rules bound to an existing service account
+func TestSonarQubeServiceAccountRBAC(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name      = "sonarqube-operator"
+		namespace = "sonarqube"
+		account   = "existing"
+		rules     = []rbacv1.PolicyRule{
+			{
+				APIGroups: []string{""},
+				Resources: []string{"configmaps"},
+				Verbs:     []string{"get", "list"},
+			},
+		}
+	)
+
+	// A SonarQube resource binding rules to an existing service account.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{
+			ServiceAccount: &sonarsourcev1alpha1.ServiceAccount{
+				Name:   &account,
+				Create: &[]bool{false}[0],
+				Rules:  rules,
+			},
+		},
+	}
+	serviceAccount := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      account,
+			Namespace: namespace,
+		},
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+		serviceAccount,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
+	// Create a fake client to mock API calls.
+	cl := newFakeClient(s, objs...)
+	// Create a ReconcileSonarQube object with the scheme and fake client.
+	apiMock := &api_client.APIClientMock{}
+	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: apiMock}
+
+	_, err := r.ReconcileServiceAccount(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceCreate {
+		t.Errorf("reconcileServiceAccount: resource create error not thrown when creating Role (%v)", err)
+	}
+	_, err = r.ReconcileServiceAccount(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceCreate {
+		t.Errorf("reconcileServiceAccount: resource create error not thrown when creating RoleBinding (%v)", err)
+	}
+	_, err = r.ReconcileServiceAccount(sonarqube)
+	if err != nil {
+		t.Errorf("reconcileServiceAccount: returned error even though Role and RoleBinding exist (%v)", err)
+	}
+
+	role := &rbacv1.Role{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, role)
+	if err != nil {
+		t.Fatalf("get role: (%v)", err)
+	}
+	if len(role.Rules) != 1 || role.Rules[0].Resources[0] != "configmaps" {
+		t.Errorf("reconcileServiceAccount: rules in spec not set on Role (%v)", role.Rules)
+	}
+	roleBinding := &rbacv1.RoleBinding{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, roleBinding)
+	if err != nil {
+		t.Fatalf("get rolebinding: (%v)", err)
+	}
+	if roleBinding.RoleRef.Name != role.Name || len(roleBinding.Subjects) != 1 || roleBinding.Subjects[0].Name != account {
+		t.Errorf("reconcileServiceAccount: Role not bound to the service account (%v)", roleBinding)
+	}
+
+	sonarqube.Spec.ServiceAccount.Rules = nil
+	_, err = r.ReconcileServiceAccount(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceUpdate {
+		t.Errorf("reconcileServiceAccount: resource update error not thrown when removing RoleBinding (%v)", err)
+	}
+	_, err = r.ReconcileServiceAccount(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceUpdate {
+		t.Errorf("reconcileServiceAccount: resource update error not thrown when removing Role (%v)", err)
+	}
+	_, err = r.ReconcileServiceAccount(sonarqube)
+	if err != nil {
+		t.Errorf("reconcileServiceAccount: returned error after Role and RoleBinding were removed (%v)", err)
+	}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, &rbacv1.Role{})
+	if !errors.IsNotFound(err) {
+		t.Errorf("reconcileServiceAccount: Role not removed without rules (%v)", err)
+	}
+}
+
+// TestSonarQubeServiceAccountName decodes SonarQube resources with the service account
+// set as a bare name before it became an object
+func TestSonarQubeServiceAccountName(t *testing.T) {
+	sonarqube := &sonarsourcev1alpha1.SonarQube{}
+	err := json.Unmarshal([]byte(`{"metadata":{"name":"sonarqube"},"spec":{"serviceAccount":"sonarqube-sa"}}`), sonarqube)
+	if err != nil {
+		t.Fatalf("unmarshal sonarqube: (%v)", err)
+	}
+	if serviceAccountName(sonarqube) != "sonarqube-sa" || !createServiceAccount(sonarqube) {
+		t.Errorf("unmarshal sonarqube: bare name not used as the created service account (%v)", sonarqube.Spec.ServiceAccount)
+	}
+
+	sonarqube = &sonarsourcev1alpha1.SonarQube{}
+	err = json.Unmarshal([]byte(`{"metadata":{"name":"sonarqube"},"spec":{"serviceAccount":""}}`), sonarqube)
+	if err != nil {
+		t.Fatalf("unmarshal sonarqube: (%v)", err)
+	}
+	if serviceAccountName(sonarqube) != "sonarqube" {
+		t.Errorf("unmarshal sonarqube: empty name not defaulted %s", serviceAccountName(sonarqube))
+	}
+
+	sonarqube = &sonarsourcev1alpha1.SonarQube{}
+	err = json.Unmarshal([]byte(`{"metadata":{"name":"sonarqube"},"spec":{"serviceAccount":{"name":"existing","create":false}}}`), sonarqube)
+	if err != nil {
+		t.Fatalf("unmarshal sonarqube: (%v)", err)
+	}
+	if serviceAccountName(sonarqube) != "existing" || createServiceAccount(sonarqube) {
+		t.Errorf("unmarshal sonarqube: service account object not decoded (%v)", sonarqube.Spec.ServiceAccount)
+	}
+}
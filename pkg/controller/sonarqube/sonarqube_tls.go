@@ -0,0 +1,218 @@
+package sonarqube
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	DefaultCAKey  string = "ca.crt"
+	VolumePathTLS string = "/etc/tls"
+
+	// TLSProxyPort is the port the TLS proxy sidecar serves https on, SonarQube only listens on localhost behind it
+	TLSProxyPort     int32  = 9443
+	TLSProxyPortName string = "https"
+)
+
+// getTLSVolume returns the volume of the TLS secret SonarQube pods serve https with, Volume is nil when pods serve http
+// Errors:
+//   ErrorReasonSpecInvalid: returned when the TLS secret doesn't exist or is missing the certificate or key
+//   ErrorReasonResourceUpdate: returned when the TLS secret was annotated to be watched
+//   ErrorReasonUnknown: returned when unhandled error from client occurs
+func (r *ReconcileSonarQube) getTLSVolume(cr *sonarsourcev1alpha1.SonarQube) (*corev1.Volume, error) {
+	if !servesTLS(cr) {
+		return nil, nil
+	}
+
+	secret := &corev1.Secret{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: *cr.Spec.TLS.Secret, Namespace: cr.Namespace}, secret)
+	if err != nil && errors.IsNotFound(err) {
+		return nil, &utils.Error{
+			Reason:  utils.ErrorReasonSpecInvalid,
+			Message: fmt.Sprintf("tls secret %s doesn't exist", *cr.Spec.TLS.Secret),
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !utils.IsOwner(cr, secret) {
+		if err := r.watchSecret(cr, secret, sonarsourcev1alpha1.SecretAnnotation); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, key := range []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey} {
+		if _, ok := secret.Data[key]; !ok {
+			return nil, &utils.Error{
+				Reason:  utils.ErrorReasonSpecInvalid,
+				Message: fmt.Sprintf("tls secret %s must contain %s", secret.Name, key),
+			}
+		}
+	}
+
+	// The whole Secret is mounted rather than sub paths so renewed certificates reach running pods
+	return &corev1.Volume{
+		Name: "tls",
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName:  secret.Name,
+				DefaultMode: &[]int32{corev1.SecretVolumeSourceDefaultMode}[0],
+			},
+		},
+	}, nil
+}
+
+// newTLSProxyContainer returns the sidecar terminating TLS with the certificate in tlsVolume and proxying to the
+// web port of SonarQube on localhost, SonarQube has no https listener of its own
+func newTLSProxyContainer(tlsVolume *corev1.Volume) *corev1.Container {
+	return &corev1.Container{
+		Name:  "tls-proxy",
+		Image: utils.GetTLSProxyImage(),
+		Args: []string{
+			"server",
+			"--listen", fmt.Sprintf("0.0.0.0:%v", TLSProxyPort),
+			"--target", fmt.Sprintf("127.0.0.1:%v", sonarsourcev1alpha1.ApplicationWebPort),
+			"--cert", fmt.Sprintf("%s/%s", VolumePathTLS, corev1.TLSCertKey),
+			"--key", fmt.Sprintf("%s/%s", VolumePathTLS, corev1.TLSPrivateKeyKey),
+			"--disable-authentication",
+		},
+		Ports: []corev1.ContainerPort{
+			{
+				Name:          TLSProxyPortName,
+				ContainerPort: TLSProxyPort,
+				Protocol:      corev1.ProtocolTCP,
+			},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      tlsVolume.Name,
+				MountPath: VolumePathTLS,
+				ReadOnly:  true,
+			},
+		},
+	}
+}
+
+// webPort returns the port of pods the web port of the service targets, the TLS proxy sidecar when pods serve https
+func webPort(cr *sonarsourcev1alpha1.SonarQube) int32 {
+	if servesTLS(cr) {
+		return TLSProxyPort
+	}
+	return sonarsourcev1alpha1.ApplicationWebPort
+}
+
+// getTLSConfig returns the tls config the API client verifies https endpoints of SonarQube with,
+// Config is nil when the system roots are trusted
+// Errors:
+//   ErrorReasonSpecInvalid: returned when the CA bundle is invalid, see getCABundle
+//   ErrorReasonResourceUpdate: returned when the CA secret was annotated to be watched
+//   ErrorReasonUnknown: returned when unhandled error from client occurs
+func (r *ReconcileSonarQube) getTLSConfig(cr *sonarsourcev1alpha1.SonarQube) (*tls.Config, error) {
+	bundle, err := r.getCABundle(cr)
+	if err != nil {
+		return nil, err
+	}
+
+	if cr.Spec.TLS != nil && cr.Spec.TLS.InsecureSkipVerify != nil && *cr.Spec.TLS.InsecureSkipVerify {
+		return &tls.Config{InsecureSkipVerify: true}, nil
+	}
+
+	if bundle == nil {
+		return nil, nil
+	}
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(bundle)
+	return &tls.Config{RootCAs: pool}, nil
+}
+
+// getCABundle returns the pem encoded CA bundle in the ConfigMap or Secret in spec, nil when none is set
+// Errors:
+//   ErrorReasonSpecInvalid: returned when both a CA ConfigMap and Secret are set, or the CA bundle is missing or holds no certificate
+//   ErrorReasonResourceUpdate: returned when the CA secret was annotated to be watched
+//   ErrorReasonUnknown: returned when unhandled error from client occurs
+func (r *ReconcileSonarQube) getCABundle(cr *sonarsourcev1alpha1.SonarQube) ([]byte, error) {
+	spec := cr.Spec.TLS
+	if spec == nil || (spec.CAConfigMap == nil && spec.CASecret == nil) {
+		return nil, nil
+	}
+
+	if spec.CAConfigMap != nil && spec.CASecret != nil {
+		return nil, &utils.Error{
+			Reason:  utils.ErrorReasonSpecInvalid,
+			Message: "ca bundle must be either in a config map or a secret",
+		}
+	}
+
+	key := DefaultCAKey
+	if spec.CAKey != nil {
+		key = *spec.CAKey
+	}
+
+	var bundle []byte
+	if spec.CAConfigMap != nil {
+		configMap := &corev1.ConfigMap{}
+		err := r.client.Get(context.TODO(), types.NamespacedName{Name: *spec.CAConfigMap, Namespace: cr.Namespace}, configMap)
+		if err != nil && errors.IsNotFound(err) {
+			return nil, &utils.Error{
+				Reason:  utils.ErrorReasonSpecInvalid,
+				Message: fmt.Sprintf("ca config map %s doesn't exist", *spec.CAConfigMap),
+			}
+		} else if err != nil {
+			return nil, err
+		}
+		bundle = []byte(configMap.Data[key])
+	} else {
+		secret := &corev1.Secret{}
+		err := r.client.Get(context.TODO(), types.NamespacedName{Name: *spec.CASecret, Namespace: cr.Namespace}, secret)
+		if err != nil && errors.IsNotFound(err) {
+			return nil, &utils.Error{
+				Reason:  utils.ErrorReasonSpecInvalid,
+				Message: fmt.Sprintf("ca secret %s doesn't exist", *spec.CASecret),
+			}
+		} else if err != nil {
+			return nil, err
+		}
+		if !utils.IsOwner(cr, secret) {
+			if err := r.watchSecret(cr, secret, sonarsourcev1alpha1.SecretAnnotation); err != nil {
+				return nil, err
+			}
+		}
+		bundle = secret.Data[key]
+	}
+
+	if !x509.NewCertPool().AppendCertsFromPEM(bundle) {
+		return nil, &utils.Error{
+			Reason:  utils.ErrorReasonSpecInvalid,
+			Message: fmt.Sprintf("ca bundle %s must contain pem encoded certificates", key),
+		}
+	}
+
+	return bundle, nil
+}
+
+// serverURL returns the url the operator calls the SonarQube API at, the external url when set.
+// Pods serving https are called through the TLS proxy at the DNS name of the service their certificate is issued for,
+// under the web context of SonarQube
+func serverURL(cr *sonarsourcev1alpha1.SonarQube, service *corev1.Service) string {
+	if cr.Spec.ExternalURL != nil {
+		return *cr.Spec.ExternalURL
+	}
+	if servesTLS(cr) {
+		return fmt.Sprintf("https://%s.%s.svc:%v%s", service.Name, service.Namespace, service.Spec.Ports[0].Port, webContext(cr))
+	}
+	return fmt.Sprintf("http://%s:%v%s", service.Spec.ClusterIP, service.Spec.Ports[0].Port, webContext(cr))
+}
+
+// servesTLS checks SonarQube pods serve https through the TLS proxy with a TLS secret in spec
+func servesTLS(cr *sonarsourcev1alpha1.SonarQube) bool {
+	return cr.Spec.TLS != nil && cr.Spec.TLS.Secret != nil
+}
@@ -0,0 +1,267 @@
+package sonarqube
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jlfowle/sonarqube-operator/pkg/api_client"
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+// TestSonarQubeTLSConfig runs ReconcileSonarQube.getTLSConfig() against a
+// fake client with CA bundles in a ConfigMap and a Secret
+func TestSonarQubeTLSConfig(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name      = "sonarqube-operator"
+		namespace = "sonarqube"
+	)
+
+	// The API client must trust the certificate of a SonarQube served over https
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("pong"))
+	}))
+	defer server.Close()
+	bundle := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+
+	// A SonarQube resource with metadata and spec.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{
+			TLS: &sonarsourcev1alpha1.TLS{
+				CAConfigMap: &[]string{"ca"}[0],
+			},
+		},
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ca",
+			Namespace: namespace,
+		},
+		Data: map[string]string{
+			DefaultCAKey: bundle,
+			"invalid":    "not a certificate",
+		},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ca",
+			Namespace: namespace,
+		},
+		Data: map[string][]byte{
+			"bundle.pem": []byte(bundle),
+		},
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+		configMap,
+		secret,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
+	// Create a fake client to mock API calls.
+	cl := newFakeClient(s, objs...)
+	// Create a ReconcileSonarQube object with the scheme and fake client.
+	apiMock := &api_client.APIClientMock{}
+	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: apiMock}
+
+	tlsConfig, err := r.getTLSConfig(sonarqube)
+	if err != nil {
+		t.Fatalf("getTLSConfig: (%v)", err)
+	}
+	if err := (&api_client.APIClient{}).New(server.URL, nil, tlsConfig).Ping(); err != nil {
+		t.Errorf("getTLSConfig: CA bundle in config map not trusted (%v)", err)
+	}
+
+	// Referenced secrets are annotated to be watched before they are used
+	sonarqube.Spec.TLS = &sonarsourcev1alpha1.TLS{
+		CASecret: &[]string{"ca"}[0],
+		CAKey:    &[]string{"bundle.pem"}[0],
+	}
+	_, err = r.getTLSConfig(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceUpdate {
+		t.Errorf("getTLSConfig: resource update error not thrown when watching CA secret (%v)", err)
+	}
+	tlsConfig, err = r.getTLSConfig(sonarqube)
+	if err != nil {
+		t.Fatalf("getTLSConfig: (%v)", err)
+	}
+	if err := (&api_client.APIClient{}).New(server.URL, nil, tlsConfig).Ping(); err != nil {
+		t.Errorf("getTLSConfig: CA bundle in secret not trusted (%v)", err)
+	}
+
+	sonarqube.Spec.TLS = &sonarsourcev1alpha1.TLS{
+		InsecureSkipVerify: &[]bool{true}[0],
+	}
+	tlsConfig, err = r.getTLSConfig(sonarqube)
+	if err != nil || tlsConfig == nil || !tlsConfig.InsecureSkipVerify {
+		t.Errorf("getTLSConfig: verification not skipped (%v)", err)
+	}
+
+	invalid := []*sonarsourcev1alpha1.TLS{
+		{CAConfigMap: &[]string{"ca"}[0], CASecret: &[]string{"ca"}[0]},
+		{CAConfigMap: &[]string{"missing"}[0]},
+		{CAConfigMap: &[]string{"ca"}[0], CAKey: &[]string{"invalid"}[0]},
+		{CASecret: &[]string{"ca"}[0]},
+	}
+	for _, spec := range invalid {
+		sonarqube.Spec.TLS = spec
+		_, err = r.getTLSConfig(sonarqube)
+		if utils.ReasonForError(err) != utils.ErrorReasonSpecInvalid {
+			t.Errorf("getTLSConfig: spec invalid error not thrown for CA bundle %v (%v)", spec, err)
+		}
+	}
+}
+
+// TestSonarQubeTLSDeployment runs ReconcileSonarQube.ReconcileDeployment() against a
+// fake client with pods serving https
+func TestSonarQubeTLSDeployment(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name      = "sonarqube-operator"
+		namespace = "sonarqube"
+	)
+
+	// A SonarQube resource with metadata and spec.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{
+			TLS: &sonarsourcev1alpha1.TLS{
+				Secret: &[]string{"sonarqube-tls"}[0],
+			},
+		},
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
+	// Create a fake client to mock API calls.
+	cl := newFakeClient(s, objs...)
+	// Create a ReconcileSonarQube object with the scheme and fake client.
+	apiMock := &api_client.APIClientMock{}
+	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: apiMock}
+
+	// Take care of dependencies, the deployment can't be created without the TLS secret
+	var err error
+	for i := 0; i < 10; i++ {
+		_, err = r.ReconcileDeployment(sonarqube)
+		if err == nil || utils.ReasonForError(err) == utils.ErrorReasonSpecInvalid || utils.ReasonForError(err) == utils.ErrorReasonUnknown {
+			break
+		}
+	}
+	if utils.ReasonForError(err) != utils.ErrorReasonSpecInvalid {
+		t.Errorf("reconcileDeployment: spec invalid error not thrown for missing TLS secret (%v)", err)
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "sonarqube-tls",
+			Namespace: namespace,
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       []byte("cert"),
+			corev1.TLSPrivateKeyKey: []byte("key"),
+		},
+	}
+	err = r.client.Create(context.TODO(), secret)
+	if err != nil {
+		t.Fatalf("reconcileDeployment: (%v)", err)
+	}
+
+	for {
+		_, err = r.ReconcileDeployment(sonarqube)
+		if err != nil && (utils.ReasonForError(err) == utils.ErrorReasonUnknown || utils.ReasonForError(err) == utils.ErrorReasonSpecInvalid) {
+			t.Fatalf("reconcileDeployment: (%v)", err)
+		} else if err == nil {
+			break
+		}
+	}
+
+	deployment := &appsv1.Deployment{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: sonarqube.Name, Namespace: sonarqube.Namespace}, deployment)
+	if err != nil {
+		t.Fatalf("reconcileDeployment: (%v)", err)
+	}
+	podSpec := deployment.Spec.Template.Spec
+	var mounted bool
+	for _, volume := range podSpec.Volumes {
+		if volume.Secret != nil && volume.Secret.SecretName == secret.Name {
+			mounted = true
+		}
+	}
+	if !mounted {
+		t.Error("reconcileDeployment: TLS secret not mounted in pods")
+	}
+	if len(podSpec.Containers) != 2 || podSpec.Containers[1].Name != "tls-proxy" {
+		t.Fatalf("reconcileDeployment: TLS proxy sidecar not added to pods (%v)", podSpec.Containers)
+	}
+	proxy := podSpec.Containers[1]
+	if len(proxy.VolumeMounts) != 1 || proxy.VolumeMounts[0].MountPath != VolumePathTLS {
+		t.Error("reconcileDeployment: TLS secret not mounted in TLS proxy")
+	}
+	for _, mount := range podSpec.Containers[0].VolumeMounts {
+		if mount.MountPath == VolumePathTLS {
+			t.Error("reconcileDeployment: TLS secret mounted in SonarQube")
+		}
+	}
+	if !utils.ContainsString(proxy.Args, "127.0.0.1:9000") || proxy.Ports[0].ContainerPort != TLSProxyPort {
+		t.Errorf("reconcileDeployment: TLS proxy doesn't proxy from %v to the web port (%v)", TLSProxyPort, proxy.Args)
+	}
+	var localhost bool
+	for _, env := range podSpec.Containers[0].Env {
+		if env.Name == "SONAR_WEB_HOST" && env.Value == "127.0.0.1" {
+			localhost = true
+		}
+	}
+	if !localhost {
+		t.Error("reconcileDeployment: SonarQube web port not bound to localhost behind the TLS proxy")
+	}
+	readiness := podSpec.Containers[0].ReadinessProbe.HTTPGet
+	if readiness.Scheme != corev1.URISchemeHTTPS || readiness.Port.IntVal != TLSProxyPort {
+		t.Errorf("reconcileDeployment: readiness probe %s on port %v doesn't use the TLS proxy", readiness.Scheme, readiness.Port.IntVal)
+	}
+	if port := podSpec.Containers[0].LivenessProbe.TCPSocket.Port.IntVal; port != TLSProxyPort {
+		t.Errorf("reconcileDeployment: liveness probe port %v isn't the TLS proxy", port)
+	}
+
+	service := &corev1.Service{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: sonarqube.Name, Namespace: sonarqube.Namespace}, service)
+	if err != nil {
+		t.Fatalf("reconcileDeployment: (%v)", err)
+	}
+	if target := service.Spec.Ports[0].TargetPort; target.StrVal != TLSProxyPortName {
+		t.Errorf("reconcileService: web port targets %s instead of the TLS proxy", target.String())
+	}
+	if url := serverURL(sonarqube, service); url != "https://sonarqube-operator.sonarqube.svc:9000" {
+		t.Errorf("serverURL: %s isn't the https url of the service", url)
+	}
+}
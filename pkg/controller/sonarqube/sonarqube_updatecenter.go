@@ -0,0 +1,112 @@
+package sonarqube
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	DefaultUpdateCenterURL string = "https://update.sonarsource.org/update-center.properties"
+	DefaultUpdateCenterKey string = "update-center.properties"
+	VolumePathUpdateCenter string = "/opt/sonarqube/update-center"
+)
+
+// getUpdateCenter returns the url of the update center catalog used by SonarQube and the plugins init container.
+// A catalog in a ConfigMap is mounted in the pods and read through a file url, Volume is nil otherwise
+// Errors:
+//   ErrorReasonSpecInvalid: returned when the update center is not a url or ConfigMap or the ConfigMap is missing the catalog
+//   ErrorReasonResourceWaiting: returned when the ConfigMap doesn't exist yet
+//   ErrorReasonResourceUpdate: returned when the ConfigMap was annotated to be watched
+//   ErrorReasonUnknown: returned when unhandled error from client occurs
+func (r *ReconcileSonarQube) getUpdateCenter(cr *sonarsourcev1alpha1.SonarQube) (string, *corev1.Volume, error) {
+	updateCenter := cr.Spec.UpdateCenter
+	if updateCenter == nil {
+		return DefaultUpdateCenterURL, nil, nil
+	}
+
+	if (updateCenter.URL == nil) == (updateCenter.ConfigMap == nil) {
+		return "", nil, &utils.Error{
+			Reason:  utils.ErrorReasonSpecInvalid,
+			Message: "update center must have either a url or a config map",
+		}
+	}
+
+	if updateCenter.URL != nil {
+		return *updateCenter.URL, nil, nil
+	}
+
+	key := DefaultUpdateCenterKey
+	if updateCenter.Key != nil {
+		key = *updateCenter.Key
+	}
+
+	configMap := &corev1.ConfigMap{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: *updateCenter.ConfigMap, Namespace: cr.Namespace}, configMap)
+	if err != nil && errors.IsNotFound(err) {
+		return "", nil, &utils.Error{
+			Reason:  utils.ErrorReasonResourceWaiting,
+			Message: fmt.Sprintf("waiting for update center config map %s", *updateCenter.ConfigMap),
+		}
+	} else if err != nil {
+		return "", nil, err
+	}
+
+	if err := r.watchConfigMap(cr, configMap, sonarsourcev1alpha1.ConfigMapAnnotation); err != nil {
+		return "", nil, err
+	}
+
+	if _, ok := configMap.Data[key]; !ok {
+		return "", nil, &utils.Error{
+			Reason:  utils.ErrorReasonSpecInvalid,
+			Message: fmt.Sprintf("update center config map %s must contain %s", configMap.Name, key),
+		}
+	}
+
+	// The whole ConfigMap is mounted rather than a sub path so catalog updates reach running pods
+	volume := &corev1.Volume{
+		Name: "update-center",
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: configMap.Name},
+				Items: []corev1.KeyToPath{
+					{
+						Key:  key,
+						Path: DefaultUpdateCenterKey,
+					},
+				},
+				DefaultMode: &[]int32{corev1.ConfigMapVolumeSourceDefaultMode}[0],
+			},
+		},
+	}
+
+	return fmt.Sprintf("file://%s/%s", VolumePathUpdateCenter, DefaultUpdateCenterKey), volume, nil
+}
+
+// watchConfigMap annotates a config map that isn't owned by the SonarQube so changes to it requeue the SonarQube
+func (r *ReconcileSonarQube) watchConfigMap(cr *sonarsourcev1alpha1.SonarQube, configMap *corev1.ConfigMap, annotation string) error {
+	if utils.IsOwner(cr, configMap) {
+		return nil
+	}
+
+	annotations := configMap.GetAnnotations()
+	if val, ok := annotations[annotation]; ok && !utils.ContainsString(strings.Split(val, ","), cr.Name) {
+		annotations[annotation] = fmt.Sprintf("%s,%s", val, cr.Name)
+	} else if !ok {
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		annotations[annotation] = cr.Name
+	} else {
+		return nil
+	}
+
+	configMap.SetAnnotations(annotations)
+	return utils.UpdateResource(r.client, configMap, utils.ErrorReasonResourceUpdate, "updated config map annotation")
+}
@@ -0,0 +1,148 @@
+package sonarqube
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jlfowle/sonarqube-operator/pkg/api_client"
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+// TestSonarQubeUpdateCenter runs ReconcileSonarQube.getUpdateCenter() against a
+// fake client
+func TestSonarQubeUpdateCenter(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name      = "sonarqube-operator"
+		namespace = "sonarqube"
+	)
+
+	// A SonarQube resource with metadata and spec.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{
+			UpdateCenter: &sonarsourcev1alpha1.UpdateCenter{
+				ConfigMap: &[]string{"update-center"}[0],
+				Key:       &[]string{"catalog"}[0],
+			},
+		},
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
+	// Create a fake client to mock API calls.
+	cl := newFakeClient(s, objs...)
+	// Create a ReconcileSonarQube object with the scheme and fake client.
+	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: &api_client.APIClientMock{}}
+
+	_, _, err := r.getUpdateCenter(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceWaiting {
+		t.Errorf("getUpdateCenter: resource waiting error not thrown when config map doesn't exist (%v)", err)
+	}
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      "update-center",
+		},
+		Data: map[string]string{
+			DefaultUpdateCenterKey: "",
+		},
+	}
+	err = r.client.Create(context.TODO(), configMap)
+	if err != nil {
+		t.Fatalf("getUpdateCenter: (%v)", err)
+	}
+
+	// Referenced config maps are annotated to be watched before they are used
+	_, _, err = r.getUpdateCenter(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceUpdate {
+		t.Errorf("getUpdateCenter: resource update error not thrown when watching config map (%v)", err)
+	}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: configMap.Name, Namespace: namespace}, configMap)
+	if err != nil {
+		t.Fatalf("getUpdateCenter: (%v)", err)
+	}
+	if configMap.Annotations[sonarsourcev1alpha1.ConfigMapAnnotation] != sonarqube.Name {
+		t.Error("getUpdateCenter: config map not annotated with sonarqube")
+	}
+
+	_, _, err = r.getUpdateCenter(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonSpecInvalid {
+		t.Error("getUpdateCenter: spec invalid error not thrown when config map is missing the catalog")
+	}
+
+	configMap.Data["catalog"] = "java.versions=6.5\n"
+	err = r.client.Update(context.TODO(), configMap)
+	if err != nil {
+		t.Fatalf("getUpdateCenter: (%v)", err)
+	}
+
+	url, volume, err := r.getUpdateCenter(sonarqube)
+	if err != nil {
+		t.Fatalf("getUpdateCenter: (%v)", err)
+	}
+	if url != "file:///opt/sonarqube/update-center/update-center.properties" {
+		t.Errorf("getUpdateCenter: unexpected url %s", url)
+	}
+	if volume == nil || volume.ConfigMap.Name != "update-center" || volume.ConfigMap.Items[0].Key != "catalog" {
+		t.Error("getUpdateCenter: config map not mounted")
+	}
+
+	for {
+		_, err := r.ReconcilePasscode(sonarqube)
+		if err != nil && utils.ReasonForError(err) == utils.ErrorReasonUnknown {
+			t.Fatalf("reconcilePasscode: (%v)", err)
+		} else if err == nil {
+			break
+		}
+	}
+
+	// SonarQube and the plugins init container read the catalog from the mounted config map
+	template, err := r.newPodTemplate(sonarqube, sonarsourcev1alpha1.AIO, r.PodLabels(sonarqube), &corev1.ServiceAccount{}, &corev1.Secret{}, podVolumes(sonarqube, nil))
+	if err != nil {
+		t.Fatalf("newPodTemplate: (%v)", err)
+	}
+	env := make(map[string]string)
+	for _, e := range template.Spec.Containers[0].Env {
+		env[e.Name] = e.Value
+	}
+	if env["SONAR_UPDATECENTER_URL"] != url {
+		t.Errorf("newPodTemplate: sonar.updatecenter.url not set %s", env["SONAR_UPDATECENTER_URL"])
+	}
+	plugins := template.Spec.InitContainers[0]
+	if len(plugins.VolumeMounts) != 2 || plugins.VolumeMounts[1].MountPath != VolumePathUpdateCenter {
+		t.Error("newPodTemplate: catalog not mounted in plugins init container")
+	}
+
+	sonarqube.Spec.UpdateCenter = &sonarsourcev1alpha1.UpdateCenter{
+		URL: &[]string{"http://mirror.example.com/update-center.properties"}[0],
+	}
+	url, volume, err = r.getUpdateCenter(sonarqube)
+	if err != nil || url != "http://mirror.example.com/update-center.properties" || volume != nil {
+		t.Errorf("getUpdateCenter: mirror url not used %s (%v)", url, err)
+	}
+
+	sonarqube.Spec.UpdateCenter.ConfigMap = &[]string{"update-center"}[0]
+	_, _, err = r.getUpdateCenter(sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonSpecInvalid {
+		t.Error("getUpdateCenter: spec invalid error not thrown when both url and config map are set")
+	}
+}
@@ -0,0 +1,187 @@
+package sonarqube
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jlfowle/sonarqube-operator/pkg/api_client"
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	"github.com/operator-framework/operator-sdk/pkg/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// UpgradeTimeout is how long the server has to report the version of an upgrade before it is failed,
+	// database migrations of large instances take a while
+	UpgradeTimeout = 2 * time.Hour
+)
+
+// Reconciles automatic upgrades for SonarQube
+// Returns: Error
+// If Error is non-nil, an upgrade was started or is still in progress
+// Errors:
+//   ErrorReasonSpecInvalid: returned when automatic upgrades are enabled for an image pinned to a tag or digest
+//   ErrorReasonSpecUpdate: returned when spec version was updated to start an upgrade
+//   ErrorReasonServerWaiting: returned when server has not reported the upgraded version yet
+//   ErrorReasonUnknown: returned when unhandled error from client occurs
+func (r *ReconcileSonarQube) reconcileUpgrade(cr *sonarsourcev1alpha1.SonarQube, serverStatus *api_client.Status, upgrades *api_client.Upgrades) error {
+	// The image of a pinned tag or digest doesn't change with the version, upgrades would never complete
+	if updatesEnabled(cr) && imagePinned(cr) {
+		if upgradeInProgress(cr) {
+			r.failUpgrade(cr, "image pinned to a tag or digest while upgrading")
+		}
+		return &utils.Error{
+			Reason:  utils.ErrorReasonSpecInvalid,
+			Message: "automatic upgrades can't be enabled for an image pinned to a tag or digest",
+		}
+	}
+
+	if upgradeInProgress(cr) {
+		return r.verifyUpgrade(cr, serverStatus)
+	}
+
+	// The database may have been migrated by a failed upgrade so its version isn't rolled back,
+	// automatic upgrades are paused until the version in spec is changed
+	if last := cr.Status.Upgrades.Last; last != nil && last.Result == sonarsourcev1alpha1.UpgradeFailed && cr.Spec.Version != nil && *cr.Spec.Version == last.To {
+		return nil
+	}
+
+	target := selectUpgrade(cr, &serverStatus.Version, upgrades)
+	if target == nil {
+		return nil
+	}
+
+	if ok, err := r.verifyBackupAge(cr, target.Version.MajorMinorPatch()); !ok || err != nil {
+		return err
+	}
+
+	return r.startUpgrade(cr, serverStatus, target)
+}
+
+func (r *ReconcileSonarQube) startUpgrade(cr *sonarsourcev1alpha1.SonarQube, serverStatus *api_client.Status, target *api_client.Upgrade) error {
+	from := serverStatus.Version.MajorMinorPatch()
+	to := target.Version.MajorMinorPatch()
+	now := metav1.Now()
+
+	newStatus := cr.DeepCopy()
+	newStatus.Status.Upgrades.Last = &sonarsourcev1alpha1.UpgradeRecord{
+		From:      from,
+		To:        to,
+		Result:    sonarsourcev1alpha1.UpgradeInProgress,
+		StartTime: &now,
+	}
+	newStatus.Status.Conditions.SetCondition(status.Condition{
+		Type:    sonarsourcev1alpha1.ConditionUpgrading,
+		Status:  corev1.ConditionTrue,
+		Reason:  sonarsourcev1alpha1.ConditionUpgradeStarted,
+		Message: fmt.Sprintf("upgrading from %s to %s", from, to),
+	})
+	utils.UpdateStatus(r.client, newStatus, cr)
+
+	cr.Spec.Version = &to
+	return utils.UpdateResource(r.client, cr, utils.ErrorReasonSpecUpdate, fmt.Sprintf("upgrading to %s", to))
+}
+
+func (r *ReconcileSonarQube) verifyUpgrade(cr *sonarsourcev1alpha1.SonarQube, serverStatus *api_client.Status) error {
+	last := cr.Status.Upgrades.Last
+
+	if cr.Spec.Version == nil || *cr.Spec.Version != last.To {
+		r.failUpgrade(cr, "spec version changed while upgrading")
+		return nil
+	}
+
+	if serverStatus.Version.MajorMinorPatch() != last.To {
+		if last.StartTime != nil && time.Since(last.StartTime.Time) > UpgradeTimeout {
+			r.failUpgrade(cr, fmt.Sprintf("server didn't report version %s within %s", last.To, UpgradeTimeout))
+			return nil
+		}
+		return &utils.Error{
+			Reason:  utils.ErrorReasonServerWaiting,
+			Message: fmt.Sprintf("waiting for server to report version %s", last.To),
+		}
+	}
+
+	r.finishUpgrade(cr, sonarsourcev1alpha1.UpgradeSucceeded, sonarsourcev1alpha1.ConditionUpgradeSucceeded, fmt.Sprintf("upgraded from %s to %s", last.From, last.To))
+
+	return nil
+}
+
+func (r *ReconcileSonarQube) failUpgrade(cr *sonarsourcev1alpha1.SonarQube, message string) {
+	r.finishUpgrade(cr, sonarsourcev1alpha1.UpgradeFailed, sonarsourcev1alpha1.ConditionUpgradeFailed, message)
+}
+
+func (r *ReconcileSonarQube) finishUpgrade(cr *sonarsourcev1alpha1.SonarQube, result sonarsourcev1alpha1.UpgradeResult, reason status.ConditionReason, message string) {
+	now := metav1.Now()
+
+	newStatus := cr.DeepCopy()
+	newStatus.Status.Upgrades.Last.Result = result
+	newStatus.Status.Upgrades.Last.Message = message
+	newStatus.Status.Upgrades.Last.CompletionTime = &now
+	if to := newStatus.Status.Upgrades.Last.To; result == sonarsourcev1alpha1.UpgradeFailed && !utils.ContainsString(newStatus.Status.Upgrades.Failed, to) {
+		newStatus.Status.Upgrades.Failed = append(newStatus.Status.Upgrades.Failed, to)
+	}
+	newStatus.Status.Conditions.SetCondition(status.Condition{
+		Type:    sonarsourcev1alpha1.ConditionUpgrading,
+		Status:  corev1.ConditionFalse,
+		Reason:  reason,
+		Message: message,
+	})
+	utils.UpdateStatus(r.client, newStatus, cr)
+}
+
+// updatesEnabled checks minor or major versions are upgraded automatically
+func updatesEnabled(cr *sonarsourcev1alpha1.SonarQube) bool {
+	return (cr.Spec.UpdatesMinor != nil && *cr.Spec.UpdatesMinor) || (cr.Spec.UpdatesMajor != nil && *cr.Spec.UpdatesMajor)
+}
+
+// imagePinned checks the image is pinned to a tag or digest in spec rather than derived from the version
+func imagePinned(cr *sonarsourcev1alpha1.SonarQube) bool {
+	return cr.Spec.Image != nil && (cr.Spec.Image.Tag != nil || cr.Spec.Image.Digest != nil)
+}
+
+func upgradeInProgress(cr *sonarsourcev1alpha1.SonarQube) bool {
+	return cr.Status.Upgrades.Last != nil && cr.Status.Upgrades.Last.Result == sonarsourcev1alpha1.UpgradeInProgress
+}
+
+// upgradeFailed checks an automatic upgrade to version failed before
+func upgradeFailed(cr *sonarsourcev1alpha1.SonarQube, version string) bool {
+	if last := cr.Status.Upgrades.Last; last != nil && last.Result == sonarsourcev1alpha1.UpgradeFailed && last.To == version {
+		return true
+	}
+	return utils.ContainsString(cr.Status.Upgrades.Failed, version)
+}
+
+// selectUpgrade returns the newest upgrade without incompatible plugins that is allowed by
+// UpdatesMinor (same major version) and UpdatesMajor (newer major version)
+func selectUpgrade(cr *sonarsourcev1alpha1.SonarQube, current *api_client.SystemVersion, upgrades *api_client.Upgrades) *api_client.Upgrade {
+	minor := cr.Spec.UpdatesMinor != nil && *cr.Spec.UpdatesMinor
+	major := cr.Spec.UpdatesMajor != nil && *cr.Spec.UpdatesMajor
+	if !minor && !major {
+		return nil
+	}
+
+	var target *api_client.Upgrade
+	for i := range upgrades.Upgrades {
+		upgrade := &upgrades.Upgrades[i]
+		if len(upgrade.Plugins.Incompatible) > 0 || upgrade.Version.Compare(current) <= 0 {
+			continue
+		}
+		if upgrade.Version.Major == current.Major && !minor {
+			continue
+		}
+		if upgrade.Version.Major > current.Major && !major {
+			continue
+		}
+		// Don't retry a target that already failed
+		if upgradeFailed(cr, upgrade.Version.MajorMinorPatch()) {
+			continue
+		}
+		if target == nil || upgrade.Version.Compare(&target.Version) > 0 {
+			target = upgrade
+		}
+	}
+
+	return target
+}
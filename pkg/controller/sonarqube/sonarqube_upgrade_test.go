@@ -0,0 +1,326 @@
+package sonarqube
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jlfowle/sonarqube-operator/pkg/api_client"
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+func testUpgrades() *api_client.Upgrades {
+	return &api_client.Upgrades{
+		Upgrades: []api_client.Upgrade{
+			{Version: api_client.SystemVersion{Major: 8, Minor: 3, Patch: 1, Build: "1"}},
+			{Version: api_client.SystemVersion{Major: 8, Minor: 4, Patch: 0, Build: "2"}},
+			{
+				Version: api_client.SystemVersion{Major: 8, Minor: 5, Patch: 0, Build: "3"},
+				Plugins: api_client.Plugins{
+					Incompatible: []api_client.Plugin{{Key: "test"}},
+				},
+			},
+			{Version: api_client.SystemVersion{Major: 9, Minor: 0, Patch: 0, Build: "4"}},
+		},
+	}
+}
+
+// TestSonarQubeSelectUpgrade verifies the upgrade target allowed by UpdatesMinor and UpdatesMajor
+func TestSonarQubeSelectUpgrade(t *testing.T) {
+	current := &api_client.SystemVersion{Major: 8, Minor: 3, Patch: 0, Build: "0"}
+
+	tests := []struct {
+		minor, major bool
+		expected     string
+	}{
+		{false, false, ""},
+		{true, false, "8.4.0"},
+		{false, true, "9.0.0"},
+		{true, true, "9.0.0"},
+	}
+
+	for _, test := range tests {
+		sonarqube := &sonarsourcev1alpha1.SonarQube{
+			Spec: sonarsourcev1alpha1.SonarQubeSpec{
+				UpdatesMinor: &[]bool{test.minor}[0],
+				UpdatesMajor: &[]bool{test.major}[0],
+			},
+		}
+
+		var version string
+		if target := selectUpgrade(sonarqube, current, testUpgrades()); target != nil {
+			version = target.Version.MajorMinorPatch()
+		}
+		if version != test.expected {
+			t.Errorf("selectUpgrade: minor %v major %v selected %q expected %q", test.minor, test.major, version, test.expected)
+		}
+	}
+
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{
+			UpdatesMinor: &[]bool{true}[0],
+		},
+		Status: sonarsourcev1alpha1.SonarQubeStatus{
+			Upgrades: sonarsourcev1alpha1.Upgrades{
+				Last: &sonarsourcev1alpha1.UpgradeRecord{
+					From:   "8.3.0",
+					To:     "8.4.0",
+					Result: sonarsourcev1alpha1.UpgradeFailed,
+				},
+			},
+		},
+	}
+	if target := selectUpgrade(sonarqube, current, testUpgrades()); target == nil || target.Version.MajorMinorPatch() != "8.3.1" {
+		t.Error("selectUpgrade: failed upgrade target selected again")
+	}
+
+	// Failed targets are kept once another upgrade is recorded
+	sonarqube.Status.Upgrades.Last = &sonarsourcev1alpha1.UpgradeRecord{
+		From:   "8.2.0",
+		To:     "8.3.0",
+		Result: sonarsourcev1alpha1.UpgradeSucceeded,
+	}
+	sonarqube.Status.Upgrades.Failed = []string{"8.4.0"}
+	if target := selectUpgrade(sonarqube, current, testUpgrades()); target == nil || target.Version.MajorMinorPatch() != "8.3.1" {
+		t.Error("selectUpgrade: failed upgrade target selected again after another upgrade")
+	}
+}
+
+// TestSonarQubeUpgrade runs ReconcileSonarQube.verifyUpgrades() against a
+// fake client
+func TestSonarQubeUpgrade(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name           = "sonarqube-operator"
+		namespace      = "sonarqube"
+		namespacedName = types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		}
+	)
+
+	// A SonarQube resource with metadata and spec.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{
+			Version:      &[]string{"8.3.0"}[0],
+			UpdatesMinor: &[]bool{true}[0],
+		},
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube)
+	// Create a fake client to mock API calls.
+	cl := newFakeClient(s, objs...)
+	// Create a ReconcileSonarQube object with the scheme and fake client.
+	apiMock := &api_client.APIClientMock{
+		UpgradesOutput: testUpgrades(),
+	}
+	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: apiMock}
+
+	serverStatus := &api_client.Status{
+		Status:  api_client.SystemUp,
+		Version: api_client.SystemVersion{Major: 8, Minor: 3, Patch: 0, Build: "0"},
+	}
+
+	err := r.verifyUpgrades(sonarqube, serverStatus, apiMock)
+	if utils.ReasonForError(err) != utils.ErrorReasonSpecUpdate {
+		t.Error("verifyUpgrades: spec update error not thrown when starting upgrade")
+	}
+	err = r.client.Get(context.TODO(), namespacedName, sonarqube)
+	if err != nil {
+		t.Fatalf("verifyUpgrades: (%v)", err)
+	}
+	if sonarqube.Spec.Version == nil || *sonarqube.Spec.Version != "8.4.0" {
+		t.Error("verifyUpgrades: spec version not updated to upgrade target")
+	}
+	if !upgradeInProgress(sonarqube) {
+		t.Error("verifyUpgrades: upgrade not recorded in status")
+	}
+	if !sonarqube.Status.Conditions.IsTrueFor(sonarsourcev1alpha1.ConditionUpgrading) {
+		t.Error("verifyUpgrades: condition upgrading not set")
+	}
+	if len(sonarqube.Status.Upgrades.Compatible) != 3 || len(sonarqube.Status.Upgrades.Incompatible) != 1 {
+		t.Error("verifyUpgrades: compatible and incompatible upgrades not recorded")
+	}
+
+	err = r.verifyUpgrades(sonarqube, serverStatus, apiMock)
+	if utils.ReasonForError(err) != utils.ErrorReasonServerWaiting {
+		t.Error("verifyUpgrades: server waiting error not thrown while server reports old version")
+	}
+
+	serverStatus.Version = api_client.SystemVersion{Major: 8, Minor: 4, Patch: 0, Build: "2"}
+	err = r.verifyUpgrades(sonarqube, serverStatus, apiMock)
+	if err != nil {
+		t.Errorf("verifyUpgrades: returned error even though upgrade completed (%v)", err)
+	}
+	err = r.client.Get(context.TODO(), namespacedName, sonarqube)
+	if err != nil {
+		t.Fatalf("verifyUpgrades: (%v)", err)
+	}
+	if sonarqube.Status.Upgrades.Last == nil || sonarqube.Status.Upgrades.Last.Result != sonarsourcev1alpha1.UpgradeSucceeded {
+		t.Error("verifyUpgrades: upgrade not recorded as succeeded")
+	}
+	if !sonarqube.Status.Conditions.IsFalseFor(sonarsourcev1alpha1.ConditionUpgrading) {
+		t.Error("verifyUpgrades: condition upgrading not cleared")
+	}
+
+	// Upgrades the server doesn't report within the timeout are failed
+	sonarqube.Spec.UpdatesMajor = &[]bool{true}[0]
+	err = r.verifyUpgrades(sonarqube, serverStatus, apiMock)
+	if utils.ReasonForError(err) != utils.ErrorReasonSpecUpdate {
+		t.Fatalf("verifyUpgrades: spec update error not thrown when starting upgrade (%v)", err)
+	}
+	err = r.client.Get(context.TODO(), namespacedName, sonarqube)
+	if err != nil {
+		t.Fatalf("verifyUpgrades: (%v)", err)
+	}
+	started := metav1.NewTime(time.Now().Add(-UpgradeTimeout - time.Minute))
+	sonarqube.Status.Upgrades.Last.StartTime = &started
+	err = r.verifyUpgrades(sonarqube, serverStatus, apiMock)
+	if err != nil {
+		t.Errorf("verifyUpgrades: returned error for an upgrade past the timeout (%v)", err)
+	}
+	err = r.client.Get(context.TODO(), namespacedName, sonarqube)
+	if err != nil {
+		t.Fatalf("verifyUpgrades: (%v)", err)
+	}
+	if sonarqube.Status.Upgrades.Last.Result != sonarsourcev1alpha1.UpgradeFailed {
+		t.Error("verifyUpgrades: upgrade past the timeout not recorded as failed")
+	}
+	if !utils.ContainsString(sonarqube.Status.Upgrades.Failed, "9.0.0") {
+		t.Errorf("verifyUpgrades: failed target not recorded %v", sonarqube.Status.Upgrades.Failed)
+	}
+
+	// Automatic upgrades are paused until the version of a failed upgrade is changed
+	err = r.verifyUpgrades(sonarqube, serverStatus, apiMock)
+	if err != nil || upgradeInProgress(sonarqube) || *sonarqube.Spec.Version != "9.0.0" {
+		t.Errorf("verifyUpgrades: upgrade started after a failed upgrade (%v)", err)
+	}
+	sonarqube.Spec.Version = &[]string{"8.4.0"}[0]
+	err = r.verifyUpgrades(sonarqube, serverStatus, apiMock)
+	if err != nil || upgradeInProgress(sonarqube) {
+		t.Errorf("verifyUpgrades: failed target upgraded to again (%v)", err)
+	}
+
+	// Automatic upgrades can't change the version of a pinned image
+	sonarqube.Spec.Image = &sonarsourcev1alpha1.Image{Tag: &[]string{"8.4-community"}[0]}
+	err = r.verifyUpgrades(sonarqube, serverStatus, apiMock)
+	if utils.ReasonForError(err) != utils.ErrorReasonSpecInvalid {
+		t.Errorf("verifyUpgrades: spec invalid error not thrown for pinned image tag (%v)", err)
+	}
+}
+
+// TestSonarQubeUpgradeBackupAge runs ReconcileSonarQube.verifyUpgrades() against a
+// fake client with UpdatesBackupMaxAge set
+func TestSonarQubeUpgradeBackupAge(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name           = "sonarqube-operator"
+		namespace      = "sonarqube"
+		namespacedName = types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		}
+	)
+
+	// A SonarQube resource with metadata and spec.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{
+			Version:             &[]string{"8.3.0"}[0],
+			UpdatesMinor:        &[]bool{true}[0],
+			UpdatesBackupMaxAge: &[]int32{60}[0],
+		},
+	}
+	// A SonarQubeBackup of the SonarQube resource with an outdated backup.
+	backup := &sonarsourcev1alpha1.SonarQubeBackup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeBackupSpec{
+			SonarQube: name,
+			Schedule:  "0 0 * * *",
+		},
+		Status: sonarsourcev1alpha1.SonarQubeBackupStatus{
+			LastBackupTime: &metav1.Time{Time: time.Now().Add(-2 * time.Hour)},
+		},
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+		backup,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube, backup, &sonarsourcev1alpha1.SonarQubeBackupList{})
+	// Create a fake client to mock API calls.
+	cl := newFakeClient(s, objs...)
+	// Create a ReconcileSonarQube object with the scheme and fake client.
+	apiMock := &api_client.APIClientMock{
+		UpgradesOutput: testUpgrades(),
+	}
+	r := &ReconcileSonarQube{client: cl, scheme: s, apiClient: apiMock}
+
+	serverStatus := &api_client.Status{
+		Status:  api_client.SystemUp,
+		Version: api_client.SystemVersion{Major: 8, Minor: 3, Patch: 0, Build: "0"},
+	}
+
+	err := r.verifyUpgrades(sonarqube, serverStatus, apiMock)
+	if err != nil {
+		t.Errorf("verifyUpgrades: returned error while waiting for a backup (%v)", err)
+	}
+	err = r.client.Get(context.TODO(), namespacedName, sonarqube)
+	if err != nil {
+		t.Fatalf("verifyUpgrades: (%v)", err)
+	}
+	if *sonarqube.Spec.Version != "8.3.0" || upgradeInProgress(sonarqube) {
+		t.Error("verifyUpgrades: upgrade started without a recent backup")
+	}
+	condition := sonarqube.Status.Conditions.GetCondition(sonarsourcev1alpha1.ConditionUpgrading)
+	if condition == nil || condition.Reason != sonarsourcev1alpha1.ConditionBackupRequired {
+		t.Error("verifyUpgrades: backup required not reported")
+	}
+
+	// Backups created by users aren't owned by SonarQube, their changes still requeue it
+	requests := backupSonarQube(handler.MapObject{Meta: backup, Object: backup})
+	if len(requests) != 1 || requests[0].NamespacedName != namespacedName {
+		t.Errorf("backupSonarQube: backup not mapped to its sonarqube (%v)", requests)
+	}
+
+	backup.Status.LastBackupTime = &metav1.Time{Time: time.Now().Add(-10 * time.Minute)}
+	err = r.client.Update(context.TODO(), backup)
+	if err != nil {
+		t.Fatalf("update backup: (%v)", err)
+	}
+
+	err = r.verifyUpgrades(sonarqube, serverStatus, apiMock)
+	if utils.ReasonForError(err) != utils.ErrorReasonSpecUpdate {
+		t.Error("verifyUpgrades: upgrade not started with a recent backup")
+	}
+}
@@ -0,0 +1,146 @@
+package sonarqube
+
+import (
+	"fmt"
+	"strings"
+
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	VolumePathStorage string = "/storage"
+	VolumePathMigrate string = "/volumes"
+)
+
+// migrateScript copies volumes out of the storage volume into their own volume once, the marker file keeps
+// data written since from being overwritten
+const migrateScript = `set -e
+for volume in $VOLUMES; do
+  if [ -e "$TARGET_PATH/$volume/.migrated" ]; then
+    continue
+  fi
+  if [ -d "$STORAGE_PATH/$volume" ]; then
+    echo "copying $volume out of the storage volume"
+    cp -a "$STORAGE_PATH/$volume/." "$TARGET_PATH/$volume/"
+  fi
+  touch "$TARGET_PATH/$volume/.migrated"
+done
+`
+
+// podVolumes returns the source of every volume mounted by pods of SonarQube from the claims of pvcs.
+// Volumes left out are kept in the storage volume, temp is an emptyDir unless it has a source in spec
+func podVolumes(cr *sonarsourcev1alpha1.SonarQube, pvcs map[Volume]*corev1.PersistentVolumeClaim) map[Volume]*corev1.VolumeSource {
+	sources := make(map[Volume]*corev1.VolumeSource)
+
+	for _, volume := range allVolumes {
+		if pvc, ok := pvcs[volume]; ok {
+			sources[volume] = &corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: pvc.Name,
+				},
+			}
+		} else if source := volumeSource(cr, volume); source != nil && source.EmptyDir != nil {
+			sources[volume] = &corev1.VolumeSource{
+				EmptyDir: source.EmptyDir.DeepCopy(),
+			}
+		}
+	}
+
+	if _, ok := sources[VolumeTemp]; !ok {
+		sources[VolumeTemp] = &corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		}
+	}
+
+	return sources
+}
+
+// migratedVolumes returns the volumes with a PersistentVolumeClaim of their own next to a storage claim,
+// they are copied out of the storage claim once
+func migratedVolumes(sources map[Volume]*corev1.VolumeSource) []Volume {
+	if storage, ok := sources[VolumeStorage]; !ok || storage.PersistentVolumeClaim == nil {
+		return nil
+	}
+
+	var migrated []Volume
+	for _, volume := range storageVolumes {
+		if source, ok := sources[volume]; ok && source.PersistentVolumeClaim != nil {
+			migrated = append(migrated, volume)
+		}
+	}
+	return migrated
+}
+
+// volumeMount mounts volume at path, volumes without a source of their own are mounted from the storage volume
+func volumeMount(sources map[Volume]*corev1.VolumeSource, volume Volume, path string) corev1.VolumeMount {
+	if _, ok := sources[volume]; ok {
+		return corev1.VolumeMount{
+			Name:      string(volume),
+			MountPath: path,
+		}
+	}
+
+	return corev1.VolumeMount{
+		Name:      string(VolumeStorage),
+		MountPath: path,
+		SubPath:   string(volume),
+	}
+}
+
+// newMigrateContainer returns the init container copying the migrated volumes out of the storage volume
+func newMigrateContainer(cr *sonarsourcev1alpha1.SonarQube, image string, migrated []Volume) *corev1.Container {
+	names := make([]string, len(migrated))
+	mounts := []corev1.VolumeMount{
+		{
+			Name:      string(VolumeStorage),
+			MountPath: VolumePathStorage,
+			ReadOnly:  true,
+		},
+	}
+	for i, volume := range migrated {
+		names[i] = string(volume)
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      string(volume),
+			MountPath: fmt.Sprintf("%s/%s", VolumePathMigrate, volume),
+		})
+	}
+
+	return &corev1.Container{
+		Name:    "migrate-volumes",
+		Image:   image,
+		Command: []string{"sh", "-c", migrateScript},
+		Env: []corev1.EnvVar{
+			{
+				Name:  "VOLUMES",
+				Value: strings.Join(names, " "),
+			},
+			{
+				Name:  "STORAGE_PATH",
+				Value: VolumePathStorage,
+			},
+			{
+				Name:  "TARGET_PATH",
+				Value: VolumePathMigrate,
+			},
+		},
+		VolumeMounts:    mounts,
+		ImagePullPolicy: imagePullPolicy(cr),
+	}
+}
+
+// verifyClusterVolumes checks the volumes of a cluster are emptyDirs, nodes of a cluster can't share claims
+// Errors:
+//   ErrorReasonSpecInvalid: returned when a volume of a cluster is a PersistentVolumeClaim
+func verifyClusterVolumes(cr *sonarsourcev1alpha1.SonarQube) error {
+	for _, volume := range allVolumes {
+		if source := volumeSource(cr, volume); source != nil && source.EmptyDir == nil {
+			return &utils.Error{
+				Reason:  utils.ErrorReasonSpecInvalid,
+				Message: fmt.Sprintf("volume %s of a cluster must be an emptyDir", volume),
+			}
+		}
+	}
+	return nil
+}
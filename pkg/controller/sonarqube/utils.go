@@ -19,20 +19,60 @@ func (r *ReconcileSonarQube) Labels(cr *sonarsourcev1alpha1.SonarQube) map[strin
 	labels[sonarsourcev1alpha1.KubeAppVersion] = cr.Status.Revision
 	labels[sonarsourcev1alpha1.KubeAppManagedby] = fmt.Sprintf("sonarqube-operator.v%s", version.Version)
 
-	if cr.Spec.Type != nil {
-		labels[sonarsourcev1alpha1.KubeAppComponent] = string(*cr.Spec.Type)
-	} else {
-		labels[sonarsourcev1alpha1.KubeAppComponent] = string(sonarsourcev1alpha1.AIO)
-	}
+	labels[sonarsourcev1alpha1.KubeAppComponent] = string(nodeType(cr))
 
 	return labels
 }
 
+// PodLabels are the labels of the pods, they leave out the revision and operator version which change over time
 func (r *ReconcileSonarQube) PodLabels(cr *sonarsourcev1alpha1.SonarQube) map[string]string {
 	labels := r.Labels(cr)
-	podLabels := make(map[string]string)
-	podLabels[sonarsourcev1alpha1.ServerTypeLabel] = labels[sonarsourcev1alpha1.ServerTypeLabel]
-	podLabels["Deployment"] = cr.Name
+	delete(labels, sonarsourcev1alpha1.KubeAppVersion)
+	delete(labels, sonarsourcev1alpha1.KubeAppManagedby)
 
 	return labels
 }
+
+// SelectorLabels are used in selectors, selectors are immutable so they leave out the labels of the SonarQube resource
+func (r *ReconcileSonarQube) SelectorLabels(cr *sonarsourcev1alpha1.SonarQube) map[string]string {
+	return map[string]string{
+		sonarsourcev1alpha1.ServerTypeLabel:  cr.Name,
+		sonarsourcev1alpha1.KubeAppInstance:  cr.Name,
+		sonarsourcev1alpha1.KubeAppComponent: string(nodeType(cr)),
+	}
+}
+
+// SearchLabels are the labels of the search nodes of a cluster
+func (r *ReconcileSonarQube) SearchLabels(cr *sonarsourcev1alpha1.SonarQube) map[string]string {
+	labels := r.Labels(cr)
+	labels[sonarsourcev1alpha1.KubeAppComponent] = string(sonarsourcev1alpha1.Search)
+
+	return labels
+}
+
+// SearchPodLabels are the labels of the search node pods of a cluster
+func (r *ReconcileSonarQube) SearchPodLabels(cr *sonarsourcev1alpha1.SonarQube) map[string]string {
+	labels := r.PodLabels(cr)
+	labels[sonarsourcev1alpha1.KubeAppComponent] = string(sonarsourcev1alpha1.Search)
+
+	return labels
+}
+
+// SearchSelectorLabels are used in the selectors of the search nodes of a cluster
+func (r *ReconcileSonarQube) SearchSelectorLabels(cr *sonarsourcev1alpha1.SonarQube) map[string]string {
+	labels := r.SelectorLabels(cr)
+	labels[sonarsourcev1alpha1.KubeAppComponent] = string(sonarsourcev1alpha1.Search)
+
+	return labels
+}
+
+// nodeType returns the node type of the pods managed by the Deployment, application nodes when clustered
+func nodeType(cr *sonarsourcev1alpha1.SonarQube) sonarsourcev1alpha1.ServerType {
+	if cr.Spec.Cluster != nil {
+		return sonarsourcev1alpha1.Application
+	}
+	if cr.Spec.Type == nil {
+		return sonarsourcev1alpha1.AIO
+	}
+	return *cr.Spec.Type
+}
@@ -0,0 +1,185 @@
+package sonarqubebackup
+
+import (
+	"context"
+	"fmt"
+
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+var log = logf.Log.WithName("controller_sonarqubebackup")
+
+// Add creates a new SonarQubeBackup Controller and adds it to the Manager. The Manager will set fields on the Controller
+// and Start it when the Manager is Started.
+func Add(mgr manager.Manager) error {
+	return add(mgr, newReconciler(mgr))
+}
+
+// newReconciler returns a new reconcile.Reconciler
+func newReconciler(mgr manager.Manager) reconcile.Reconciler {
+	return &ReconcileSonarQubeBackup{client: mgr.GetClient(), scheme: mgr.GetScheme()}
+}
+
+// add adds a new Controller to mgr with r as the reconcile.Reconciler
+func add(mgr manager.Manager, r reconcile.Reconciler) error {
+	// Create a new controller
+	c, err := controller.New("sonarqubebackup-controller", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to primary resource SonarQubeBackup
+	err = c.Watch(&source.Kind{Type: &sonarsourcev1alpha1.SonarQubeBackup{}}, &handler.EnqueueRequestForObject{})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to secondary resource CronJob and requeue the owner SonarQubeBackup
+	err = c.Watch(&source.Kind{Type: &batchv1beta1.CronJob{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &sonarsourcev1alpha1.SonarQubeBackup{},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Dumps, snapshots and the Jobs started by the CronJob aren't owned by SonarQubeBackup so they are mapped by label
+	backupMapper := &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(o handler.MapObject) []reconcile.Request {
+			name, ok := o.Meta.GetLabels()[sonarsourcev1alpha1.BackupLabel]
+			if !ok {
+				return nil
+			}
+			return []reconcile.Request{
+				{NamespacedName: types.NamespacedName{Namespace: o.Meta.GetNamespace(), Name: name}},
+			}
+		}),
+	}
+
+	// Watch for changes to secondary resource PersistentVolumeClaim and requeue the SonarQubeBackup
+	err = c.Watch(&source.Kind{Type: &corev1.PersistentVolumeClaim{}}, backupMapper)
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to Jobs started by the CronJob
+	err = c.Watch(&source.Kind{Type: &batchv1.Job{}}, backupMapper)
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to secondary resource VolumeSnapshot and requeue the SonarQubeBackup,
+	// snapshots only exist when the snapshot CRDs are installed
+	if _, err := mgr.GetRESTMapper().RESTMapping(VolumeSnapshotGVK.GroupKind(), VolumeSnapshotGVK.Version); err == nil {
+		err = c.Watch(&source.Kind{Type: newVolumeSnapshot()}, backupMapper)
+		if err != nil {
+			return err
+		}
+	} else if !meta.IsNoMatchError(err) {
+		return err
+	}
+
+	return nil
+}
+
+// blank assignment to verify that ReconcileSonarQubeBackup implements reconcile.Reconciler
+var _ reconcile.Reconciler = &ReconcileSonarQubeBackup{}
+
+// ReconcileSonarQubeBackup reconciles a SonarQubeBackup object
+type ReconcileSonarQubeBackup struct {
+	// This client, initialized using mgr.Client() above, is a split client
+	// that reads objects from the cache and writes to the apiserver
+	client client.Client
+	scheme *runtime.Scheme
+}
+
+// Reconcile reads that state of the cluster for a SonarQubeBackup object and makes changes based on the state read
+// and what is in the SonarQubeBackup.Spec
+func (r *ReconcileSonarQubeBackup) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	reqLogger := log.WithValues("Request.Namespace", request.Namespace, "Request.Name", request.Name)
+	reqLogger.Info("Reconciling SonarQubeBackup")
+
+	// Fetch the SonarQubeBackup instance
+	instance := &sonarsourcev1alpha1.SonarQubeBackup{}
+	err := r.client.Get(context.TODO(), request.NamespacedName, instance)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// Request object not found, could have been deleted after reconcile request.
+			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
+			// Return and don't requeue
+			return reconcile.Result{}, nil
+		}
+		// Error reading the object - requeue the request.
+		return reconcile.Result{}, err
+	}
+
+	sonarqube, err := r.getSonarQube(instance)
+	if err != nil {
+		return utils.ParseErrorForReconcileResult(r.client, instance, err)
+	}
+
+	_, err = r.ReconcilePVC(instance)
+	if err != nil {
+		return utils.ParseErrorForReconcileResult(r.client, instance, err)
+	}
+
+	_, err = r.ReconcileCronJob(instance, sonarqube)
+	if err != nil {
+		return utils.ParseErrorForReconcileResult(r.client, instance, err)
+	}
+
+	err = r.ReconcileBackups(instance, sonarqube)
+	if err != nil {
+		return utils.ParseErrorForReconcileResult(r.client, instance, err)
+	}
+
+	newStatus := instance.DeepCopy()
+
+	newStatus.Status.Conditions = utils.ClearConditions(newStatus.Status.Conditions)
+
+	utils.UpdateStatus(r.client, newStatus, instance)
+
+	return utils.ParseErrorForReconcileResult(r.client, instance, nil)
+}
+
+// getSonarQube returns the SonarQube resource backed up
+// Errors:
+//   ErrorReasonSpecInvalid: returned when the SonarQube resource doesn't exist or has no external database
+//   ErrorReasonUnknown: returned when unhandled error from client occurs
+func (r *ReconcileSonarQubeBackup) getSonarQube(cr *sonarsourcev1alpha1.SonarQubeBackup) (*sonarsourcev1alpha1.SonarQube, error) {
+	sonarqube := &sonarsourcev1alpha1.SonarQube{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: cr.Spec.SonarQube, Namespace: cr.Namespace}, sonarqube)
+	if err != nil && errors.IsNotFound(err) {
+		return nil, &utils.Error{
+			Reason:  utils.ErrorReasonSpecInvalid,
+			Message: fmt.Sprintf("sonarqube %s doesn't exist", cr.Spec.SonarQube),
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	// The embedded H2 database lives in the SonarQube pod and can't be dumped
+	if sonarqube.Spec.Database == nil {
+		return nil, &utils.Error{
+			Reason:  utils.ErrorReasonSpecInvalid,
+			Message: fmt.Sprintf("sonarqube %s must use an external PostgreSQL database to be backed up", sonarqube.Name),
+		}
+	}
+
+	return sonarqube, nil
+}
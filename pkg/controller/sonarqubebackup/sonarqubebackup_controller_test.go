@@ -0,0 +1,232 @@
+package sonarqubebackup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils/fake"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+func newJob(cr *sonarsourcev1alpha1.SonarQubeBackup, name string, completed time.Time, failed bool) *batchv1.Job {
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         cr.Namespace,
+			Labels:            Labels(cr),
+			CreationTimestamp: metav1.Time{Time: completed.Add(-time.Minute)},
+		},
+	}
+	if failed {
+		job.Status.Conditions = []batchv1.JobCondition{
+			{Type: batchv1.JobFailed, Status: corev1.ConditionTrue},
+		}
+	} else {
+		job.Status.Succeeded = 1
+		job.Status.CompletionTime = &metav1.Time{Time: completed}
+	}
+	return job
+}
+
+// TestSonarQubeBackupController runs ReconcileSonarQubeBackup.Reconcile() against a
+// fake client that tracks a SonarQubeBackup object.
+func TestSonarQubeBackupController(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name           = "sonarqube-operator"
+		namespace      = "sonarqube"
+		namespacedName = types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		}
+	)
+
+	// A SonarQube resource with an external database.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{
+			Database: &sonarsourcev1alpha1.Database{
+				Host:   &[]string{"postgres"}[0],
+				Secret: "database",
+			},
+		},
+	}
+	// A SonarQubeBackup resource with metadata and spec.
+	backup := &sonarsourcev1alpha1.SonarQubeBackup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeBackupSpec{
+			SonarQube: name,
+			Schedule:  "0 0 * * *",
+			Retention: &[]int32{2}[0],
+		},
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+		backup,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube, backup, &sonarsourcev1alpha1.SonarQubeBackupList{})
+	// Create a fake client to mock API calls.
+	cl := fake.NewFakeClient(s, objs...)
+	// Create a ReconcileSonarQubeBackup object with the scheme and fake client.
+	r := &ReconcileSonarQubeBackup{client: cl, scheme: s}
+
+	// Mock request to simulate Reconcile() being called on an event for a
+	// watched resource .
+	req := reconcile.Request{
+		NamespacedName: namespacedName,
+	}
+
+	var err error
+	for i := 0; i < 5; i++ {
+		_, err = r.Reconcile(req)
+		if err != nil {
+			t.Fatalf("reconcile: (%v)", err)
+		}
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: dumpsName(backup), Namespace: namespace}, pvc)
+	if err != nil {
+		t.Errorf("reconcile: dumps pvc not created (%v)", err)
+	}
+	if metav1.GetControllerOf(pvc) != nil {
+		t.Error("reconcile: dumps pvc garbage collected with sonarqubebackup")
+	}
+
+	cronJob := &batchv1beta1.CronJob{}
+	err = r.client.Get(context.TODO(), namespacedName, cronJob)
+	if err != nil {
+		t.Fatalf("reconcile: cronjob not created (%v)", err)
+	}
+	if cronJob.Spec.Schedule != backup.Spec.Schedule {
+		t.Error("reconcile: cronjob schedule doesn't match spec")
+	}
+	containers := cronJob.Spec.JobTemplate.Spec.Template.Spec.Containers
+	if len(containers) != 1 || containers[0].Env[0].Value != "postgresql://postgres:5432/sonarqube" {
+		t.Error("reconcile: cronjob doesn't dump the database of sonarqube")
+	}
+
+	now := time.Now()
+	jobs := []*batchv1.Job{
+		newJob(backup, "backup-1", now.Add(-3*time.Hour), false),
+		newJob(backup, "backup-2", now.Add(-2*time.Hour), false),
+		newJob(backup, "backup-3", now.Add(-time.Hour), false),
+	}
+	for _, job := range jobs {
+		if err := r.client.Create(context.TODO(), job); err != nil {
+			t.Fatalf("create job: (%v)", err)
+		}
+	}
+
+	_, err = r.Reconcile(req)
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+	err = r.client.Get(context.TODO(), namespacedName, backup)
+	if err != nil {
+		t.Fatalf("get sonarqubebackup: (%v)", err)
+	}
+	if len(backup.Status.Backups) != 2 || backup.Status.Backups[0].Name != "backup-3" || backup.Status.Backups[1].Name != "backup-2" {
+		t.Errorf("reconcile: backups beyond retention recorded (%v)", backup.Status.Backups)
+	}
+	if backup.Status.LastBackupTime == nil || !backup.Status.LastBackupTime.Equal(&backup.Status.Backups[0].CompletionTime) {
+		t.Error("reconcile: last backup time not recorded")
+	}
+
+	err = r.client.Create(context.TODO(), newJob(backup, "backup-4", now, true))
+	if err != nil {
+		t.Fatalf("create job: (%v)", err)
+	}
+	err = r.ReconcileBackups(backup, sonarqube)
+	if utils.ReasonForError(err) != utils.ErrorReasonResourceInvalid {
+		t.Error("reconcile: resource invalid error not thrown when latest backup failed")
+	}
+}
+
+// TestSonarQubeBackupControllerEmbeddedDatabase runs ReconcileSonarQubeBackup.Reconcile() against a
+// fake client that tracks a SonarQube object without an external database.
+func TestSonarQubeBackupControllerEmbeddedDatabase(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name           = "sonarqube-operator"
+		namespace      = "sonarqube"
+		namespacedName = types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		}
+	)
+
+	// A SonarQube resource with the embedded database.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+	// A SonarQubeBackup resource with metadata and spec.
+	backup := &sonarsourcev1alpha1.SonarQubeBackup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeBackupSpec{
+			SonarQube: name,
+			Schedule:  "0 0 * * *",
+		},
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+		backup,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube, backup, &sonarsourcev1alpha1.SonarQubeBackupList{})
+	// Create a fake client to mock API calls.
+	cl := fake.NewFakeClient(s, objs...)
+	// Create a ReconcileSonarQubeBackup object with the scheme and fake client.
+	r := &ReconcileSonarQubeBackup{client: cl, scheme: s}
+
+	_, err := r.getSonarQube(backup)
+	if utils.ReasonForError(err) != utils.ErrorReasonSpecInvalid {
+		t.Error("getSonarQube: spec invalid error not thrown for embedded database")
+	}
+
+	_, err = r.Reconcile(reconcile.Request{NamespacedName: namespacedName})
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+	err = r.client.Get(context.TODO(), namespacedName, backup)
+	if err != nil {
+		t.Fatalf("get sonarqubebackup: (%v)", err)
+	}
+	if !backup.Status.Conditions.IsTrueFor(sonarsourcev1alpha1.ConditionInvalid) {
+		t.Error("reconcile: condition invalid not set for embedded database")
+	}
+}
@@ -0,0 +1,217 @@
+package sonarqubebackup
+
+import (
+	"fmt"
+
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	"github.com/jlfowle/sonarqube-operator/version"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	DefaultRetention   int32  = 7
+	DefaultImage       string = "postgres:12"
+	DefaultStorageSize string = "10Gi"
+	VolumePathDumps    string = "/backups"
+)
+
+// dumpScript dumps the database to <job name>.dump then removes the dumps beyond retention.
+// The dump is written to a temporary file first so an interrupted dump is never kept
+const dumpScript = `set -e
+pg_dump --format=custom --file="$DUMP_PATH/$JOB_NAME.dump.tmp" "$DATABASE_URL"
+mv "$DUMP_PATH/$JOB_NAME.dump.tmp" "$DUMP_PATH/$JOB_NAME.dump"
+ls -1t "$DUMP_PATH"/*.dump | tail -n +$((RETENTION + 1)) | xargs -r rm -f
+`
+
+// Reconciles PersistentVolumeClaim holding the database dumps of SonarQubeBackup,
+// the claim outlives SonarQubeBackup and is deleted manually
+// Returns: PersistentVolumeClaim, Error
+// If Error is non-nil, PersistentVolumeClaim is not in expected state
+// Errors:
+//   ErrorReasonSpecInvalid: returned when the storage size is invalid
+//   ErrorReasonResourceCreate: returned when PersistentVolumeClaim does not exists
+//   ErrorReasonResourceUpdate: returned when PersistentVolumeClaim was updated to meet expected state
+//   ErrorReasonUnknown: returned when unhandled error from client occurs
+func (r *ReconcileSonarQubeBackup) ReconcilePVC(cr *sonarsourcev1alpha1.SonarQubeBackup) (*corev1.PersistentVolumeClaim, error) {
+	storageSize := DefaultStorageSize
+	if cr.Spec.StorageSize != nil {
+		storageSize = *cr.Spec.StorageSize
+	}
+	size, err := resource.ParseQuantity(storageSize)
+	if err != nil {
+		return nil, &utils.Error{
+			Reason:  utils.ErrorReasonSpecInvalid,
+			Message: fmt.Sprintf("storage size %s is invalid", storageSize),
+		}
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: cr.Namespace,
+			Name:      dumpsName(cr),
+			Labels:    Labels(cr),
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: size,
+				},
+			},
+			StorageClassName: cr.Spec.StorageClass,
+			VolumeMode:       &[]corev1.PersistentVolumeMode{corev1.PersistentVolumeFilesystem}[0],
+		},
+	}
+
+	// Dumps are not owned by SonarQubeBackup so they aren't garbage collected with it
+	foundPVC := &corev1.PersistentVolumeClaim{}
+
+	return foundPVC, utils.ApplyResource(r.client, r.scheme, pvc, foundPVC, "")
+}
+
+// Reconciles CronJob dumping the database of SonarQube on the schedule of SonarQubeBackup
+// Returns: CronJob, Error
+// If Error is non-nil, CronJob is not in expected state
+// Errors:
+//   ErrorReasonSpecInvalid: returned when the database of SonarQube is not a PostgreSQL database
+//   ErrorReasonResourceCreate: returned when CronJob does not exists
+//   ErrorReasonResourceUpdate: returned when CronJob was updated to meet expected state
+//   ErrorReasonUnknown: returned when unhandled error from client occurs
+func (r *ReconcileSonarQubeBackup) ReconcileCronJob(cr *sonarsourcev1alpha1.SonarQubeBackup, sonarqube *sonarsourcev1alpha1.SonarQube) (*batchv1beta1.CronJob, error) {
+	newCronJob, err := r.newCronJob(cr, sonarqube)
+	if err != nil {
+		return nil, err
+	}
+
+	foundCronJob := &batchv1beta1.CronJob{}
+
+	return foundCronJob, utils.ApplyResource(r.client, r.scheme, newCronJob, foundCronJob, "")
+}
+
+func (r *ReconcileSonarQubeBackup) newCronJob(cr *sonarsourcev1alpha1.SonarQubeBackup, sonarqube *sonarsourcev1alpha1.SonarQube) (*batchv1beta1.CronJob, error) {
+	env, err := utils.GetPostgreSQLEnv(sonarqube.Spec.Database)
+	if err != nil {
+		return nil, err
+	}
+
+	env = append(env,
+		corev1.EnvVar{
+			Name: "JOB_NAME",
+			ValueFrom: &corev1.EnvVarSource{
+				FieldRef: &corev1.ObjectFieldSelector{
+					APIVersion: "v1",
+					FieldPath:  "metadata.labels['job-name']",
+				},
+			},
+		},
+		corev1.EnvVar{
+			Name:  "DUMP_PATH",
+			Value: VolumePathDumps,
+		},
+		corev1.EnvVar{
+			Name:  "RETENTION",
+			Value: fmt.Sprintf("%d", *retention(cr)),
+		},
+	)
+
+	labels := Labels(cr)
+
+	image := DefaultImage
+	if cr.Spec.Image != nil {
+		image = *cr.Spec.Image
+	}
+
+	cronJob := &batchv1beta1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: cr.Namespace,
+			Name:      cr.Name,
+			Labels:    labels,
+		},
+		Spec: batchv1beta1.CronJobSpec{
+			Schedule:                   cr.Spec.Schedule,
+			Suspend:                    cr.Spec.Suspend,
+			ConcurrencyPolicy:          batchv1beta1.ForbidConcurrent,
+			SuccessfulJobsHistoryLimit: retention(cr),
+			FailedJobsHistoryLimit:     &[]int32{1}[0],
+			JobTemplate: batchv1beta1.JobTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: batchv1.JobSpec{
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{
+							Labels: labels,
+						},
+						Spec: corev1.PodSpec{
+							RestartPolicy: corev1.RestartPolicyOnFailure,
+							Containers: []corev1.Container{
+								{
+									Name:    "pg-dump",
+									Image:   image,
+									Command: []string{"sh", "-c", dumpScript},
+									Env:     env,
+									VolumeMounts: []corev1.VolumeMount{
+										{
+											Name:      "dumps",
+											MountPath: VolumePathDumps,
+										},
+									},
+								},
+							},
+							Volumes: []corev1.Volume{
+								{
+									Name: "dumps",
+									VolumeSource: corev1.VolumeSource{
+										PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+											ClaimName: dumpsName(cr),
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if err := controllerutil.SetControllerReference(cr, cronJob, r.scheme); err != nil {
+		return cronJob, err
+	}
+
+	return cronJob, nil
+}
+
+// Labels of the resources of SonarQubeBackup, jobs started by the CronJob are mapped back to the backup with BackupLabel
+func Labels(cr *sonarsourcev1alpha1.SonarQubeBackup) map[string]string {
+	labels := make(map[string]string)
+
+	for k, v := range cr.Labels {
+		labels[k] = v
+	}
+
+	labels[sonarsourcev1alpha1.BackupLabel] = cr.Name
+	labels[sonarsourcev1alpha1.KubeAppName] = "SonarQube"
+	labels[sonarsourcev1alpha1.KubeAppInstance] = cr.Spec.SonarQube
+	labels[sonarsourcev1alpha1.KubeAppComponent] = "backup"
+	labels[sonarsourcev1alpha1.KubeAppManagedby] = fmt.Sprintf("sonarqube-operator.v%s", version.Version)
+
+	return labels
+}
+
+func retention(cr *sonarsourcev1alpha1.SonarQubeBackup) *int32 {
+	if cr.Spec.Retention == nil {
+		return &[]int32{DefaultRetention}[0]
+	}
+	return cr.Spec.Retention
+}
+
+func dumpsName(cr *sonarsourcev1alpha1.SonarQubeBackup) string {
+	return fmt.Sprintf("%s-dumps", cr.Name)
+}
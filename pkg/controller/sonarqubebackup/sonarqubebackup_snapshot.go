@@ -0,0 +1,173 @@
+package sonarqubebackup
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// VolumeSnapshotGVK is the CSI VolumeSnapshot kind, snapshots are handled as unstructured objects so the
+// operator doesn't depend on the snapshot client
+var VolumeSnapshotGVK = schema.GroupVersionKind{Group: "snapshot.storage.k8s.io", Version: "v1beta1", Kind: "VolumeSnapshot"}
+
+// Reconciles completed backups of SonarQubeBackup
+// Every Job completed by the CronJob is recorded in status with a VolumeSnapshot of the SonarQube volume,
+// backups beyond retention are dropped with their snapshot
+// Returns: Error
+// Errors:
+//   ErrorReasonResourceInvalid: returned when the latest backup job failed
+//   ErrorReasonUnknown: returned when unhandled error from client occurs
+func (r *ReconcileSonarQubeBackup) ReconcileBackups(cr *sonarsourcev1alpha1.SonarQubeBackup, sonarqube *sonarsourcev1alpha1.SonarQube) error {
+	jobs := &batchv1.JobList{}
+	err := r.client.List(context.TODO(), jobs, client.InNamespace(cr.Namespace), client.MatchingLabels{sonarsourcev1alpha1.BackupLabel: cr.Name})
+	if err != nil {
+		return err
+	}
+
+	backups := append([]sonarsourcev1alpha1.BackupRecord{}, cr.Status.Backups...)
+	recorded := make(map[string]bool)
+	for _, backup := range backups {
+		recorded[backup.Name] = true
+	}
+
+	var failed *batchv1.Job
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		if recorded[job.Name] {
+			continue
+		}
+		if job.Status.Succeeded > 0 && job.Status.CompletionTime != nil {
+			backups = append(backups, sonarsourcev1alpha1.BackupRecord{
+				Name:           job.Name,
+				Dump:           fmt.Sprintf("%s.dump", job.Name),
+				CompletionTime: *job.Status.CompletionTime,
+			})
+		} else if jobFailed(job) && (failed == nil || failed.CreationTimestamp.Before(&job.CreationTimestamp)) {
+			failed = job
+		}
+	}
+
+	sort.SliceStable(backups, func(i, j int) bool {
+		return backups[j].CompletionTime.Before(&backups[i].CompletionTime)
+	})
+
+	var expired []sonarsourcev1alpha1.BackupRecord
+	if limit := int(*retention(cr)); len(backups) > limit {
+		expired = backups[limit:]
+		backups = backups[:limit]
+	}
+
+	for _, backup := range expired {
+		if backup.Snapshot == "" {
+			continue
+		}
+		if err := r.removeSnapshot(cr, backup.Snapshot); err != nil {
+			return err
+		}
+	}
+
+	for i := range backups {
+		if recorded[backups[i].Name] {
+			continue
+		}
+		snapshot, err := r.createSnapshot(cr, sonarqube, backups[i].Name)
+		if err != nil {
+			return err
+		}
+		backups[i].Snapshot = snapshot
+	}
+
+	newStatus := cr.DeepCopy()
+	newStatus.Status.Backups = backups
+	newStatus.Status.LastBackupTime = nil
+	if len(backups) > 0 {
+		newStatus.Status.LastBackupTime = backups[0].CompletionTime.DeepCopy()
+	}
+	utils.UpdateStatus(r.client, newStatus, cr)
+
+	if failed != nil && (len(backups) == 0 || backups[0].CompletionTime.Before(&failed.CreationTimestamp)) {
+		return &utils.Error{
+			Reason:  utils.ErrorReasonResourceInvalid,
+			Message: fmt.Sprintf("backup job %s failed", failed.Name),
+		}
+	}
+
+	return nil
+}
+
+// createSnapshot takes a VolumeSnapshot of the claim holding the SonarQube data volume
+// Returns: name of the snapshot, empty when SonarQube has no volume or volume snapshots are not available
+func (r *ReconcileSonarQubeBackup) createSnapshot(cr *sonarsourcev1alpha1.SonarQubeBackup, sonarqube *sonarsourcev1alpha1.SonarQube, name string) (string, error) {
+	// Application nodes of a cluster and emptyDir data volumes don't keep data in a claim
+	claim := sonarqube.Status.Storage.Claim
+	if sonarqube.Spec.Cluster != nil || claim == "" {
+		return "", nil
+	}
+
+	snapshot := newVolumeSnapshot()
+	snapshot.SetNamespace(cr.Namespace)
+	snapshot.SetName(name)
+	snapshot.SetLabels(Labels(cr))
+
+	spec := map[string]interface{}{
+		"source": map[string]interface{}{
+			"persistentVolumeClaimName": claim,
+		},
+	}
+	if cr.Spec.VolumeSnapshotClass != nil {
+		spec["volumeSnapshotClassName"] = *cr.Spec.VolumeSnapshotClass
+	}
+	if err := unstructured.SetNestedField(snapshot.Object, spec, "spec"); err != nil {
+		return "", err
+	}
+
+	// Snapshots are not owned by SonarQubeBackup so they aren't garbage collected with it,
+	// only backups beyond retention are removed
+	err := r.client.Create(context.TODO(), snapshot, client.FieldOwner(utils.FieldManager))
+	if err != nil && meta.IsNoMatchError(err) {
+		log.Info("volume snapshots are not available, only the database is backed up", "SonarQubeBackup.Name", cr.Name)
+		return "", nil
+	} else if err != nil && !errors.IsAlreadyExists(err) {
+		return "", err
+	}
+
+	return name, nil
+}
+
+func (r *ReconcileSonarQubeBackup) removeSnapshot(cr *sonarsourcev1alpha1.SonarQubeBackup, name string) error {
+	snapshot := newVolumeSnapshot()
+	snapshot.SetNamespace(cr.Namespace)
+	snapshot.SetName(name)
+
+	err := r.client.Delete(context.TODO(), snapshot)
+	if err != nil && !errors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+		return err
+	}
+
+	return nil
+}
+
+func jobFailed(job *batchv1.Job) bool {
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == batchv1.JobFailed && condition.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+func newVolumeSnapshot() *unstructured.Unstructured {
+	snapshot := &unstructured.Unstructured{}
+	snapshot.SetGroupVersionKind(VolumeSnapshotGVK)
+	return snapshot
+}
@@ -0,0 +1,198 @@
+package sonarquberestore
+
+import (
+	"context"
+	"fmt"
+
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	"github.com/operator-framework/operator-sdk/pkg/status"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+var log = logf.Log.WithName("controller_sonarquberestore")
+
+// Add creates a new SonarQubeRestore Controller and adds it to the Manager. The Manager will set fields on the Controller
+// and Start it when the Manager is Started.
+func Add(mgr manager.Manager) error {
+	return add(mgr, newReconciler(mgr))
+}
+
+// newReconciler returns a new reconcile.Reconciler
+func newReconciler(mgr manager.Manager) reconcile.Reconciler {
+	return &ReconcileSonarQubeRestore{client: mgr.GetClient(), scheme: mgr.GetScheme()}
+}
+
+// add adds a new Controller to mgr with r as the reconcile.Reconciler
+func add(mgr manager.Manager, r reconcile.Reconciler) error {
+	// Create a new controller
+	c, err := controller.New("sonarquberestore-controller", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to primary resource SonarQubeRestore
+	err = c.Watch(&source.Kind{Type: &sonarsourcev1alpha1.SonarQubeRestore{}}, &handler.EnqueueRequestForObject{})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to secondary resource Job and requeue the owner SonarQubeRestore
+	err = c.Watch(&source.Kind{Type: &batchv1.Job{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &sonarsourcev1alpha1.SonarQubeRestore{},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// blank assignment to verify that ReconcileSonarQubeRestore implements reconcile.Reconciler
+var _ reconcile.Reconciler = &ReconcileSonarQubeRestore{}
+
+// ReconcileSonarQubeRestore reconciles a SonarQubeRestore object
+type ReconcileSonarQubeRestore struct {
+	// This client, initialized using mgr.Client() above, is a split client
+	// that reads objects from the cache and writes to the apiserver
+	client client.Client
+	scheme *runtime.Scheme
+}
+
+// Reconcile reads that state of the cluster for a SonarQubeRestore object and makes changes based on the state read
+// and what is in the SonarQubeRestore.Spec
+// The restore runs once, every phase sets its condition so completed phases are not run again
+func (r *ReconcileSonarQubeRestore) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	reqLogger := log.WithValues("Request.Namespace", request.Namespace, "Request.Name", request.Name)
+	reqLogger.Info("Reconciling SonarQubeRestore")
+
+	// Fetch the SonarQubeRestore instance
+	instance := &sonarsourcev1alpha1.SonarQubeRestore{}
+	err := r.client.Get(context.TODO(), request.NamespacedName, instance)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// Request object not found, could have been deleted after reconcile request.
+			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
+			// Return and don't requeue
+			return reconcile.Result{}, nil
+		}
+		// Error reading the object - requeue the request.
+		return reconcile.Result{}, err
+	}
+
+	if instance.Status.Conditions.IsTrueFor(sonarsourcev1alpha1.ConditionRestored) {
+		return reconcile.Result{}, nil
+	}
+
+	sonarqube, err := r.getSonarQube(instance)
+	if err != nil {
+		return utils.ParseErrorForReconcileResult(r.client, instance, err)
+	}
+
+	err = r.StopServer(instance, sonarqube)
+	if err != nil {
+		return utils.ParseErrorForReconcileResult(r.client, instance, err)
+	}
+
+	err = r.RestoreDatabase(instance, sonarqube)
+	if err != nil {
+		return utils.ParseErrorForReconcileResult(r.client, instance, err)
+	}
+
+	err = r.RestoreVolume(instance, sonarqube)
+	if err != nil {
+		return utils.ParseErrorForReconcileResult(r.client, instance, err)
+	}
+
+	err = r.ClearIndices(instance, sonarqube)
+	if err != nil {
+		return utils.ParseErrorForReconcileResult(r.client, instance, err)
+	}
+
+	err = r.StartServer(instance, sonarqube)
+	if err != nil {
+		return utils.ParseErrorForReconcileResult(r.client, instance, err)
+	}
+
+	newStatus := instance.DeepCopy()
+
+	newStatus.Status.Conditions = utils.ClearConditions(newStatus.Status.Conditions)
+
+	utils.UpdateStatus(r.client, newStatus, instance)
+
+	return utils.ParseErrorForReconcileResult(r.client, instance, nil)
+}
+
+// getSonarQube returns the SonarQube resource restored
+// Errors:
+//   ErrorReasonSpecInvalid: returned when the SonarQube resource doesn't exist, has no external database or
+//                           a snapshot is restored to a cluster or a data volume not created by the operator
+//   ErrorReasonUnknown: returned when unhandled error from client occurs
+func (r *ReconcileSonarQubeRestore) getSonarQube(cr *sonarsourcev1alpha1.SonarQubeRestore) (*sonarsourcev1alpha1.SonarQube, error) {
+	sonarqube := &sonarsourcev1alpha1.SonarQube{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: cr.Spec.SonarQube, Namespace: cr.Namespace}, sonarqube)
+	if err != nil && errors.IsNotFound(err) {
+		return nil, &utils.Error{
+			Reason:  utils.ErrorReasonSpecInvalid,
+			Message: fmt.Sprintf("sonarqube %s doesn't exist", cr.Spec.SonarQube),
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	if sonarqube.Spec.Database == nil {
+		return nil, &utils.Error{
+			Reason:  utils.ErrorReasonSpecInvalid,
+			Message: fmt.Sprintf("sonarqube %s must use an external PostgreSQL database to be restored", sonarqube.Name),
+		}
+	}
+
+	// Search nodes of a cluster keep only elasticsearch indices, which are rebuilt
+	if cr.Spec.Snapshot != nil && sonarqube.Spec.Cluster != nil {
+		return nil, &utils.Error{
+			Reason:  utils.ErrorReasonSpecInvalid,
+			Message: fmt.Sprintf("sonarqube %s is a cluster, snapshots can't be restored", sonarqube.Name),
+		}
+	}
+
+	// Snapshots are restored by recreating the claim of the data volume, only claims of the operator are recreated
+	if data := dataVolume(sonarqube); cr.Spec.Snapshot != nil && data != nil && (data.EmptyDir != nil || data.ClaimName != nil) {
+		return nil, &utils.Error{
+			Reason:  utils.ErrorReasonSpecInvalid,
+			Message: fmt.Sprintf("data volume of sonarqube %s isn't a persistentvolumeclaim of the operator, snapshots can't be restored", sonarqube.Name),
+		}
+	}
+
+	return sonarqube, nil
+}
+
+// dataVolume returns the source of the data volume in spec, nil when data is kept in the storage volume
+func dataVolume(sonarqube *sonarsourcev1alpha1.SonarQube) *sonarsourcev1alpha1.VolumeSource {
+	if sonarqube.Spec.NodeConfig.Volumes == nil {
+		return nil
+	}
+	return sonarqube.Spec.NodeConfig.Volumes.Data
+}
+
+// setPhase records a completed phase of the restore in status
+func (r *ReconcileSonarQubeRestore) setPhase(cr *sonarsourcev1alpha1.SonarQubeRestore, phase status.ConditionType, message string) {
+	newStatus := cr.DeepCopy()
+	newStatus.Status.Conditions.SetCondition(status.Condition{
+		Type:    phase,
+		Status:  corev1.ConditionTrue,
+		Message: message,
+	})
+	utils.UpdateStatus(r.client, newStatus, cr)
+}
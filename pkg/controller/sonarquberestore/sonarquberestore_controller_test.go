@@ -0,0 +1,299 @@
+package sonarquberestore
+
+import (
+	"context"
+	"testing"
+
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils/fake"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+// completeJob marks the Job name as succeeded or failed
+func completeJob(t *testing.T, c client.Client, namespace, name string, failed bool) {
+	job := &batchv1.Job{}
+	err := c.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, job)
+	if err != nil {
+		t.Fatalf("get job %s: (%v)", name, err)
+	}
+	if failed {
+		job.Status.Conditions = []batchv1.JobCondition{
+			{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Message: "BackoffLimitExceeded"},
+		}
+	} else {
+		job.Status.Succeeded = 1
+	}
+	err = c.Status().Update(context.TODO(), job)
+	if err != nil {
+		t.Fatalf("update job %s: (%v)", name, err)
+	}
+}
+
+// TestSonarQubeRestoreController runs ReconcileSonarQubeRestore.Reconcile() against a
+// fake client that tracks a SonarQubeRestore object through every phase.
+func TestSonarQubeRestoreController(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name           = "sonarqube-operator"
+		namespace      = "sonarqube"
+		snapshot       = "backup-1"
+		namespacedName = types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		}
+		serverLabels = map[string]string{sonarsourcev1alpha1.ServerTypeLabel: name}
+	)
+
+	// A running SonarQube resource with an external database keeping data in the storage volume.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{
+			Database: &sonarsourcev1alpha1.Database{
+				Host:   &[]string{"postgres"}[0],
+				Secret: "database",
+			},
+		},
+		Status: sonarsourcev1alpha1.SonarQubeStatus{
+			Storage: sonarsourcev1alpha1.StorageStatus{
+				Claim:   name,
+				SubPath: "data",
+			},
+		},
+	}
+	// A SonarQubeRestore resource with metadata and spec.
+	restore := &sonarsourcev1alpha1.SonarQubeRestore{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeRestoreSpec{
+			SonarQube: name,
+			Dump: sonarsourcev1alpha1.DumpLocation{
+				ClaimName: "sonarqube-operator-dumps",
+				Path:      "backup-1.dump",
+			},
+			Snapshot: &snapshot,
+		},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    serverLabels,
+		},
+	}
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    serverLabels,
+		},
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+		restore,
+		pod,
+		pvc,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube, restore, &sonarsourcev1alpha1.SonarQubeRestoreList{})
+	// Create a fake client to mock API calls.
+	cl := fake.NewFakeClient(s, objs...)
+	// Create a ReconcileSonarQubeRestore object with the scheme and fake client.
+	r := &ReconcileSonarQubeRestore{client: cl, scheme: s}
+
+	// Mock request to simulate Reconcile() being called on an event for a
+	// watched resource .
+	req := reconcile.Request{
+		NamespacedName: namespacedName,
+	}
+	reconcileRestore := func() {
+		if _, err := r.Reconcile(req); err != nil {
+			t.Fatalf("reconcile: (%v)", err)
+		}
+		// Decoding into the previous objects would merge maps, start from empty objects
+		restore = &sonarsourcev1alpha1.SonarQubeRestore{}
+		if err := r.client.Get(context.TODO(), namespacedName, restore); err != nil {
+			t.Fatalf("get sonarquberestore: (%v)", err)
+		}
+		sonarqube = &sonarsourcev1alpha1.SonarQube{}
+		if err := r.client.Get(context.TODO(), namespacedName, sonarqube); err != nil {
+			t.Fatalf("get sonarqube: (%v)", err)
+		}
+	}
+
+	reconcileRestore()
+	if sonarqube.Spec.Shutdown == nil || !*sonarqube.Spec.Shutdown {
+		t.Error("reconcile: sonarqube not shut down")
+	}
+	if restore.Status.Shutdown == nil || *restore.Status.Shutdown {
+		t.Error("reconcile: shutdown of sonarqube not recorded")
+	}
+
+	reconcileRestore()
+	if restore.Status.Conditions.IsTrueFor(sonarsourcev1alpha1.ConditionServerStopped) {
+		t.Error("reconcile: server stopped while pods are running")
+	}
+
+	if err := r.client.Delete(context.TODO(), pod); err != nil {
+		t.Fatalf("delete pod: (%v)", err)
+	}
+	reconcileRestore()
+	if !restore.Status.Conditions.IsTrueFor(sonarsourcev1alpha1.ConditionServerStopped) {
+		t.Error("reconcile: server stopped not set")
+	}
+
+	completeJob(t, r.client, namespace, name+"-database", false)
+	reconcileRestore()
+	if !restore.Status.Conditions.IsTrueFor(sonarsourcev1alpha1.ConditionDatabaseRestored) {
+		t.Error("reconcile: database restored not set")
+	}
+	if sonarqube.Annotations[sonarsourcev1alpha1.RestoreSnapshotAnnotation] != snapshot {
+		t.Error("reconcile: snapshot not set on sonarqube")
+	}
+
+	reconcileRestore()
+	if restore.Status.Conditions.IsTrueFor(sonarsourcev1alpha1.ConditionVolumeRestored) {
+		t.Error("reconcile: volume restored before pvc was replaced")
+	}
+
+	// The SonarQube controller replaces the claim
+	if err := r.client.Delete(context.TODO(), pvc); err != nil {
+		t.Fatalf("delete pvc: (%v)", err)
+	}
+	pvc = pvc.DeepCopy()
+	pvc.ResourceVersion = ""
+	pvc.Spec.DataSource = &corev1.TypedLocalObjectReference{Kind: "VolumeSnapshot", Name: snapshot}
+	if err := r.client.Create(context.TODO(), pvc); err != nil {
+		t.Fatalf("create pvc: (%v)", err)
+	}
+	reconcileRestore()
+	if !restore.Status.Conditions.IsTrueFor(sonarsourcev1alpha1.ConditionVolumeRestored) {
+		t.Error("reconcile: volume restored not set")
+	}
+
+	job := &batchv1.Job{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: name + "-indices-0", Namespace: namespace}, job); err != nil {
+		t.Fatalf("reconcile: indices job not created (%v)", err)
+	}
+	if job.Spec.Template.Spec.Volumes[0].PersistentVolumeClaim.ClaimName != name || job.Spec.Template.Spec.Containers[0].VolumeMounts[0].SubPath != "data" {
+		t.Error("reconcile: indices job doesn't mount the data volume of sonarqube")
+	}
+
+	completeJob(t, r.client, namespace, name+"-indices-0", false)
+	reconcileRestore()
+	if !restore.Status.Conditions.IsTrueFor(sonarsourcev1alpha1.ConditionIndicesCleared) {
+		t.Error("reconcile: indices cleared not set")
+	}
+	if !restore.Status.Conditions.IsTrueFor(sonarsourcev1alpha1.ConditionRestored) || restore.Status.CompletionTime == nil {
+		t.Error("reconcile: restored not set")
+	}
+	if sonarqube.Spec.Shutdown == nil || *sonarqube.Spec.Shutdown {
+		t.Error("reconcile: sonarqube not started after restore")
+	}
+	if _, ok := sonarqube.Annotations[sonarsourcev1alpha1.RestoreSnapshotAnnotation]; ok {
+		t.Error("reconcile: snapshot not removed from sonarqube")
+	}
+}
+
+// TestSonarQubeRestoreControllerFailed runs ReconcileSonarQubeRestore.Reconcile() against a
+// fake client with a failing database restore.
+func TestSonarQubeRestoreControllerFailed(t *testing.T) {
+	// Set the logger to development mode for verbose logs.
+	logf.SetLogger(logf.ZapLogger(true))
+
+	var (
+		name           = "sonarqube-operator"
+		namespace      = "sonarqube"
+		namespacedName = types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		}
+	)
+
+	// A shut down SonarQube resource with an external database.
+	sonarqube := &sonarsourcev1alpha1.SonarQube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeSpec{
+			Shutdown: &[]bool{true}[0],
+			Database: &sonarsourcev1alpha1.Database{
+				Host:   &[]string{"postgres"}[0],
+				Secret: "database",
+			},
+		},
+	}
+	// A SonarQubeRestore resource with metadata and spec.
+	restore := &sonarsourcev1alpha1.SonarQubeRestore{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sonarsourcev1alpha1.SonarQubeRestoreSpec{
+			SonarQube: name,
+			Dump: sonarsourcev1alpha1.DumpLocation{
+				ClaimName: "sonarqube-operator-dumps",
+				Path:      "backup-1.dump",
+			},
+		},
+	}
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		sonarqube,
+		restore,
+	}
+
+	// Register operator types with the runtime scheme.
+	s := scheme.Scheme
+	s.AddKnownTypes(sonarsourcev1alpha1.SchemeGroupVersion, sonarqube, restore, &sonarsourcev1alpha1.SonarQubeRestoreList{})
+	// Create a fake client to mock API calls.
+	cl := fake.NewFakeClient(s, objs...)
+	// Create a ReconcileSonarQubeRestore object with the scheme and fake client.
+	r := &ReconcileSonarQubeRestore{client: cl, scheme: s}
+
+	req := reconcile.Request{
+		NamespacedName: namespacedName,
+	}
+
+	_, err := r.Reconcile(req)
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+	completeJob(t, r.client, namespace, name+"-database", true)
+
+	_, err = r.Reconcile(req)
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+	err = r.client.Get(context.TODO(), namespacedName, restore)
+	if err != nil {
+		t.Fatalf("get sonarquberestore: (%v)", err)
+	}
+	if !restore.Status.Conditions.IsTrueFor(sonarsourcev1alpha1.ConditionInvalid) {
+		t.Error("reconcile: condition invalid not set when database restore failed")
+	}
+	if restore.Status.Conditions.IsTrueFor(sonarsourcev1alpha1.ConditionDatabaseRestored) {
+		t.Error("reconcile: database restored set when database restore failed")
+	}
+	if restore.Status.Shutdown == nil || !*restore.Status.Shutdown {
+		t.Error("reconcile: shutdown of sonarqube not recorded")
+	}
+}
@@ -0,0 +1,303 @@
+package sonarquberestore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	"github.com/jlfowle/sonarqube-operator/version"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	DefaultImage    string = "postgres:12"
+	VolumePathDumps string = "/backups"
+	VolumePathData  string = "/data"
+)
+
+// restoreScript restores the dump in a single transaction so a failed restore leaves the database as it was
+const restoreScript = `set -e
+pg_restore --clean --if-exists --no-owner --no-privileges --single-transaction --dbname="$DATABASE_URL" "$DUMP_PATH/$DUMP"
+`
+
+// indicesScript removes the elasticsearch indices, SonarQube rebuilds them from the database on startup
+const indicesScript = `set -e
+rm -rf "$DATA_PATH"/es*
+`
+
+// Restores the database dump of SonarQubeRestore with a Job
+// Returns: Error
+// If Error is nil, the database is restored
+// Errors:
+//   ErrorReasonSpecInvalid: returned when the database of SonarQube is not a PostgreSQL database
+//   ErrorReasonResourceCreate: returned when the Job does not exists
+//   ErrorReasonResourceWaiting: returned while the Job is running
+//   ErrorReasonResourceInvalid: returned when the Job failed
+//   ErrorReasonUnknown: returned when unhandled error from client occurs
+func (r *ReconcileSonarQubeRestore) RestoreDatabase(cr *sonarsourcev1alpha1.SonarQubeRestore, sonarqube *sonarsourcev1alpha1.SonarQube) error {
+	if cr.Status.Conditions.IsTrueFor(sonarsourcev1alpha1.ConditionDatabaseRestored) {
+		return nil
+	}
+
+	env, err := utils.GetPostgreSQLEnv(sonarqube.Spec.Database)
+	if err != nil {
+		return err
+	}
+
+	env = append(env,
+		corev1.EnvVar{
+			Name:  "DUMP_PATH",
+			Value: VolumePathDumps,
+		},
+		corev1.EnvVar{
+			Name:  "DUMP",
+			Value: cr.Spec.Dump.Path,
+		},
+	)
+
+	job, err := r.newJob(cr, fmt.Sprintf("%s-database", cr.Name), "pg-restore", restoreScript, env, corev1.VolumeMount{
+		Name:      "dumps",
+		MountPath: VolumePathDumps,
+		ReadOnly:  true,
+	}, cr.Spec.Dump.ClaimName)
+	if err != nil {
+		return err
+	}
+
+	foundJob := &batchv1.Job{}
+	if err := utils.ApplyResource(r.client, r.scheme, job, foundJob, ""); err != nil {
+		return err
+	}
+	if err := verifyJob(foundJob); err != nil {
+		return err
+	}
+
+	r.setPhase(cr, sonarsourcev1alpha1.ConditionDatabaseRestored, fmt.Sprintf("restored database from %s in %s", cr.Spec.Dump.Path, cr.Spec.Dump.ClaimName))
+
+	return nil
+}
+
+// Recreates the volume of SonarQube from the snapshot of SonarQubeRestore.
+// The SonarQube controller replaces the claim of a shut down SonarQube annotated with the snapshot
+// Returns: Error
+// If Error is nil, the claim of the SonarQube data volume is restored from the snapshot
+// Errors:
+//   ErrorReasonSpecUpdate: returned when SonarQube was annotated with the snapshot
+//   ErrorReasonResourceWaiting: returned while the claim is not restored from the snapshot
+//   ErrorReasonUnknown: returned when unhandled error from client occurs
+func (r *ReconcileSonarQubeRestore) RestoreVolume(cr *sonarsourcev1alpha1.SonarQubeRestore, sonarqube *sonarsourcev1alpha1.SonarQube) error {
+	if cr.Spec.Snapshot == nil || cr.Status.Conditions.IsTrueFor(sonarsourcev1alpha1.ConditionVolumeRestored) {
+		return nil
+	}
+	snapshot := *cr.Spec.Snapshot
+
+	if sonarqube.Annotations[sonarsourcev1alpha1.RestoreSnapshotAnnotation] != snapshot {
+		if sonarqube.Annotations == nil {
+			sonarqube.Annotations = make(map[string]string)
+		}
+		sonarqube.Annotations[sonarsourcev1alpha1.RestoreSnapshotAnnotation] = snapshot
+		if err := r.client.Update(context.TODO(), sonarqube); err != nil {
+			return err
+		}
+		return &utils.Error{
+			Reason:  utils.ErrorReasonSpecUpdate,
+			Message: fmt.Sprintf("restoring snapshot %s to sonarqube %s", snapshot, sonarqube.Name),
+		}
+	}
+
+	claim := sonarqube.Status.Storage.Claim
+	pvc := &corev1.PersistentVolumeClaim{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: claim, Namespace: sonarqube.Namespace}, pvc)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if claim == "" || err != nil || pvc.DeletionTimestamp != nil || pvc.Spec.DataSource == nil || pvc.Spec.DataSource.Name != snapshot {
+		return &utils.Error{
+			Reason:  utils.ErrorReasonResourceWaiting,
+			Message: fmt.Sprintf("waiting for the data volume of sonarqube %s to be restored from snapshot %s", sonarqube.Name, snapshot),
+		}
+	}
+
+	r.setPhase(cr, sonarsourcev1alpha1.ConditionVolumeRestored, fmt.Sprintf("restored persistentvolumeclaim %s from snapshot %s", pvc.Name, snapshot))
+
+	return nil
+}
+
+// Removes the elasticsearch indices from the data volume and the volumes of search nodes of SonarQube with Jobs
+// so they are rebuilt from the restored database
+// Returns: Error
+// If Error is nil, the indices are removed
+// Errors:
+//   ErrorReasonResourceCreate: returned when a Job does not exists
+//   ErrorReasonResourceWaiting: returned while the claims of SonarQube don't exist or a Job is running
+//   ErrorReasonResourceInvalid: returned when a Job failed
+//   ErrorReasonUnknown: returned when unhandled error from client occurs
+func (r *ReconcileSonarQubeRestore) ClearIndices(cr *sonarsourcev1alpha1.SonarQubeRestore, sonarqube *sonarsourcev1alpha1.SonarQube) error {
+	if cr.Status.Conditions.IsTrueFor(sonarsourcev1alpha1.ConditionIndicesCleared) {
+		return nil
+	}
+
+	var claims []indicesClaim
+	if sonarqube.Status.Storage.Claim != "" {
+		claims = append(claims, indicesClaim{name: sonarqube.Status.Storage.Claim, subPath: sonarqube.Status.Storage.SubPath})
+	}
+
+	pvcs := &corev1.PersistentVolumeClaimList{}
+	err := r.client.List(context.TODO(), pvcs, client.InNamespace(sonarqube.Namespace), client.MatchingLabels{
+		sonarsourcev1alpha1.ServerTypeLabel:  sonarqube.Name,
+		sonarsourcev1alpha1.KubeAppComponent: string(sonarsourcev1alpha1.Search),
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(pvcs.Items, func(i, j int) bool {
+		return pvcs.Items[i].Name < pvcs.Items[j].Name
+	})
+	for _, pvc := range pvcs.Items {
+		claims = append(claims, indicesClaim{name: pvc.Name, subPath: "data"})
+	}
+
+	// An emptyDir data volume loses its indices when SonarQube stops
+	if data := dataVolume(sonarqube); len(claims) == 0 && (data == nil || data.EmptyDir == nil) {
+		return &utils.Error{
+			Reason:  utils.ErrorReasonResourceWaiting,
+			Message: fmt.Sprintf("waiting for persistentvolumeclaims of sonarqube %s", sonarqube.Name),
+		}
+	}
+
+	env := []corev1.EnvVar{
+		{
+			Name:  "DATA_PATH",
+			Value: VolumePathData,
+		},
+	}
+
+	for i, claim := range claims {
+		job, err := r.newJob(cr, fmt.Sprintf("%s-indices-%d", cr.Name, i), "clear-indices", indicesScript, env, corev1.VolumeMount{
+			Name:      "storage",
+			MountPath: VolumePathData,
+			SubPath:   claim.subPath,
+		}, claim.name)
+		if err != nil {
+			return err
+		}
+
+		foundJob := &batchv1.Job{}
+		if err := utils.ApplyResource(r.client, r.scheme, job, foundJob, ""); err != nil {
+			return err
+		}
+		if err := verifyJob(foundJob); err != nil {
+			return err
+		}
+	}
+
+	r.setPhase(cr, sonarsourcev1alpha1.ConditionIndicesCleared, fmt.Sprintf("removed elasticsearch indices of %d persistentvolumeclaims", len(claims)))
+
+	return nil
+}
+
+// indicesClaim is a PersistentVolumeClaim keeping elasticsearch indices in the data volume at subPath
+type indicesClaim struct {
+	name    string
+	subPath string
+}
+
+// newJob returns a Job running script in the restore image with claimName mounted by mount
+func (r *ReconcileSonarQubeRestore) newJob(cr *sonarsourcev1alpha1.SonarQubeRestore, name, container, script string, env []corev1.EnvVar, mount corev1.VolumeMount, claimName string) (*batchv1.Job, error) {
+	labels := Labels(cr)
+
+	image := DefaultImage
+	if cr.Spec.Image != nil {
+		image = *cr.Spec.Image
+	}
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: cr.Namespace,
+			Name:      name,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &[]int32{2}[0],
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyOnFailure,
+					Containers: []corev1.Container{
+						{
+							Name:         container,
+							Image:        image,
+							Command:      []string{"sh", "-c", script},
+							Env:          env,
+							VolumeMounts: []corev1.VolumeMount{mount},
+						},
+					},
+					Volumes: []corev1.Volume{
+						{
+							Name: mount.Name,
+							VolumeSource: corev1.VolumeSource{
+								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+									ClaimName: claimName,
+									ReadOnly:  mount.ReadOnly,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if err := controllerutil.SetControllerReference(cr, job, r.scheme); err != nil {
+		return job, err
+	}
+
+	return job, nil
+}
+
+// verifyJob returns an error until job succeeded
+func verifyJob(job *batchv1.Job) error {
+	if job.Status.Succeeded > 0 {
+		return nil
+	}
+
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == batchv1.JobFailed && condition.Status == corev1.ConditionTrue {
+			return &utils.Error{
+				Reason:  utils.ErrorReasonResourceInvalid,
+				Message: fmt.Sprintf("job %s failed: %s", job.Name, condition.Message),
+			}
+		}
+	}
+
+	return &utils.Error{
+		Reason:  utils.ErrorReasonResourceWaiting,
+		Message: fmt.Sprintf("waiting for job %s", job.Name),
+	}
+}
+
+// Labels of the Jobs of SonarQubeRestore
+func Labels(cr *sonarsourcev1alpha1.SonarQubeRestore) map[string]string {
+	labels := make(map[string]string)
+
+	for k, v := range cr.Labels {
+		labels[k] = v
+	}
+
+	labels[sonarsourcev1alpha1.KubeAppName] = "SonarQube"
+	labels[sonarsourcev1alpha1.KubeAppInstance] = cr.Spec.SonarQube
+	labels[sonarsourcev1alpha1.KubeAppComponent] = "restore"
+	labels[sonarsourcev1alpha1.KubeAppManagedby] = fmt.Sprintf("sonarqube-operator.v%s", version.Version)
+
+	return labels
+}
@@ -0,0 +1,88 @@
+package sonarquberestore
+
+import (
+	"context"
+	"fmt"
+
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	"github.com/jlfowle/sonarqube-operator/pkg/utils"
+	"github.com/operator-framework/operator-sdk/pkg/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Shuts down SonarQube before the database and volume are restored
+// Returns: Error
+// If Error is nil, SonarQube has no running pods
+// Errors:
+//   ErrorReasonSpecUpdate: returned when SonarQube was shut down
+//   ErrorReasonResourceWaiting: returned while pods of SonarQube are running
+//   ErrorReasonUnknown: returned when unhandled error from client occurs
+func (r *ReconcileSonarQubeRestore) StopServer(cr *sonarsourcev1alpha1.SonarQubeRestore, sonarqube *sonarsourcev1alpha1.SonarQube) error {
+	if cr.Status.Conditions.IsTrueFor(sonarsourcev1alpha1.ConditionServerStopped) {
+		return nil
+	}
+
+	shutdown := sonarqube.Spec.Shutdown != nil && *sonarqube.Spec.Shutdown
+
+	// The shutdown of SonarQube is recorded before it is changed so the restore sets it back
+	if cr.Status.Shutdown == nil {
+		newStatus := cr.DeepCopy()
+		newStatus.Status.Shutdown = &shutdown
+		utils.UpdateStatus(r.client, newStatus, cr)
+	}
+
+	if !shutdown {
+		sonarqube.Spec.Shutdown = &[]bool{true}[0]
+		if err := r.client.Update(context.TODO(), sonarqube); err != nil {
+			return err
+		}
+		return &utils.Error{
+			Reason:  utils.ErrorReasonSpecUpdate,
+			Message: fmt.Sprintf("shut down sonarqube %s", sonarqube.Name),
+		}
+	}
+
+	pods := &corev1.PodList{}
+	err := r.client.List(context.TODO(), pods, client.InNamespace(sonarqube.Namespace), client.MatchingLabels{sonarsourcev1alpha1.ServerTypeLabel: sonarqube.Name})
+	if err != nil {
+		return err
+	}
+	if len(pods.Items) > 0 {
+		return &utils.Error{
+			Reason:  utils.ErrorReasonResourceWaiting,
+			Message: fmt.Sprintf("waiting for %d pods of sonarqube %s to stop", len(pods.Items), sonarqube.Name),
+		}
+	}
+
+	r.setPhase(cr, sonarsourcev1alpha1.ConditionServerStopped, fmt.Sprintf("sonarqube %s stopped", sonarqube.Name))
+
+	return nil
+}
+
+// Starts SonarQube again once the restore completed, the shutdown recorded before the restore is set back
+// Returns: Error
+// Errors:
+//   ErrorReasonUnknown: returned when unhandled error from client occurs
+func (r *ReconcileSonarQubeRestore) StartServer(cr *sonarsourcev1alpha1.SonarQubeRestore, sonarqube *sonarsourcev1alpha1.SonarQube) error {
+	shutdown := cr.Status.Shutdown != nil && *cr.Status.Shutdown
+
+	sonarqube.Spec.Shutdown = &shutdown
+	delete(sonarqube.Annotations, sonarsourcev1alpha1.RestoreSnapshotAnnotation)
+	if err := r.client.Update(context.TODO(), sonarqube); err != nil {
+		return err
+	}
+
+	completionTime := metav1.Now()
+	newStatus := cr.DeepCopy()
+	newStatus.Status.CompletionTime = &completionTime
+	newStatus.Status.Conditions.SetCondition(status.Condition{
+		Type:    sonarsourcev1alpha1.ConditionRestored,
+		Status:  corev1.ConditionTrue,
+		Message: fmt.Sprintf("sonarqube %s restored", sonarqube.Name),
+	})
+	utils.UpdateStatus(r.client, newStatus, cr)
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	sonarsourcev1alpha1 "github.com/jlfowle/sonarqube-operator/pkg/apis/sonarsource/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	DatabaseDefaultPort int32  = 5432
+	DatabaseDefaultName string = "sonarqube"
+)
+
+// GetJDBCURL returns the url from spec or builds a PostgreSQL url from host, port, and name
+func GetJDBCURL(database *sonarsourcev1alpha1.Database) (string, error) {
+	if database.URL != nil && *database.URL != "" {
+		return *database.URL, nil
+	}
+
+	if database.Host == nil || *database.Host == "" {
+		return "", &Error{
+			Reason:  ErrorReasonSpecInvalid,
+			Message: "database must have url or host",
+		}
+	}
+
+	port := DatabaseDefaultPort
+	if database.Port != nil {
+		port = *database.Port
+	}
+
+	name := DatabaseDefaultName
+	if database.Name != nil && *database.Name != "" {
+		name = *database.Name
+	}
+
+	return fmt.Sprintf("jdbc:postgresql://%s:%d/%s", *database.Host, port, name), nil
+}
+
+// GetPostgreSQLURL returns the connection uri of the database for PostgreSQL client tools
+func GetPostgreSQLURL(database *sonarsourcev1alpha1.Database) (string, error) {
+	url, err := GetJDBCURL(database)
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.HasPrefix(url, "jdbc:postgresql://") {
+		return "", &Error{
+			Reason:  ErrorReasonSpecInvalid,
+			Message: fmt.Sprintf("database %s is not a PostgreSQL database", url),
+		}
+	}
+
+	// JDBC parameters aren't understood by libpq
+	url = strings.SplitN(url, "?", 2)[0]
+
+	return strings.TrimPrefix(url, "jdbc:"), nil
+}
+
+// GetPostgreSQLEnv returns the environment of PostgreSQL client tools connecting to the database,
+// DATABASE_URL holds the connection uri and credentials are read from the database secret
+func GetPostgreSQLEnv(database *sonarsourcev1alpha1.Database) ([]corev1.EnvVar, error) {
+	url, err := GetPostgreSQLURL(database)
+	if err != nil {
+		return nil, err
+	}
+
+	return []corev1.EnvVar{
+		{
+			Name:  "DATABASE_URL",
+			Value: url,
+		},
+		{
+			Name: "PGUSER",
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: database.Secret},
+					Key:                  sonarsourcev1alpha1.DatabaseSecretUsername,
+				},
+			},
+		},
+		{
+			Name: "PGPASSWORD",
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: database.Secret},
+					Key:                  sonarsourcev1alpha1.DatabaseSecretPassword,
+				},
+			},
+		},
+	}, nil
+}
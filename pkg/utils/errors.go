@@ -16,6 +16,7 @@ const (
 	ErrorReasonResourceShutdown ErrorType = "ResourceShutdown"
 	ErrorReasonServerWaiting    ErrorType = "ServerWaiting"
 	ErrorReasonServerDown       ErrorType = "ServerDown"
+	ErrorReasonServerAuth       ErrorType = "ServerAuth"
 	ErrorReasonUnknown          ErrorType = "Unknown"
 )
 
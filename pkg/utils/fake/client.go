@@ -0,0 +1,72 @@
+package fake
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// applyClient converts server-side apply patches, which the fake client doesn't support, to strategic merge patches.
+// Patches that don't change the object are skipped like the api server does so the resource version only changes on updates
+type applyClient struct {
+	client.Client
+}
+
+// NewFakeClient returns a fake client supporting server-side apply for tests of the controllers
+func NewFakeClient(s *runtime.Scheme, objs ...runtime.Object) client.Client {
+	return &applyClient{Client: fake.NewFakeClientWithScheme(s, objs...)}
+}
+
+func (c *applyClient) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if patch.Type() != types.ApplyPatchType {
+		return c.Client.Patch(ctx, obj, patch, opts...)
+	}
+
+	data, err := patch.Data(obj)
+	if err != nil {
+		return err
+	}
+	// The api server ignores status in patches to the main resource
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	delete(fields, "status")
+	if data, err = json.Marshal(fields); err != nil {
+		return err
+	}
+
+	metaObject := obj.(metav1.Object)
+	// Decoding into a copy of obj would merge maps, start from empty objects
+	current := reflect.New(reflect.TypeOf(obj).Elem()).Interface().(runtime.Object)
+	err = c.Get(ctx, types.NamespacedName{Name: metaObject.GetName(), Namespace: metaObject.GetNamespace()}, current)
+	if err != nil {
+		return err
+	}
+	original, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	modified, err := strategicpatch.StrategicMergePatch(original, data, current)
+	if err != nil {
+		return err
+	}
+	updated := reflect.New(reflect.TypeOf(obj).Elem()).Interface().(runtime.Object)
+	if err := json.Unmarshal(modified, updated); err != nil {
+		return err
+	}
+	if equality.Semantic.DeepEqual(current, updated) {
+		reflect.ValueOf(obj).Elem().Set(reflect.ValueOf(current).Elem())
+		return nil
+	}
+
+	return c.Client.Patch(ctx, obj, client.RawPatch(types.StrategicMergePatchType, data))
+}
@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// ReconcileResultSucceeded labels reconciliations that ended without error
+	ReconcileResultSucceeded string = "Succeeded"
+)
+
+// ReconcileResults counts the outcomes of reconciliations by kind and the reason of the returned Error
+var ReconcileResults = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "sonarqube_operator_reconcile_total",
+		Help: "Reconciliations by kind and result reason",
+	},
+	[]string{"kind", "reason"},
+)
+
+func init() {
+	// Metrics in the controller-runtime registry are served by the manager
+	metrics.Registry.MustRegister(ReconcileResults)
+}
+
+// observeReconcile counts a reconciliation of kind ending with err
+func observeReconcile(kind string, err error) {
+	reason := ReconcileResultSucceeded
+	if err != nil {
+		reason = string(ReasonForError(err))
+	}
+	ReconcileResults.WithLabelValues(kind, reason).Inc()
+}
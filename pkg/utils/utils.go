@@ -2,9 +2,12 @@ package utils
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/sha1"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"os"
 	"reflect"
 	"strings"
 	"time"
@@ -19,6 +22,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
@@ -26,6 +30,12 @@ import (
 
 const (
 	DefaultImage = "sonarqube"
+	// ImageRegistryEnvVar is the environment variable of the operator with the registry SonarQube images are pulled from
+	ImageRegistryEnvVar = "SONARQUBE_IMAGE_REGISTRY"
+	// DefaultTLSProxyImage is the image of the sidecar terminating TLS in front of SonarQube
+	DefaultTLSProxyImage = "ghostunnel/ghostunnel:v1.5.3"
+	// TLSProxyImageEnvVar is the environment variable of the operator with the image of the TLS proxy sidecar
+	TLSProxyImageEnvVar = "SONARQUBE_TLS_PROXY_IMAGE"
 )
 
 var log = logf.Log.WithName("controller_sonarqube")
@@ -80,32 +90,83 @@ func UpdateResource(client client.Writer, object runtime.Object, reason ErrorTyp
 	}
 }
 
-func CreateResourceIfNotFound(client client.Client, object, output runtime.Object) error {
+// FieldManager is the server-side apply field manager of the operator
+const FieldManager = "sonarqube-operator"
+
+// ApplyResource creates object when it doesn't exist or server-side applies it so the operator only owns the fields it sets.
+// output is filled with the object found in the cluster. Objects controlled by another owner are left as they are.
+// Data of secrets is only written on creation, after that it belongs to users.
+// Errors:
+//   ErrorReasonResourceCreate: returned when object does not exists
+//   ErrorReasonResourceUpdate: returned when applying changed the object, message is used when set
+//   ErrorReasonUnknown: returned when unhandled error from client occurs
+func ApplyResource(c client.Client, scheme *runtime.Scheme, object, output runtime.Object, message string) error {
 	metaObject := object.(metav1.Object)
-	err := client.Get(context.TODO(), types.NamespacedName{Name: metaObject.GetName(), Namespace: metaObject.GetNamespace()}, output)
+	gvk, err := apiutil.GVKForObject(object, scheme)
+	if err != nil {
+		return err
+	}
+	object.GetObjectKind().SetGroupVersionKind(gvk)
+
+	err = c.Get(context.TODO(), types.NamespacedName{Name: metaObject.GetName(), Namespace: metaObject.GetNamespace()}, output)
 	if err != nil && errors.IsNotFound(err) {
-		err := client.Create(context.TODO(), object)
+		err := c.Create(context.TODO(), object, client.FieldOwner(FieldManager))
 		if err != nil {
 			return err
 		}
 		return &Error{
 			Reason:  ErrorReasonResourceCreate,
-			Message: fmt.Sprintf("created %s %s", object.GetObjectKind().GroupVersionKind().Kind, metaObject.GetName()),
+			Message: fmt.Sprintf("created %s %s", gvk.Kind, metaObject.GetName()),
 		}
 	} else if err != nil {
 		return err
 	}
 
-	return nil
+	outputMeta := output.(metav1.Object)
+	if controller := metav1.GetControllerOf(metaObject); controller != nil {
+		if found := metav1.GetControllerOf(outputMeta); found == nil || found.UID != controller.UID {
+			return nil
+		}
+	}
+
+	if secret, ok := object.(*corev1.Secret); ok {
+		secret = secret.DeepCopy()
+		secret.Data = nil
+		secret.StringData = nil
+		object = secret
+	}
+
+	resourceVersion := outputMeta.GetResourceVersion()
+	err = c.Patch(context.TODO(), object, client.Apply, client.ForceOwnership, client.FieldOwner(FieldManager))
+	if err != nil {
+		return err
+	}
+
+	// The patched object returned by the api server replaces the found object
+	reflect.ValueOf(output).Elem().Set(reflect.ValueOf(object).Elem())
+	if outputMeta.GetResourceVersion() == resourceVersion {
+		return nil
+	}
+
+	if message == "" {
+		message = fmt.Sprintf("updated %s %s", strings.ToLower(gvk.Kind), metaObject.GetName())
+	}
+	return &Error{
+		Reason:  ErrorReasonResourceUpdate,
+		Message: message,
+	}
 }
 
 func ClearConditions(conditions status.Conditions) status.Conditions {
 	var cList []status.ConditionType
+conditionLoop:
 	for _, c := range conditions {
 		// Filter out excluded condition types
-		for _, e := range []status.ConditionType{sonarsourcev1alpha1.ConditionUnavailable} {
+		for _, e := range []status.ConditionType{sonarsourcev1alpha1.ConditionUnavailable, sonarsourcev1alpha1.ConditionUpgrading, sonarsourcev1alpha1.ConditionMigrating, sonarsourcev1alpha1.ConditionPluginsMismatched,
+			sonarsourcev1alpha1.ConditionServerStopped, sonarsourcev1alpha1.ConditionDatabaseRestored, sonarsourcev1alpha1.ConditionVolumeRestored,
+			sonarsourcev1alpha1.ConditionIndicesCleared, sonarsourcev1alpha1.ConditionRestored} {
 			if e == c.Type {
-				break
+				continue conditionLoop
 			}
 		}
 		cList = append(cList, c.Type)
@@ -127,10 +188,19 @@ func ParseErrorForReconcileResult(client client.Client, object interface{}, err
 	reqLogger := log.WithValues("SonarQube.Namespace", objectMeta.GetNamespace(), "SonarQube.Name", objectMeta.GetName())
 	newStatus := objectRuntime.DeepCopyObject()
 	var statusConditions *status.Conditions
+	var kind string
 	switch t := newStatus.(type) {
 	case *sonarsourcev1alpha1.SonarQube:
 		statusConditions = &t.Status.Conditions
+		kind = "SonarQube"
+	case *sonarsourcev1alpha1.SonarQubeBackup:
+		statusConditions = &t.Status.Conditions
+		kind = "SonarQubeBackup"
+	case *sonarsourcev1alpha1.SonarQubeRestore:
+		statusConditions = &t.Status.Conditions
+		kind = "SonarQubeRestore"
 	}
+	observeReconcile(kind, err)
 
 	if statusConditions == nil {
 		statusConditions = &status.Conditions{}
@@ -164,7 +234,7 @@ func ParseErrorForReconcileResult(client client.Client, object interface{}, err
 			default:
 				return reconcile.Result{Requeue: true}, nil
 			}
-		case ErrorReasonSpecInvalid, ErrorReasonResourceInvalid:
+		case ErrorReasonSpecInvalid, ErrorReasonResourceInvalid, ErrorReasonServerAuth:
 			*statusConditions = ClearConditions(*statusConditions)
 			var reason status.ConditionReason
 			switch sqErr.Type() {
@@ -172,6 +242,8 @@ func ParseErrorForReconcileResult(client client.Client, object interface{}, err
 				reason = sonarsourcev1alpha1.ConditionSpecInvalid
 			case ErrorReasonResourceInvalid:
 				reason = sonarsourcev1alpha1.ConditionReasourcesInvalid
+			case ErrorReasonServerAuth:
+				reason = sonarsourcev1alpha1.ConditionAuthenticationFailed
 			}
 			statusConditions.SetCondition(status.Condition{
 				Type:    sonarsourcev1alpha1.ConditionInvalid,
@@ -222,6 +294,18 @@ func UpdateStatus(client client.Client, newObject interface{}, object interface{
 			t.Status = *newSonarQube.Status.DeepCopy()
 			requiresUpdate = true
 		}
+	case *sonarsourcev1alpha1.SonarQubeBackup:
+		newBackup := newObject.(*sonarsourcev1alpha1.SonarQubeBackup)
+		if !reflect.DeepEqual(newBackup.Status, t.Status) {
+			t.Status = *newBackup.Status.DeepCopy()
+			requiresUpdate = true
+		}
+	case *sonarsourcev1alpha1.SonarQubeRestore:
+		newRestore := newObject.(*sonarsourcev1alpha1.SonarQubeRestore)
+		if !reflect.DeepEqual(newRestore.Status, t.Status) {
+			t.Status = *newRestore.Status.DeepCopy()
+			requiresUpdate = true
+		}
 	}
 	reqLogger := log.WithValues("SonarQube.Namespace", objectMetav1.GetNamespace(), "SonarQube.Name", objectMetav1.GetName())
 
@@ -272,20 +356,44 @@ func GenVersion(spec interface{}, secret []byte) (string, error) {
 	return fmt.Sprintf("%x", h.Sum(nil)), nil
 }
 
+func GenPassword(length int) (string, error) {
+	b := make([]byte, length)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)[:length], nil
+}
+
 func GetImage(edition, version *string) string {
-	var sqImage, sqEdition string
+	return fmt.Sprintf("%s:%s", GetImageRepository(), GetImageTag(edition, version))
+}
+
+// GetImageRepository returns the SonarQube image repository in the registry set for the operator, Docker Hub when unset
+func GetImageRepository() string {
+	if registry := strings.TrimSuffix(os.Getenv(ImageRegistryEnvVar), "/"); registry != "" {
+		return fmt.Sprintf("%s/%s", registry, DefaultImage)
+	}
+	return DefaultImage
+}
+
+// GetTLSProxyImage returns the image of the TLS proxy sidecar set for the operator, the default image when unset
+func GetTLSProxyImage() string {
+	if image := os.Getenv(TLSProxyImageEnvVar); image != "" {
+		return image
+	}
+	return DefaultTLSProxyImage
+}
 
+// GetImageTag returns the tag of the SonarQube image of edition and version
+func GetImageTag(edition, version *string) string {
+	sqEdition := "community"
 	if edition != nil {
 		sqEdition = *edition
-	} else {
-		sqEdition = "community"
 	}
 
 	if version != nil {
-		sqImage = fmt.Sprintf("%s:%s-%s", DefaultImage, *version, sqEdition)
-	} else {
-		sqImage = fmt.Sprintf("%s:%s", DefaultImage, sqEdition)
+		return fmt.Sprintf("%s-%s", *version, sqEdition)
 	}
-
-	return sqImage
+	return sqEdition
 }